@@ -0,0 +1,80 @@
+package attest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// messageMagic is prepended to messages by the legacy signmessage format
+const messageMagic = "Bitcoin Signed Message:\n"
+
+// Message binds an injection transaction to the hash of the data it holds
+func Message(txid string, data []byte) string {
+	hash := sha256.Sum256(data)
+	return fmt.Sprintf("bitcandle attestation\ntxid: %s\nsha256: %s", txid, hex.EncodeToString(hash[:]))
+}
+
+// Sign signs a message with the legacy signmessage format
+// The signature can be verified by any wallet supporting verifymessage with the P2PKH address of the key
+func Sign(key *btcutil.WIF, message string) (string, error) {
+	sig, err := ecdsa.SignCompact(key.PrivKey, messageHash(message), key.CompressPubKey)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// Address returns the P2PKH address identifying the signer of an attestation
+func Address(key *btcutil.WIF, network *chaincfg.Params) (*btcutil.AddressPubKeyHash, error) {
+	return btcutil.NewAddressPubKeyHash(btcutil.Hash160(key.SerializePubKey()), network)
+}
+
+// Verify checks that a message was signed by the key of a P2PKH address
+func Verify(address btcutil.Address, message, signature string) error {
+	if _, ok := address.(*btcutil.AddressPubKeyHash); !ok {
+		return errors.New("only P2PKH addresses are supported")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.New("invalid signature encoding")
+	}
+
+	pubKey, compressed, err := ecdsa.RecoverCompact(sig, messageHash(message))
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+
+	var serialized []byte
+	if compressed {
+		serialized = pubKey.SerializeCompressed()
+	} else {
+		serialized = pubKey.SerializeUncompressed()
+	}
+
+	if !bytes.Equal(btcutil.Hash160(serialized), address.ScriptAddress()) {
+		return errors.New("signature does not match address")
+	}
+
+	return nil
+}
+
+// messageHash computes the double SHA-256 of a message prefixed with the signmessage magic
+func messageHash(message string) []byte {
+	var buf bytes.Buffer
+	_ = wire.WriteVarString(&buf, 0, messageMagic)
+	_ = wire.WriteVarString(&buf, 0, message)
+
+	return chainhash.DoubleHashB(buf.Bytes())
+}
@@ -0,0 +1,296 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aureleoules/bitcandle/injector"
+	"github.com/aureleoules/bitcandle/payload"
+	"github.com/aureleoules/bitcandle/spv"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Version is the current version of the certificate format
+const Version = 1
+
+// Certificate is a self-contained proof that a file was injected in the blockchain at a given block
+// It holds every transaction needed to rebuild the file and their Merkle proofs, so that it can be verified offline
+type Certificate struct {
+	Version       int           `json:"version"`
+	Network       string        `json:"network"`
+	TxID          string        `json:"txid"`
+	Author        string        `json:"author,omitempty"`
+	PayloadSHA256 string        `json:"payload_sha256"`
+	File          *File         `json:"file,omitempty"`
+	Transactions  []Transaction `json:"transactions"`
+}
+
+// File describes the file found in the payload, it is absent if the payload has no header or is encrypted
+type File struct {
+	Name     string `json:"name"`
+	MIMEType string `json:"mime_type"`
+	Size     uint64 `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// Transaction holds a raw transaction and the proof of its inclusion in a block
+type Transaction struct {
+	TxID         string    `json:"txid"`
+	Raw          string    `json:"raw"`
+	BlockHeight  int32     `json:"block_height"`
+	BlockHash    string    `json:"block_hash"`
+	BlockTime    time.Time `json:"block_time"`
+	BlockHeader  string    `json:"block_header"`
+	MerkleBranch []string  `json:"merkle_branch"`
+	Position     uint32    `json:"position"`
+}
+
+// Result is the outcome of the verification of a certificate
+type Result struct {
+	Payload []byte
+	Author  *btcec.PublicKey
+
+	// The file existed at the latest block including one of the transactions
+	// Its height is copied from the certificate, nothing commits to it
+	BlockHeight int32
+	BlockHash   chainhash.Hash
+	BlockTime   time.Time
+}
+
+// New creates the certificate of a file injected in a transaction
+// Parts of files referenced by a manifest must be given as well, proofs are given in the same order as transactions
+func New(txid chainhash.Hash, txs []*wire.MsgTx, proofs []*spv.Proof, network *chaincfg.Params) (*Certificate, error) {
+	if len(txs) != len(proofs) {
+		return nil, errors.New("missing proofs")
+	}
+
+	certificate := Certificate{
+		Version: Version,
+		Network: network.Name,
+		TxID:    txid.String(),
+	}
+
+	for i, tx := range txs {
+		var raw bytes.Buffer
+		err := tx.Serialize(&raw)
+		if err != nil {
+			return nil, err
+		}
+
+		var header bytes.Buffer
+		err = proofs[i].Header.Serialize(&header)
+		if err != nil {
+			return nil, err
+		}
+
+		transaction := Transaction{
+			TxID:        tx.TxHash().String(),
+			Raw:         hex.EncodeToString(raw.Bytes()),
+			BlockHeight: proofs[i].Height,
+			BlockHash:   proofs[i].Header.BlockHash().String(),
+			BlockTime:   proofs[i].Header.Timestamp.UTC(),
+			BlockHeader: hex.EncodeToString(header.Bytes()),
+			Position:    proofs[i].Position,
+		}
+
+		for _, hash := range proofs[i].Branch {
+			transaction.MerkleBranch = append(transaction.MerkleBranch, hash.String())
+		}
+
+		certificate.Transactions = append(certificate.Transactions, transaction)
+	}
+
+	// Rebuild the file from the certificate itself, so that it can only be created if it verifies
+	result, err := certificate.rebuild(network)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(result.Payload)
+	certificate.PayloadSHA256 = hex.EncodeToString(hash[:])
+
+	if result.Author != nil {
+		certificate.Author = hex.EncodeToString(result.Author.SerializeCompressed())
+	}
+
+	header, data, err := payload.Decode(result.Payload)
+	if err == nil && header.Verify(data) == nil {
+		certificate.File = &File{
+			Name:     header.Name,
+			MIMEType: header.MIMEType,
+			Size:     header.Length,
+			SHA256:   hex.EncodeToString(header.Checksum[:]),
+		}
+	}
+
+	return &certificate, nil
+}
+
+// Verify checks the certificate without any network access
+// Every transaction must be committed by the header of its block, and the file rebuilt from them must match the certificate
+// Headers are not checked against the best chain, the hashes of their blocks must be checked against a verified header chain
+func (c *Certificate) Verify() (*Result, error) {
+	if c.Version != Version {
+		return nil, errors.New("unsupported certificate version")
+	}
+
+	network, err := networkParams(c.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.rebuild(network)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(result.Payload)
+	if hex.EncodeToString(hash[:]) != c.PayloadSHA256 {
+		return nil, errors.New("payload hash mismatch")
+	}
+
+	if c.Author != "" && (result.Author == nil || hex.EncodeToString(result.Author.SerializeCompressed()) != c.Author) {
+		return nil, errors.New("author mismatch")
+	}
+
+	if c.File != nil {
+		header, data, err := payload.Decode(result.Payload)
+		if err != nil {
+			return nil, errors.New("payload has no header")
+		}
+
+		if header.Verify(data) != nil || hex.EncodeToString(header.Checksum[:]) != c.File.SHA256 || header.Name != c.File.Name || header.Length != c.File.Size {
+			return nil, errors.New("file mismatch")
+		}
+	}
+
+	return result, nil
+}
+
+// rebuild checks the inclusion proofs of the transactions and rebuilds the payload they hold
+func (c *Certificate) rebuild(network *chaincfg.Params) (*Result, error) {
+	var result Result
+	retrievals := make(map[string]*injector.Retrieval)
+
+	for i, transaction := range c.Transactions {
+		raw, err := hex.DecodeString(transaction.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: invalid hex", i)
+		}
+
+		retrieval, err := injector.RetrieveData(raw)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %v", i, err)
+		}
+
+		txid := retrieval.Tx.TxHash()
+		if txid.String() != transaction.TxID {
+			return nil, fmt.Errorf("transaction %d: txid mismatch", i)
+		}
+
+		proof, err := parseProof(&transaction)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %v", i, err)
+		}
+
+		err = proof.Verify(txid, network)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %v", i, err)
+		}
+
+		if proof.Height >= result.BlockHeight {
+			result.BlockHeight = proof.Height
+			result.BlockHash = proof.Header.BlockHash()
+			result.BlockTime = proof.Header.Timestamp.UTC()
+		}
+
+		retrievals[transaction.TxID] = retrieval
+	}
+
+	root, ok := retrievals[c.TxID]
+	if !ok {
+		return nil, errors.New("missing transaction " + c.TxID)
+	}
+	result.Author = root.Author
+
+	// Data that does not parse as a manifest is the payload itself
+	manifest, err := injector.DecodeManifest(root.Data)
+	if err != nil {
+		result.Payload = root.Data
+		return &result, nil
+	}
+
+	// Parts must be injected by the author of the manifest
+	parts := make([][]byte, len(manifest.TxIDs))
+	for i, txid := range manifest.TxIDs {
+		part, ok := retrievals[txid.String()]
+		if ok && (root.Author == nil || part.IsAuthor(root.Author)) {
+			parts[i] = part.Data
+		}
+	}
+
+	if manifest.IsErasureCoded() {
+		injector.CheckShards(manifest, parts)
+		result.Payload, err = injector.JoinShards(manifest, parts)
+		if err != nil {
+			return nil, err
+		}
+
+		return &result, nil
+	}
+
+	for i, part := range parts {
+		if part == nil {
+			return nil, errors.New("missing transaction " + manifest.TxIDs[i].String())
+		}
+		result.Payload = append(result.Payload, part...)
+	}
+
+	return &result, nil
+}
+
+// parseProof decodes the block header and Merkle branch of a transaction
+func parseProof(transaction *Transaction) (*spv.Proof, error) {
+	raw, err := hex.DecodeString(transaction.BlockHeader)
+	if err != nil {
+		return nil, errors.New("invalid block header")
+	}
+
+	proof := spv.Proof{Height: transaction.BlockHeight, Position: transaction.Position}
+	err = proof.Header.Deserialize(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.New("invalid block header")
+	}
+
+	if proof.Header.BlockHash().String() != transaction.BlockHash {
+		return nil, errors.New("block hash mismatch")
+	}
+
+	for _, h := range transaction.MerkleBranch {
+		hash, err := chainhash.NewHashFromStr(h)
+		if err != nil {
+			return nil, errors.New("invalid merkle branch")
+		}
+		proof.Branch = append(proof.Branch, *hash)
+	}
+
+	return &proof, nil
+}
+
+// networkParams returns the parameters of a network by name
+func networkParams(name string) (*chaincfg.Params, error) {
+	for _, params := range []*chaincfg.Params{&chaincfg.MainNetParams, &chaincfg.TestNet3Params, &chaincfg.RegressionNetParams} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+
+	return nil, errors.New("unknown network: " + name)
+}
@@ -0,0 +1,44 @@
+package certificate
+
+import (
+	"html/template"
+	"io"
+)
+
+var htmlTemplate = template.Must(template.New("certificate").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Proof of existence - {{.TxID}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; }
+td { padding: 0.2em 1em 0.2em 0; vertical-align: top; }
+code { word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>Proof of existence</h1>
+<p>The following data was stored in the Bitcoin blockchain ({{.Network}}).</p>
+<table>
+<tr><td>Transaction</td><td><code>{{.TxID}}</code></td></tr>
+<tr><td>Payload SHA-256</td><td><code>{{.PayloadSHA256}}</code></td></tr>
+{{if .Author}}<tr><td>Injected by</td><td><code>{{.Author}}</code></td></tr>{{end}}
+{{with .File}}<tr><td>File name</td><td>{{.Name}}</td></tr>
+<tr><td>File type</td><td>{{.MIMEType}}</td></tr>
+<tr><td>File size</td><td>{{.Size}} bytes</td></tr>
+<tr><td>File SHA-256</td><td><code>{{.SHA256}}</code></td></tr>{{end}}
+</table>
+<h2>Transactions</h2>
+<table>
+<tr><th>Transaction</th><th>Block</th><th>Time</th></tr>
+{{range .Transactions}}<tr><td><code>{{.TxID}}</code></td><td>{{.BlockHeight}}<br><code>{{.BlockHash}}</code></td><td>{{.BlockTime}}</td></tr>
+{{end}}</table>
+<p>This page is a summary. The accompanying JSON certificate can be verified with <code>bitcandle certificate verify</code>, which checks the block hashes against the header chain of an electrum server to prove that the blocks are part of the blockchain.</p>
+</body>
+</html>
+`))
+
+// WriteHTML renders a human readable summary of the certificate
+func (c *Certificate) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, c)
+}
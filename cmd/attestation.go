@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aureleoules/bitcandle/attest"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/guumaster/logsymbols"
+	"github.com/spf13/cobra"
+	"github.com/thediveo/enumflag"
+)
+
+var (
+	attestKey       string
+	attestAddress   string
+	attestSignature string
+)
+
+func init() {
+	attestCmd.Flags().StringVar(&txHash, "tx", "", "txid of the injected file")
+	attestCmd.Flags().StringVar(&attestKey, "key", "", "long-term private key (WIF) signing the attestation")
+	attestCmd.Flags().StringVarP(&electrumServer, "server", "s", "", "electrum server")
+	attestCmd.PersistentFlags().VarP(
+		enumflag.New(&network, "network", NetworkIds, enumflag.EnumCaseInsensitive), "network", "n", "bitcoin network; can be 'mainnet', 'testnet' or 'regtest'")
+
+	verifyAttestationCmd.Flags().StringVar(&txHash, "tx", "", "txid of the injected file")
+	verifyAttestationCmd.Flags().StringVar(&attestAddress, "address", "", "P2PKH address of the signer")
+	verifyAttestationCmd.Flags().StringVar(&attestSignature, "signature", "", "base64 signature of the attestation")
+	verifyAttestationCmd.Flags().StringVarP(&electrumServer, "server", "s", "", "electrum server")
+	verifyAttestationCmd.PersistentFlags().VarP(
+		enumflag.New(&network, "network", NetworkIds, enumflag.EnumCaseInsensitive), "network", "n", "bitcoin network; can be 'mainnet', 'testnet' or 'regtest'")
+
+	rootCmd.AddCommand(attestCmd)
+	rootCmd.AddCommand(verifyAttestationCmd)
+}
+
+var attestCmd = &cobra.Command{
+	Use:   "attest",
+	Short: "Sign a message binding an injected file to a long-term key",
+	Run: func(cmd *cobra.Command, args []string) {
+		if txHash == "" {
+			errCommandHelp(cmd, "no txid was provided")
+		}
+
+		key, err := btcutil.DecodeWIF(attestKey)
+		if err != nil {
+			errCommandHelp(cmd, "invalid private key")
+		}
+
+		address, err := attest.Address(key, loadChainParams(network))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		connectElectrum()
+
+		message := attest.Message(txHash, retrieveFile(txHash, nil))
+
+		signature, err := attest.Sign(key, message)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not sign attestation.")
+			os.Exit(1)
+		}
+
+		fmt.Println(logsymbols.Success, "Signed attestation.")
+		fmt.Println()
+		fmt.Println(message)
+		fmt.Println()
+		fmt.Println("Address:  ", address.EncodeAddress())
+		fmt.Println("Signature:", signature)
+	},
+}
+
+var verifyAttestationCmd = &cobra.Command{
+	Use:   "verify-attestation",
+	Short: "Verify that an injected file was attested by a long-term key",
+	Run: func(cmd *cobra.Command, args []string) {
+		if txHash == "" {
+			errCommandHelp(cmd, "no txid was provided")
+		}
+
+		if attestSignature == "" {
+			errCommandHelp(cmd, "no signature was provided")
+		}
+
+		address, err := btcutil.DecodeAddress(attestAddress, loadChainParams(network))
+		if err != nil {
+			errCommandHelp(cmd, "invalid address")
+		}
+
+		connectElectrum()
+
+		// The message is rebuilt from the data on chain, the signature only matches if the data is unchanged
+		message := attest.Message(txHash, retrieveFile(txHash, nil))
+
+		err = attest.Verify(address, message, attestSignature)
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Invalid attestation: "+err.Error()+".")
+			os.Exit(1)
+		}
+
+		fmt.Println(logsymbols.Success, "File was attested by "+address.EncodeAddress()+".")
+	},
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/aureleoules/bitcandle/injector"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+// defaultBackupDelay is about 30 days of blocks
+const defaultBackupDelay = 4320
+
+var (
+	backupPubKey string
+	backupKey    string
+	backupDelay  int
+
+	backup *injector.Backup
+)
+
+// loadBackup parses the backup key allowed to reclaim the funds of witness script inputs, if any
+// The backup key is given as a public key when injecting, or as a private key (WIF) when reclaiming, which is returned as well
+func loadBackup() (*injector.Backup, *btcec.PrivateKey, error) {
+	var pubKey *btcec.PublicKey
+	var privKey *btcec.PrivateKey
+
+	switch {
+	case backupKey != "":
+		wif, err := btcutil.DecodeWIF(backupKey)
+		if err != nil {
+			return nil, nil, errors.New("invalid backup private key")
+		}
+		privKey = wif.PrivKey
+		pubKey = privKey.PubKey()
+	case backupPubKey != "":
+		b, err := hex.DecodeString(backupPubKey)
+		if err != nil {
+			return nil, nil, errors.New("invalid backup public key: " + backupPubKey)
+		}
+
+		pubKey, err = btcec.ParsePubKey(b)
+		if err != nil {
+			return nil, nil, errors.New("invalid backup public key: " + backupPubKey)
+		}
+	default:
+		return nil, nil, nil
+	}
+
+	// Relative timelocks in blocks are 16 bits
+	if backupDelay < 1 || backupDelay > 0xffff {
+		return nil, nil, errors.New("backup delay must be between 1 and 65535 blocks")
+	}
+
+	return &injector.Backup{PubKey: pubKey, Delay: uint16(backupDelay)}, privKey, nil
+}
+
+// applyBackup adds the backup branch to the scripts of an encoder
+// It returns false if a backup key is set and the encoder does not support it
+func applyBackup(encoder injector.Encoder) (injector.Encoder, bool) {
+	if backup == nil {
+		return encoder, true
+	}
+
+	backupEncoder, ok := encoder.(injector.BackupEncoder)
+	if !ok {
+		return nil, false
+	}
+
+	return backupEncoder.WithBackup(backup), true
+}
+
+// checkBackupFunding rejects plans funded through the deposit address, its P2WPKH outputs have no backup branch
+// Only the manifest goes through the deposit address when funding with a PSBT
+func checkBackupFunding(plan *injector.Plan) error {
+	if backup == nil {
+		return nil
+	}
+
+	if fundingWithPSBT() {
+		if plan.NeedsManifest() {
+			return errors.New("the manifest is funded through the deposit address, which the backup key could not reclaim")
+		}
+		return nil
+	}
+
+	if !payToMany {
+		return errors.New("funds of the deposit address could not be reclaimed by the backup key, use --pay-to-many with --backup-pubkey")
+	}
+
+	return nil
+}
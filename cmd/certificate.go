@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aureleoules/bitcandle/certificate"
+	"github.com/aureleoules/bitcandle/injector"
+	"github.com/aureleoules/bitcandle/spv"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guumaster/logsymbols"
+	"github.com/spf13/cobra"
+	"github.com/thediveo/enumflag"
+)
+
+var (
+	htmlPath           string
+	certificateOffline bool
+)
+
+func init() {
+	certificateCmd.Flags().StringVar(&txHash, "tx", "", "txid of the injected file")
+	certificateCmd.Flags().StringVarP(&outputFile, "output", "o", "", "output path of the JSON certificate (defaults to <txid>.json)")
+	certificateCmd.Flags().StringVar(&htmlPath, "html", "", "also write a human readable HTML summary to this path")
+	certificateCmd.PersistentFlags().StringVarP(&electrumServer, "server", "s", "", "electrum server")
+	certificateCmd.PersistentFlags().VarP(
+		enumflag.New(&network, "network", NetworkIds, enumflag.EnumCaseInsensitive), "network", "n", "bitcoin network; can be 'mainnet', 'testnet' or 'regtest'")
+
+	certificateVerifyCmd.Flags().BoolVar(&certificateOffline, "offline", false, "do not check the blocks against the header chain of an electrum server")
+
+	certificateCmd.AddCommand(certificateVerifyCmd)
+	rootCmd.AddCommand(certificateCmd)
+}
+
+var certificateCmd = &cobra.Command{
+	Use:   "certificate",
+	Short: "Export a proof of existence of an injected file",
+	Run: func(cmd *cobra.Command, args []string) {
+		if txHash == "" {
+			errCertificateHelp("no txid was provided")
+		}
+
+		txid, err := chainhash.NewHashFromStr(txHash)
+		if err != nil {
+			errCertificateHelp("invalid txid")
+		}
+
+		if outputFile == "" {
+			outputFile = txHash + ".json"
+		}
+
+		connectElectrum()
+
+		txs := collectTransactions(txHash)
+
+		var proofs []*spv.Proof
+		for _, tx := range txs {
+			proof, err := fetchProof(tx)
+			if err != nil {
+				fmt.Println(logsymbols.Error, "Could not fetch proof of transaction "+tx.TxHash().String()+": "+err.Error()+".")
+				os.Exit(1)
+			}
+
+			if proof == nil {
+				fmt.Println(logsymbols.Error, "Transaction "+tx.TxHash().String()+" is not confirmed yet.")
+				os.Exit(1)
+			}
+
+			proofs = append(proofs, proof)
+		}
+
+		cert, err := certificate.New(*txid, txs, proofs, loadChainParams(network))
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Could not create certificate: "+err.Error()+".")
+			os.Exit(1)
+		}
+
+		b, err := json.MarshalIndent(cert, "", "  ")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		err = ioutil.WriteFile(outputFile, b, 0644)
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Could not write certificate.")
+			os.Exit(1)
+		}
+
+		fmt.Println(logsymbols.Success, "Saved certificate to \""+outputFile+"\".")
+
+		if htmlPath != "" {
+			f, err := os.Create(htmlPath)
+			if err != nil {
+				fmt.Println(logsymbols.Error, "Could not write HTML summary.")
+				os.Exit(1)
+			}
+			defer f.Close()
+
+			err = cert.WriteHTML(f)
+			if err != nil {
+				fmt.Println(logsymbols.Error, "Could not write HTML summary.")
+				os.Exit(1)
+			}
+
+			fmt.Println(logsymbols.Success, "Saved HTML summary to \""+htmlPath+"\".")
+		}
+	},
+}
+
+var certificateVerifyCmd = &cobra.Command{
+	Use:   "verify <certificate>",
+	Short: "Verify a proof of existence and check its blocks against the header chain",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		b, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Could not read certificate.")
+			os.Exit(1)
+		}
+
+		var cert certificate.Certificate
+		err = json.Unmarshal(b, &cert)
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Could not parse certificate.")
+			os.Exit(1)
+		}
+
+		result, err := cert.Verify()
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Invalid certificate: "+err.Error()+".")
+			os.Exit(1)
+		}
+
+		if !certificateOffline {
+			verifyCertificateBlocks(&cert)
+		}
+
+		fmt.Println(logsymbols.Success, "Certificate is valid.")
+		fmt.Println(logsymbols.Info, "Transaction: "+cert.TxID)
+		fmt.Println(logsymbols.Info, "Payload SHA-256: "+cert.PayloadSHA256)
+		if result.Author != nil {
+			fmt.Println(logsymbols.Info, "Injected by: "+hex.EncodeToString(result.Author.SerializeCompressed()))
+		}
+		if cert.File != nil {
+			fmt.Println(logsymbols.Info, fmt.Sprintf("File: %s (%s, %d bytes, SHA-256 %s)", cert.File.Name, cert.File.MIMEType, cert.File.Size, cert.File.SHA256))
+		}
+		if certificateOffline {
+			fmt.Println(logsymbols.Info, fmt.Sprintf("Existed at block %s (%s).", result.BlockHash, result.BlockTime))
+			// Heights are not committed by headers, only the block hash can be checked
+			fmt.Println(logsymbols.Warn, fmt.Sprintf("Height %d is claimed by the certificate and not verified, check the block hash against a trusted node.", result.BlockHeight))
+		} else {
+			fmt.Println(logsymbols.Info, fmt.Sprintf("Existed at block %s at height %d (%s).", result.BlockHash, result.BlockHeight, result.BlockTime))
+		}
+	},
+}
+
+// collectTransactions fetches the transaction of a file and the parts referenced by its manifest
+// Shards that cannot be fetched are left out as long as the file can be rebuilt
+func collectTransactions(txid string) []*wire.MsgTx {
+	root, err := retrieveData(txid, nil)
+	if err != nil {
+		fmt.Println(logsymbols.Error, err.Error())
+		os.Exit(1)
+	}
+
+	txs := []*wire.MsgTx{root.Tx}
+	manifest, err := injector.DecodeManifest(root.Data)
+	if err != nil {
+		return txs
+	}
+
+	for _, txid := range manifest.TxIDs {
+		part, err := retrieveData(txid.String(), root.Author)
+		if err != nil {
+			if manifest.IsErasureCoded() {
+				fmt.Println(logsymbols.Warn, err.Error())
+				continue
+			}
+
+			fmt.Println(logsymbols.Error, err.Error())
+			os.Exit(1)
+		}
+
+		txs = append(txs, part.Tx)
+	}
+
+	return txs
+}
+
+// verifyCertificateBlocks checks that the blocks of a certificate are in the header chain of the electrum server, verified from a checkpoint
+func verifyCertificateBlocks(cert *certificate.Certificate) {
+	found := false
+	for n := range NetworkIds {
+		if loadChainParams(n).Name == cert.Network {
+			network = n
+			found = true
+		}
+	}
+
+	if !found {
+		fmt.Println(logsymbols.Error, "Invalid certificate: unknown network "+cert.Network+".")
+		os.Exit(1)
+	}
+
+	connectElectrum()
+
+	for _, transaction := range cert.Transactions {
+		header, err := fetchBlockHeader(transaction.BlockHeight)
+		if err != nil {
+			fmt.Println(logsymbols.Error, fmt.Sprintf("Could not verify block at height %d: %s.", transaction.BlockHeight, err.Error()))
+			os.Exit(1)
+		}
+
+		if header.BlockHash().String() != transaction.BlockHash {
+			fmt.Println(logsymbols.Error, fmt.Sprintf("Invalid certificate: block %s is not at height %d of the header chain.", transaction.BlockHash, transaction.BlockHeight))
+			os.Exit(1)
+		}
+	}
+}
+
+func errCertificateHelp(err string) {
+	fmt.Println("error: " + err)
+	fmt.Println(`Please see "bitcandle certificate --help" for more information.`)
+	os.Exit(1)
+}
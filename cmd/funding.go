@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/aureleoules/bitcandle/electrum"
+	"github.com/aureleoules/bitcandle/funding"
+	"github.com/aureleoules/bitcandle/injector"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guumaster/logsymbols"
+)
+
+// gapLimit is the number of consecutive unused scripts after which ranged descriptors are no longer scanned
+const gapLimit = 20
+
+var (
+	fundingPSBT        string
+	signedPSBT         string
+	fundingDescriptors []string
+	fundingAddresses   []string
+)
+
+// fundingWithPSBT returns true if the injection addresses are paid by an external wallet
+func fundingWithPSBT() bool {
+	return fundingPSBT != "" || signedPSBT != ""
+}
+
+// fundWithPSBT pays the injection addresses, and the deposit funding the manifest if any, with a PSBT
+// The PSBT is written for an external wallet to sign, or broadcast once signed
+func fundWithPSBT(addresses []*injector.InjectionAddress, deposit *injector.Deposit, netParams *chaincfg.Params) {
+	var outputs []*wire.TxOut
+	for _, addr := range addresses {
+		pkScript, err := txscript.PayToAddrScript(addr.Address)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		outputs = append(outputs, wire.NewTxOut(addr.Amount, pkScript))
+	}
+
+	if deposit != nil {
+		outputs = append(outputs, wire.NewTxOut(deposit.Amount, deposit.PkScript()))
+	}
+
+	if signedPSBT != "" {
+		packet := readPSBT(signedPSBT)
+
+		tx, err := funding.Extract(packet, outputs)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not extract funding transaction.")
+			os.Exit(1)
+		}
+
+		broadcastTX(tx, "Funded injection addresses.")
+		return
+	}
+
+	packet, err := funding.NewPSBT(listFundingUTXOs(netParams), outputs, feeRate)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not create funding PSBT.")
+		os.Exit(1)
+	}
+
+	var buf bytes.Buffer
+	err = packet.Serialize(&buf)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = ioutil.WriteFile(fundingPSBT, buf.Bytes(), 0644)
+	if err != nil {
+		fmt.Println(logsymbols.Error, "Could not write funding PSBT.")
+		os.Exit(1)
+	}
+
+	fee, err := packet.GetTxFee()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Println(logsymbols.Success, fmt.Sprintf("Wrote funding PSBT to \"%s\" (fee: %.8f BTC).", fundingPSBT, float64(fee)/consensus.BTCSats))
+	fmt.Println(logsymbols.Info, "Sign and broadcast it with your wallet, or sign it and run again with --signed-psbt.")
+}
+
+// readPSBT loads a PSBT in binary or base64 format
+func readPSBT(path string) *psbt.Packet {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		errInjectHelp(err.Error())
+	}
+
+	b64 := !bytes.HasPrefix(raw, []byte("psbt\xff"))
+	if b64 {
+		raw = bytes.TrimSpace(raw)
+	}
+
+	packet, err := psbt.NewFromRawBytes(bytes.NewReader(raw), b64)
+	if err != nil {
+		errInjectHelp("invalid PSBT: " + err.Error())
+	}
+
+	return packet
+}
+
+// listFundingUTXOs finds the unspent outputs of the funding descriptors and addresses
+func listFundingUTXOs(netParams *chaincfg.Params) []*funding.UTXO {
+	var descriptors []*funding.Descriptor
+	for _, s := range fundingDescriptors {
+		descriptor, err := funding.ParseDescriptor(s, netParams)
+		if err != nil {
+			errInjectHelp(err.Error())
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+
+	for _, address := range fundingAddresses {
+		descriptor, err := funding.ParseDescriptor("addr("+address+")", netParams)
+		if err != nil {
+			errInjectHelp(err.Error())
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+
+	var utxos []*funding.UTXO
+	for _, descriptor := range descriptors {
+		scripts, err := scanDescriptor(descriptor)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not scan funding descriptor.")
+			os.Exit(1)
+		}
+
+		for _, script := range scripts {
+			unspent, err := electrum.Client.ListUnspent(electrum.ScriptHash(script.PkScript))
+			if err != nil {
+				fmt.Println(logsymbols.Error, "Could not list unspent outputs.")
+				os.Exit(1)
+			}
+
+			for _, u := range unspent {
+				raw, err := fetchTransaction(u.Hash)
+				if err != nil {
+					fmt.Println(logsymbols.Error, err.Error())
+					os.Exit(1)
+				}
+
+				var prevTx wire.MsgTx
+				err = prevTx.Deserialize(bytes.NewReader(raw))
+				if err != nil || prevTx.TxHash().String() != u.Hash || int(u.Position) >= len(prevTx.TxOut) {
+					fmt.Println(logsymbols.Error, "Invalid transaction "+u.Hash+".")
+					os.Exit(1)
+				}
+
+				// Amounts are taken from the transaction itself rather than trusted from the server
+				prevOut := prevTx.TxOut[u.Position]
+				if !bytes.Equal(prevOut.PkScript, script.PkScript) {
+					fmt.Println(logsymbols.Error, "Invalid transaction "+u.Hash+".")
+					os.Exit(1)
+				}
+
+				txid := prevTx.TxHash()
+				utxos = append(utxos, &funding.UTXO{
+					OutPoint: *wire.NewOutPoint(&txid, u.Position),
+					Value:    prevOut.Value,
+					Script:   script,
+					PrevTx:   &prevTx,
+				})
+			}
+		}
+	}
+
+	return utxos
+}
+
+// scanDescriptor returns the scripts of a descriptor, ranged descriptors are scanned until gapLimit consecutive scripts are unused
+func scanDescriptor(descriptor *funding.Descriptor) ([]*funding.Script, error) {
+	if !descriptor.IsRanged() {
+		return descriptor.Scripts(1)
+	}
+
+	var scripts []*funding.Script
+	count := gapLimit
+	checked := 0
+	lastUsed := -1
+	for {
+		var err error
+		scripts, err = descriptor.Scripts(count)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := checked; i < count; i++ {
+			history, err := electrum.Client.GetHistory(electrum.ScriptHash(scripts[i].PkScript))
+			if err != nil {
+				return nil, err
+			}
+
+			if len(history) > 0 {
+				lastUsed = i
+			}
+		}
+		checked = count
+
+		if count-lastUsed-1 >= gapLimit {
+			return scripts[:lastUsed+1], nil
+		}
+		count = lastUsed + 1 + gapLimit
+	}
+}
@@ -3,20 +3,27 @@ package cmd
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/aureleoules/bitcandle/consensus"
 	"github.com/aureleoules/bitcandle/electrum"
 	"github.com/aureleoules/bitcandle/injector"
+	"github.com/aureleoules/bitcandle/keystore"
+	"github.com/aureleoules/bitcandle/payload"
 	"github.com/briandowns/spinner"
-	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/btcsuite/btcutil"
 	"github.com/guumaster/logsymbols"
 	"github.com/mdp/qrterminal"
 	"github.com/spf13/cobra"
@@ -28,8 +35,19 @@ const keyDir = "./keys"
 var (
 	filePath      string
 	network       Network
+	method        string
+	addressType   string
+	nonStandard   bool
+	exportPath    string
+	compress      string
+	encrypt       bool
+	recipients    []string
+	shards        string
+	dataShards    int
+	parityShards  int
 	feeRate       int
 	changeAddress string
+	payToMany     bool
 )
 
 // Network represents an enum of different bitcoin networks
@@ -56,6 +74,25 @@ func init() {
 	injectCmd.Flags().StringVarP(&filePath, "file", "f", "", "path of the file to inject on Bitcoin")
 	injectCmd.Flags().StringVarP(&changeAddress, "change-address", "c", "", "address to receive change (548 sats)")
 	injectCmd.Flags().IntVar(&feeRate, "fee", 5, "fee rate (sat/B)")
+	injectCmd.Flags().StringVar(&method, "method", "", "injection method; can be 'auto' (cheapest) or "+encoderNames()+" (defaults to witness scripts of --address-type, or 'op-return' when cheaper)")
+	injectCmd.Flags().StringVar(&addressType, "address-type", "", "funding address type of witness scripts when no method is given; can be 'p2sh-p2wsh' or 'p2wsh'")
+	injectCmd.Flags().BoolVar(&nonStandard, "non-standard", false, "use 520 bytes chunks allowed by consensus but not relayed by nodes (requires --export)")
+	injectCmd.Flags().StringVar(&exportPath, "export", "", "write signed transactions to this file instead of broadcasting them")
+	injectCmd.Flags().BoolVar(&encrypt, "encrypt", false, "encrypt the file with a passphrase before injection")
+	injectCmd.Flags().StringArrayVar(&recipients, "recipient", nil, "encrypt the file to this public key (hex), can be repeated")
+	injectCmd.Flags().StringVar(&shards, "shards", "", "erasure code the file into n transactions, any k of which rebuild it (e.g. '4-of-6')")
+	injectCmd.Flags().BoolVar(&payToMany, "pay-to-many", false, "fund every injection address separately instead of a single deposit address")
+	injectCmd.Flags().StringVar(&fundingPSBT, "funding-psbt", "", "write a PSBT paying every injection address to this file, for an external wallet to sign")
+	injectCmd.Flags().StringArrayVar(&fundingDescriptors, "funding-descriptor", nil, "output descriptor of the wallet funding the PSBT, can be repeated")
+	injectCmd.Flags().StringArrayVar(&fundingAddresses, "funding-address", nil, "address of the wallet funding the PSBT, can be repeated")
+	injectCmd.Flags().StringVar(&signedPSBT, "signed-psbt", "", "broadcast this signed funding PSBT instead of waiting for payments")
+	injectCmd.Flags().StringVar(&signerPubKey, "pubkey", "", "public key (hex) of an external signer holding the injection key, instead of a local key")
+	injectCmd.Flags().StringVar(&signerOrigin, "pubkey-origin", "", "origin of the external signer key, as in descriptors: [fingerprint/path]")
+	injectCmd.Flags().StringVar(&unsignedPSBTs, "unsigned-psbts", "", "write the PSBTs of the transactions the external signer must sign to this file")
+	injectCmd.Flags().StringVar(&signedPSBTs, "signed-psbts", "", "finalize the transactions with the PSBTs signed by the external signer")
+	injectCmd.Flags().StringVar(&backupPubKey, "backup-pubkey", "", "public key (hex) of a backup key allowed to reclaim the funds of witness script inputs after a delay")
+	injectCmd.Flags().IntVar(&backupDelay, "backup-delay", defaultBackupDelay, "number of blocks after which the backup key may reclaim the funds")
+	injectCmd.Flags().StringVar(&compress, "compress", "none", "compress the file before injection; can be 'none', 'gzip', 'zstd' or 'brotli'")
 
 	rootCmd.AddCommand(injectCmd)
 }
@@ -68,6 +105,46 @@ var injectCmd = &cobra.Command{
 			errInjectHelp("missing file path")
 		}
 
+		// Non-standard transactions are not relayed by nodes, they must be submitted to a miner
+		if nonStandard && exportPath == "" {
+			errInjectHelp("non-standard transactions must be exported with --export")
+		}
+
+		compression, err := payload.ParseCompression(compress)
+		if err != nil {
+			errInjectHelp(err.Error())
+		}
+
+		if encrypt && len(recipients) > 0 {
+			errInjectHelp("--encrypt and --recipient cannot be used together")
+		}
+
+		recipientKeys, err := parseRecipients(recipients)
+		if err != nil {
+			errInjectHelp(err.Error())
+		}
+
+		if fundingPSBT != "" && signedPSBT == "" && len(fundingDescriptors) == 0 && len(fundingAddresses) == 0 {
+			errInjectHelp("--funding-psbt requires --funding-descriptor or --funding-address")
+		}
+
+		// Encrypted payloads are randomized, transactions built again would not match the signed PSBTs
+		if signerPubKey != "" && (encrypt || len(recipients) > 0) {
+			errInjectHelp("encrypted files cannot be signed with PSBTs")
+		}
+
+		if shards != "" {
+			dataShards, parityShards, err = parseShards(shards)
+			if err != nil {
+				errInjectHelp(err.Error())
+			}
+		}
+
+		backup, _, err = loadBackup()
+		if err != nil {
+			errInjectHelp(err.Error())
+		}
+
 		if electrumServer == "" {
 			electrumServer = getDefaultElectrumServer(network)
 		}
@@ -83,82 +160,92 @@ var injectCmd = &cobra.Command{
 			errInjectHelp(err.Error())
 		}
 
-		if len(data) > 285*1024 {
-			fmt.Println(logsymbols.Error, "File is too large.")
-			os.Exit(1)
-		}
-
 		fmt.Println(logsymbols.Success, "Loaded", len(data), "bytes to inject.")
 
-		if len(data) < 800 {
-			fmt.Println(logsymbols.Warn, "It is not recommended to use this injection method for files less than 800 bytes as there are more optimized ones for smaller files.")
-		}
-
 		if changeAddress == "" {
 			fmt.Println(logsymbols.Warn, "No change address has been provided. Defaulting to provided public key's P2PKH address.")
 		}
 
-		md5hasher := md5.New()
-		md5hasher.Write(data)
-		md5Hash := md5hasher.Sum(nil)
+		// Describe the file so that it can be verified and named when retrieved
+		header := payload.NewHeader(data, fileInfo.Name(), detectMIMEType(filePath, data), compression)
+		raw := data
+		data, err = payload.Encode(header, raw)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not compress file.")
+			os.Exit(1)
+		}
+
+		// Keys are derived from the content of the file, compression and encryption do not change them
+		signer := loadSigner(fileInfo.Name(), raw)
 
-		_ = os.Mkdir(keyDir, 0777)
-		keyFilePath := keyDir + "/" + fileInfo.Name() + "_" + hex.EncodeToString(md5Hash)
-		_, err = os.Stat(keyFilePath)
-		var key *btcec.PrivateKey
-		if err == nil {
-			key, err = loadKey(keyFilePath)
-			if err != nil {
-				errInjectHelp(err.Error())
-			}
+		// The scripts cannot be rebuilt without the public key of the file
+		if backup != nil {
+			fmt.Println(logsymbols.Info, "Injection public key: "+hex.EncodeToString(signer.PubKey().SerializeCompressed())+". Keep it with the backup key to reclaim the funds.")
+		}
 
-			// Load private key
-			fmt.Println(logsymbols.Success, "Loaded existing private key.")
-		} else {
+		if compression != payload.NoCompression {
+			fmt.Println(logsymbols.Info, fmt.Sprintf("Compressed %d bytes to %d bytes with %s.", len(raw), len(data), compression))
+		}
 
-			key, err = btcec.NewPrivateKey(btcec.S256())
+		// Encrypt the whole payload so that the file name and type remain private
+		if encrypt {
+			passphrase, err := readPassphrase(true)
 			if err != nil {
 				errInjectHelp(err.Error())
 			}
 
-			err = ioutil.WriteFile(keyFilePath, key.Serialize(), 0644)
+			data, err = payload.Encrypt(data, passphrase)
 			if err != nil {
-				fmt.Println("here")
 				fmt.Println(err)
+				fmt.Println(logsymbols.Error, "Could not encrypt file.")
 				os.Exit(1)
 			}
 
-			// Load private key
-			fmt.Println(logsymbols.Success, "Generated new private key.")
+			fmt.Println(logsymbols.Success, "Encrypted file.")
 		}
 
-		// Load chain params
-		netParams := loadChainParams(network)
-
-		var addr btcutil.Address
-
-		if changeAddress != "" {
-			addr, err = btcutil.DecodeAddress(changeAddress, netParams)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
-		} else {
-			addr, err = btcutil.NewAddressPubKeyHash(btcutil.Hash160(key.PubKey().SerializeCompressed()), netParams)
+		// Only the recipients can unwrap the content key
+		if len(recipientKeys) > 0 {
+			data, err = payload.EncryptToRecipients(data, recipientKeys)
 			if err != nil {
 				fmt.Println(err)
+				fmt.Println(logsymbols.Error, "Could not encrypt file.")
 				os.Exit(1)
 			}
+
+			fmt.Println(logsymbols.Success, fmt.Sprintf("Encrypted file to %d recipients.", len(recipientKeys)))
 		}
 
-		// Create file injector
-		inject, err := injector.NewInjection(data, feeRate, key, netParams)
+		// Load chain params
+		netParams := loadChainParams(network)
+
+		payToAddrScript := changeScript(signer.PubKey(), netParams)
+
+		// Create file injectors
+		// Files larger than a single standard transaction are split into several parts
+		plan, err := buildPlan(data, signer, netParams)
 		if err != nil {
 			fmt.Println(err)
 			fmt.Println(logsymbols.Error, "Could not prepare injection data.")
 			os.Exit(1)
 		}
 
+		printMethod(plan)
+
+		err = checkBackupFunding(plan)
+		if err != nil {
+			errInjectHelp(err.Error())
+		}
+
+		recordPlan(data, plan, signer, netParams)
+
+		if plan.ParityShards > 0 {
+			fmt.Println(logsymbols.Info, fmt.Sprintf("File will be split into %d shards, any %d of which rebuild it, and a manifest.", len(plan.Parts), plan.DataShards))
+		} else if plan.NeedsManifest() {
+			fmt.Println(logsymbols.Info, fmt.Sprintf("File will be split into %d transactions and a manifest.", len(plan.Parts)))
+		}
+
 		s := spinner.New(spinner.CharSets[9], 100*time.Millisecond, spinner.WithSuffix(" Connecting to electrum server..."))
 		s.Start()
 
@@ -172,86 +259,584 @@ var injectCmd = &cobra.Command{
 		s.Stop()
 		fmt.Println(logsymbols.Success, "Connected to electrum server ("+electrumServer+").")
 
-		cost, costPerInput, err := inject.EstimateCost()
+		cost := estimateCost(plan)
+
+		if compression != payload.NoCompression {
+			printSavings(raw, header, plan, cost, signer, netParams)
+		}
+
+		txs, _ := executePlan(plan, signer, payToAddrScript)
+
+		if plan.NeedsManifest() {
+			fmt.Println(logsymbols.Info, "Manifest TxID:", txs[len(txs)-1].TxHash().String())
+		}
+	},
+}
+
+// legacyKeyPath returns the path of the random key generated for a file by older versions
+func legacyKeyPath(name string, data []byte) string {
+	md5Hash := md5.Sum(data)
+	return keyDir + "/" + name + "_" + hex.EncodeToString(md5Hash[:])
+}
+
+// loadInjectionKey loads the key of a file from the keystore, or derives it from the seed and the hash of its content
+// Legacy and imported keys are preferred so that injections started with them can be resumed and their funds recovered
+func loadInjectionKey(name string, data []byte) *btcec.PrivateKey {
+	ks := openKeystore()
+	entries, err := ks.Find(sha256.Sum256(data), loadChainParams(network))
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not read keystore.")
+		os.Exit(1)
+	}
+
+	var derived *keystore.Entry
+	for _, entry := range entries {
+		switch entry.Origin {
+		case keystore.OriginLegacy, keystore.OriginImported:
+			injectionEntry = entry
+			key := loadEntryKey(ks, entry)
+			fmt.Println(logsymbols.Success, "Loaded private key "+entry.ID+" from keystore.")
+			return key
+		case keystore.OriginSeed:
+			derived = entry
+		}
+	}
+
+	// Random keys of older versions were stored in clear, they are moved to the keystore
+	keyFilePath := legacyKeyPath(name, data)
+	_, err = os.Stat(keyFilePath)
+	if err == nil {
+		key, err := loadKey(keyFilePath)
+		if err != nil {
+			errInjectHelp(err.Error())
+		}
+
+		injectionEntry = storeKey(ks, name, data, keystore.OriginLegacy, key)
+		err = os.Remove(keyFilePath)
 		if err != nil {
 			fmt.Println(err)
-			fmt.Println(logsymbols.Error, "Could not estimate injection cost.")
 			os.Exit(1)
 		}
 
-		fmt.Println(logsymbols.Info, fmt.Sprintf("Estimated injection cost: %.8f BTC.", float64(cost)/consensus.BTCSats))
+		fmt.Println(logsymbols.Warn, "Moved legacy private key to the keystore, see \"bitcandle seed migrate\".")
+		return key
+	}
+
+	if derived != nil {
+		injectionEntry = derived
+		key := loadEntryKey(ks, derived)
+		fmt.Println(logsymbols.Success, "Loaded private key "+derived.ID+" from keystore.")
+		return key
+	}
+
+	key := seedKey(data)
+	injectionEntry = storeKey(ks, name, data, keystore.OriginSeed, key)
+	fmt.Println(logsymbols.Success, "Derived private key from seed.")
+	return key
+}
 
-		for _, addr := range inject.Addresses {
-			fmt.Println(logsymbols.Info, fmt.Sprintf("You must send %.8f BTC to %s.", float64(costPerInput)/consensus.BTCSats, addr.Address.EncodeAddress()))
+// changeScript returns the output script receiving the change, the P2PKH address of the key is used by default
+func changeScript(pubKey *btcec.PublicKey, netParams *chaincfg.Params) []byte {
+	var addr btcutil.Address
+	var err error
 
-			if len(inject.Addresses) == 1 {
-				qrterminal.GenerateHalfBlock(fmt.Sprintf("bitcoin:%s?amount=%.8f", addr.Address.EncodeAddress(), float64(costPerInput)/consensus.BTCSats), qrterminal.L, os.Stdout)
-			}
+	if changeAddress != "" {
+		addr, err = btcutil.DecodeAddress(changeAddress, netParams)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		addr, err = btcutil.NewAddressPubKeyHash(btcutil.Hash160(pubKey.SerializeCompressed()), netParams)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+	}
 
-		if len(inject.Addresses) > 1 {
-			fmt.Println(logsymbols.Info, "Copy paste this in Electrum -> Tools -> Pay to many.")
-			fmt.Println()
-			for _, addr := range inject.Addresses {
-				fmt.Println(fmt.Sprintf("%s,%.8f", addr.Address.EncodeAddress(), float64(costPerInput)/consensus.BTCSats))
-			}
-			fmt.Println()
+	payToAddrScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return payToAddrScript
+}
+
+// executePlan requests the payments of a plan, signs its transactions and broadcasts or exports them
+// Transactions to be signed by an external signer are written as PSBTs instead
+// Only the injection transactions are returned, the manifest, if any, is the last one
+// It also tells whether they were broadcast, they are not while waiting for signatures or when exported
+func executePlan(plan *injector.Plan, signer injector.Signer, payToAddrScript []byte) ([]*wire.MsgTx, bool) {
+	var addresses []*injector.InjectionAddress
+	for _, part := range plan.Parts {
+		addresses = append(addresses, part.Addresses...)
+	}
+
+	// Fan-out transactions must be broadcast before the injections spending them
+	var signed []*wire.MsgTx
+
+	var deposit *injector.Deposit
+	switch {
+	case fundingWithPSBT():
+		// The PSBT pays the parts, the manifest is funded from the deposit address once their txids are known
+		if plan.NeedsManifest() {
+			deposit = newManifestDeposit(plan)
 		}
 
-		s = spinner.New(spinner.CharSets[9], 100*time.Millisecond, spinner.WithSuffix(" Waiting for payments..."))
-		s.Start()
+		fundWithPSBT(addresses, deposit, plan.Network)
+		waitPayments(plan.Parts)
+		if deposit != nil {
+			waitDeposit(deposit)
+		}
+	case payToMany:
+		requestPayments(addresses)
+		waitPayments(plan.Parts)
+	default:
+		deposit = newDeposit(plan)
+		requestPayments([]*injector.InjectionAddress{{Address: deposit.Address, Amount: deposit.Amount}})
+		waitDeposit(deposit)
+
+		// The change funds the manifest once the txids of the parts are known
+		changeScript := payToAddrScript
+		if plan.NeedsManifest() {
+			changeScript = deposit.PkScript()
+		}
 
-		// Wait for utxos to be created by the user
-		err = inject.WaitPayments(func(addr string, num int) {
-			s.Stop()
-			fmt.Println(logsymbols.Success, fmt.Sprintf("Payment received. (%d/%d)", num, inject.NumInputs()))
-			s.Start()
-		})
+		signed = append(signed, fanOut(deposit, addresses, changeScript))
+	}
 
+	var txs []*wire.MsgTx
+	var txids []chainhash.Hash
+	for _, part := range plan.Parts {
+		tx, err := part.BuildTX(wire.NewTxOut(consensus.P2PKHDustLimit, payToAddrScript))
 		if err != nil {
-			fmt.Println(logsymbols.Error, err.Error())
-			s.Stop()
+			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		fmt.Println(logsymbols.Success, "All payments received.")
+		txs = append(txs, tx)
+		txids = append(txids, tx.TxHash())
+	}
 
-		payToAddrScript, err := txscript.PayToAddrScript(addr)
+	// The manifest can only be built once the txids of all parts are known
+	if plan.NeedsManifest() {
+		manifest, err := plan.BuildManifest(txids)
 		if err != nil {
 			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not prepare manifest.")
 			os.Exit(1)
 		}
 
-		tx, err := inject.BuildTX(wire.NewTxOut(consensus.P2PKHDustLimit, payToAddrScript))
+		recordManifest(plan.ManifestData(txids), manifest)
+
+		if deposit != nil {
+			signed = append(signed, fanOut(deposit, manifest.Addresses, payToAddrScript))
+		} else {
+			fmt.Println(logsymbols.Info, "All parts are funded. One last payment is required for the manifest.")
+			requestPayments(manifest.Addresses)
+			waitPayments([]*injector.Injection{manifest})
+		}
+
+		tx, err := manifest.BuildTX(wire.NewTxOut(consensus.P2PKHDustLimit, payToAddrScript))
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		// Checks if transaction has been mined already
-		_, err = electrum.Client.GetRawTransaction(tx.TxHash().String())
-		if err == nil {
-			fmt.Println(logsymbols.Warn, "Data already injected.")
-			fmt.Println(logsymbols.Info, "TxID:", tx.TxHash().String())
-		} else {
-			s = spinner.New(spinner.CharSets[9], 100*time.Millisecond, spinner.WithSuffix(" Broadcasting transaction..."))
-			s.Start()
+		txs = append(txs, tx)
+	}
 
-			var txBytes bytes.Buffer
-			tx.Serialize(&txBytes)
+	// Txids do not depend on signatures, they are known before the transactions are signed
+	if requestSignatures(signer) {
+		return txs, false
+	}
 
-			txid, err := electrum.Client.BroadcastTransaction(hex.EncodeToString(txBytes.Bytes()))
-			if err != nil {
-				s.Stop()
-				fmt.Println(logsymbols.Error, "Could not broadcast transaction.")
-				fmt.Println(err)
-				os.Exit(1)
+	if exportPath != "" {
+		exportTXs(append(signed, txs...))
+		return txs, false
+	}
+
+	for _, tx := range signed {
+		broadcastTX(tx, "Funded injection addresses.")
+	}
+	for _, tx := range txs {
+		broadcastTX(tx, "Data injected.")
+	}
+
+	return txs, true
+}
+
+// estimateCost prints and returns the cost of a plan, including the fan-out transactions of its deposit
+func estimateCost(plan *injector.Plan) int64 {
+	cost, err := plan.EstimateCost()
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not estimate injection cost.")
+		os.Exit(1)
+	}
+
+	switch {
+	case fundingWithPSBT():
+		if plan.NeedsManifest() {
+			cost += newManifestDeposit(plan).Fee
+		}
+	case !payToMany:
+		cost += newDeposit(plan).Fee
+	}
+
+	fmt.Println(logsymbols.Info, fmt.Sprintf("Estimated injection cost: %.8f BTC.", float64(cost)/consensus.BTCSats))
+	return cost
+}
+
+// newDeposit creates the deposit address funding a plan
+func newDeposit(plan *injector.Plan) *injector.Deposit {
+	deposit, err := plan.NewDeposit()
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not prepare deposit address.")
+		os.Exit(1)
+	}
+
+	return deposit
+}
+
+// newManifestDeposit creates the deposit address funding the manifest of a plan
+func newManifestDeposit(plan *injector.Plan) *injector.Deposit {
+	deposit, err := plan.NewManifestDeposit()
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not prepare deposit address.")
+		os.Exit(1)
+	}
+
+	return deposit
+}
+
+// waitDeposit waits until the deposit address is funded
+func waitDeposit(deposit *injector.Deposit) {
+	s := spinner.New(spinner.CharSets[9], 100*time.Millisecond, spinner.WithSuffix(" Waiting for payment..."))
+	s.Start()
+
+	err := deposit.WaitPayment()
+	if err != nil {
+		s.Stop()
+		fmt.Println(logsymbols.Error, err.Error())
+		os.Exit(1)
+	}
+
+	s.Stop()
+	fmt.Println(logsymbols.Success, "Payment received.")
+}
+
+// fanOut signs the transaction funding injection addresses from the deposit
+func fanOut(deposit *injector.Deposit, addresses []*injector.InjectionAddress, changeScript []byte) *wire.MsgTx {
+	tx, err := deposit.FanOut(addresses, changeScript)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not fund injection addresses.")
+		os.Exit(1)
+	}
+
+	return tx
+}
+
+// buildPlan prepares the injection of data using the requested method
+// By default, witness scripts of the requested address type are used, or OP_RETURN outputs when they are cheaper
+// With the auto method, every registered encoder is asked for a cost and the cheapest one is picked
+func buildPlan(data []byte, signer injector.Signer, netParams *chaincfg.Params) (*injector.Plan, error) {
+	if addressType != "" && method != "" {
+		return nil, errors.New("--address-type cannot be used with --method, use --method " + addressType + " instead")
+	}
+
+	switch method {
+	case "":
+		witnessMethod := addressType
+		if witnessMethod == "" {
+			witnessMethod = "p2sh-p2wsh"
+		}
+
+		if witnessMethod != "p2sh-p2wsh" && witnessMethod != "p2wsh" {
+			return nil, errors.New("unknown address type: " + addressType)
+		}
+
+		witnessEncoder, err := injector.EncoderByName(witnessMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		opReturnEncoder, err := injector.EncoderByName("op-return")
+		if err != nil {
+			return nil, err
+		}
+
+		return cheapestPlan(data, []injector.Encoder{witnessEncoder, opReturnEncoder}, signer, netParams)
+	case "auto":
+		return cheapestPlan(data, injector.Encoders(), signer, netParams)
+	}
+
+	encoder, err := injector.EncoderByName(method)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder, ok := applyBackup(applyProfile(encoder))
+	if !ok {
+		return nil, errors.New("the " + method + " method does not support backup keys")
+	}
+
+	return newPlan(data, encoder, signer, netParams)
+}
+
+// cheapestPlan asks each encoder for a cost and returns the cheapest plan
+func cheapestPlan(data []byte, encoders []injector.Encoder, signer injector.Signer, netParams *chaincfg.Params) (*injector.Plan, error) {
+	var cheapest *injector.Plan
+	var cheapestCost int64
+	lastErr := errors.New("no injection method supports backup keys")
+	for _, encoder := range encoders {
+		// Funds of methods without backup branch could not be reclaimed by the backup key
+		encoder, ok := applyBackup(applyProfile(encoder))
+		if !ok {
+			continue
+		}
+
+		plan, err := newPlan(data, encoder, signer, netParams)
+		if err != nil {
+			// Shards may not fit in the transactions of every encoder
+			if dataShards > 0 {
+				lastErr = err
+				continue
 			}
+			return nil, err
+		}
+
+		// OP_RETURN parts are only relayed by recent nodes, a file split across them is less likely to be rebuilt
+		if _, ok := encoder.(*injector.OPReturnEncoder); ok && plan.NeedsManifest() {
+			continue
+		}
+
+		cost, err := plan.EstimateCost()
+		if err != nil {
+			return nil, err
+		}
+
+		if cheapest == nil || cost < cheapestCost {
+			cheapest = plan
+			cheapestCost = cost
+		}
+	}
+
+	if cheapest == nil {
+		return nil, lastErr
+	}
+
+	return cheapest, nil
+}
+
+// printMethod prints the injection method of a plan
+func printMethod(plan *injector.Plan) {
+	fmt.Println(logsymbols.Info, "Using "+plan.Encoder.Name()+" injection method.")
+
+	if _, ok := plan.Encoder.(*injector.OPReturnEncoder); ok {
+		fmt.Println(logsymbols.Warn, "Transactions with several OP_RETURN outputs are only relayed by nodes running Bitcoin Core 30 or later.")
+	}
+}
+
+// newPlan splits data into parts, or into shards if erasure coding is requested
+func newPlan(data []byte, encoder injector.Encoder, signer injector.Signer, netParams *chaincfg.Params) (*injector.Plan, error) {
+	if dataShards > 0 {
+		return injector.NewErasurePlan(data, encoder, dataShards, parityShards, feeRate, signer, netParams)
+	}
+
+	return injector.NewPlan(data, encoder, feeRate, signer, netParams)
+}
+
+// parseShards parses a k-of-n erasure coding scheme
+func parseShards(s string) (int, int, error) {
+	var k, n int
+	_, err := fmt.Sscanf(s, "%d-of-%d", &k, &n)
+	if err != nil || k < 1 || n <= k || n > 256 {
+		return 0, 0, errors.New("invalid shards, expected k-of-n with 0 < k < n <= 256")
+	}
+
+	return k, n - k, nil
+}
+
+// printSavings compares the cost of the plan with the cost of injecting the file uncompressed
+func printSavings(data []byte, header *payload.Header, plan *injector.Plan, cost int64, signer injector.Signer, netParams *chaincfg.Params) {
+	uncompressedHeader := *header
+	uncompressedHeader.Compression = payload.NoCompression
+
+	uncompressed, err := payload.Encode(&uncompressedHeader, data)
+	if err != nil {
+		return
+	}
+
+	// Encryption adds the same overhead to both payloads
+	if encrypt {
+		uncompressed = append(uncompressed, make([]byte, payload.EncryptionOverhead)...)
+	}
+	if len(recipients) > 0 {
+		uncompressed = append(uncompressed, make([]byte, payload.RecipientOverhead(len(recipients)))...)
+	}
+
+	uncompressedPlan, err := newPlan(uncompressed, plan.Encoder, signer, netParams)
+	if err != nil {
+		return
+	}
+
+	uncompressedCost, err := uncompressedPlan.EstimateCost()
+	if err != nil {
+		return
+	}
+
+	if uncompressedCost <= cost {
+		fmt.Println(logsymbols.Warn, "Compression does not reduce the injection cost.")
+		return
+	}
+
+	fmt.Println(logsymbols.Info, fmt.Sprintf("Compression saves %.8f BTC.", float64(uncompressedCost-cost)/consensus.BTCSats))
+}
+
+// parseRecipients decodes the public keys given as hex
+func parseRecipients(recipients []string) ([]*btcec.PublicKey, error) {
+	var keys []*btcec.PublicKey
+	for _, recipient := range recipients {
+		b, err := hex.DecodeString(recipient)
+		if err != nil {
+			return nil, errors.New("invalid recipient public key: " + recipient)
+		}
+
+		key, err := btcec.ParsePubKey(b)
+		if err != nil {
+			return nil, errors.New("invalid recipient public key: " + recipient)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// applyProfile switches encoders to consensus limits if non-standard transactions are requested
+func applyProfile(encoder injector.Encoder) injector.Encoder {
+	if profileEncoder, ok := encoder.(injector.ProfileEncoder); ok && nonStandard {
+		return profileEncoder.WithProfile(consensus.NonStandard)
+	}
+
+	return encoder
+}
+
+// encoderNames lists the names of all registered encoders
+func encoderNames() string {
+	var names []string
+	for _, encoder := range injector.Encoders() {
+		names = append(names, "'"+encoder.Name()+"'")
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// requestPayments displays the addresses the user must fund
+func requestPayments(addresses []*injector.InjectionAddress) {
+	for _, addr := range addresses {
+		fmt.Println(logsymbols.Info, fmt.Sprintf("You must send %.8f BTC to %s.", float64(addr.Amount)/consensus.BTCSats, addr.Address.EncodeAddress()))
+
+		if len(addresses) == 1 {
+			qrterminal.GenerateHalfBlock(fmt.Sprintf("bitcoin:%s?amount=%.8f", addr.Address.EncodeAddress(), float64(addr.Amount)/consensus.BTCSats), qrterminal.L, os.Stdout)
+		}
+	}
+
+	if len(addresses) > 1 {
+		fmt.Println(logsymbols.Info, "Copy paste this in Electrum -> Tools -> Pay to many.")
+		fmt.Println()
+		for _, addr := range addresses {
+			fmt.Println(fmt.Sprintf("%s,%.8f", addr.Address.EncodeAddress(), float64(addr.Amount)/consensus.BTCSats))
+		}
+		fmt.Println()
+	}
+}
+
+// waitPayments waits until all addresses of the injections are funded
+func waitPayments(injections []*injector.Injection) {
+	var total int
+	for _, inject := range injections {
+		total += inject.NumInputs()
+	}
+
+	s := spinner.New(spinner.CharSets[9], 100*time.Millisecond, spinner.WithSuffix(" Waiting for payments..."))
+	s.Start()
+
+	var received int
+	for _, inject := range injections {
+		// Wait for utxos to be created by the user
+		err := inject.WaitPayments(func(addr string, num int) {
+			s.Stop()
+			received++
+			fmt.Println(logsymbols.Success, fmt.Sprintf("Payment received. (%d/%d)", received, total))
+			s.Start()
+		})
+
+		if err != nil {
 			s.Stop()
-			fmt.Println(logsymbols.Success, "Data injected.")
-			fmt.Println(logsymbols.Info, "TxID:", txid)
+			fmt.Println(logsymbols.Error, err.Error())
+			os.Exit(1)
 		}
-	},
+	}
+
+	s.Stop()
+	fmt.Println(logsymbols.Success, "All payments received.")
+}
+
+// broadcastTX broadcasts a signed transaction unless it has already been mined
+func broadcastTX(tx *wire.MsgTx, success string) {
+	// Checks if transaction has been mined already
+	_, err := electrum.Client.GetRawTransaction(tx.TxHash().String())
+	if err == nil {
+		fmt.Println(logsymbols.Warn, "Transaction already broadcast.")
+		fmt.Println(logsymbols.Info, "TxID:", tx.TxHash().String())
+		return
+	}
+
+	s := spinner.New(spinner.CharSets[9], 100*time.Millisecond, spinner.WithSuffix(" Broadcasting transaction..."))
+	s.Start()
+
+	var txBytes bytes.Buffer
+	tx.Serialize(&txBytes)
+
+	txid, err := electrum.Client.BroadcastTransaction(hex.EncodeToString(txBytes.Bytes()))
+	if err != nil {
+		s.Stop()
+		fmt.Println(logsymbols.Error, "Could not broadcast transaction.")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	s.Stop()
+	fmt.Println(logsymbols.Success, success)
+	fmt.Println(logsymbols.Info, "TxID:", txid)
+}
+
+// exportTXs writes signed transactions to the export file, one hex encoded transaction per line
+func exportTXs(txs []*wire.MsgTx) {
+	var lines []string
+	for _, tx := range txs {
+		var txBytes bytes.Buffer
+		tx.Serialize(&txBytes)
+
+		lines = append(lines, hex.EncodeToString(txBytes.Bytes()))
+	}
+
+	err := ioutil.WriteFile(exportPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		fmt.Println(logsymbols.Error, "Could not write to export file.")
+		os.Exit(1)
+	}
+
+	fmt.Println(logsymbols.Success, fmt.Sprintf("Exported %d signed transactions to \"%s\".", len(txs), exportPath))
+	for _, tx := range txs {
+		fmt.Println(logsymbols.Info, "TxID:", tx.TxHash().String())
+	}
 }
 
 func errInjectHelp(err string) {
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aureleoules/bitcandle/injector"
+	"github.com/aureleoules/bitcandle/keystore"
+	"github.com/aureleoules/bitcandle/seed"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func TestBuildPlan(t *testing.T) {
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := injector.NewKeySigner(key)
+	netParams := &chaincfg.RegressionNetParams
+
+	backupKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small := []byte("a small file")
+	large := bytes.Repeat([]byte("a larger file "), 400)
+
+	tests := []struct {
+		name        string
+		method      string
+		addressType string
+		data        []byte
+		backup      bool
+		encoder     string
+		err         bool
+	}{
+		{"default small file", "", "", small, false, "op-return", false},
+		{"default large file", "", "", large, false, "p2sh-p2wsh", false},
+		{"address type", "", "p2wsh", large, false, "p2wsh", false},
+		{"address type small file", "", "p2wsh", small, false, "p2wsh", false},
+		{"default with backup", "", "", small, true, "p2sh-p2wsh", false},
+		{"explicit method", "p2tr", "", small, false, "p2tr", false},
+		{"explicit op-return", "op-return", "", large, false, "op-return", false},
+		{"auto", "auto", "", large, false, "", false},
+		{"auto with backup", "auto", "", small, true, "", false},
+		{"address type with method", "p2wsh", "p2wsh", large, false, "", true},
+		{"unknown address type", "", "p2tr", large, false, "", true},
+		{"unknown method", "p2pkh", "", large, false, "", true},
+		{"op-return with backup", "op-return", "", small, true, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method, addressType, feeRate = test.method, test.addressType, 5
+			backup = nil
+			if test.backup {
+				backup = &injector.Backup{PubKey: backupKey.PubKey(), Delay: defaultBackupDelay}
+			}
+			defer func() { method, addressType, backup = "", "", nil }()
+
+			plan, err := buildPlan(test.data, signer, netParams)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got the %s method", plan.Encoder.Name())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.encoder != "" {
+				if plan.Encoder.Name() != test.encoder {
+					t.Fatalf("method = %s, want %s", plan.Encoder.Name(), test.encoder)
+				}
+				return
+			}
+
+			// Automatic selection picks the cheapest encoder supporting the options
+			cost, err := plan.EstimateCost()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, encoder := range injector.Encoders() {
+				encoder, ok := applyBackup(encoder)
+				if !ok {
+					continue
+				}
+
+				other, err := injector.NewPlan(test.data, encoder, feeRate, signer, netParams)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				otherCost, err := other.EstimateCost()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if otherCost < cost {
+					t.Fatalf("%s costs %d, %s costs %d", plan.Encoder.Name(), cost, encoder.Name(), otherCost)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadInjectionKey(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	network, keystorePassphrase = RegressionTest, "passphrase"
+	defer func() { network, keystorePassphrase, injectionEntry = Mainnet, "", nil }()
+	netParams := &chaincfg.RegressionNetParams
+
+	// The seed is created on first use
+	data := []byte("file content")
+	key := loadInjectionKey("file.txt", data)
+
+	ks := openKeystore()
+	mnemonic, err := ks.Seed("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := seed.FromMnemonic(mnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	derived, err := s.Key(sha256.Sum256(data), netParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !key.Key.Equals(&derived.Key) {
+		t.Fatal("key is not derived from the seed")
+	}
+
+	// Keys only depend on the content of the file
+	if renamed := loadInjectionKey("renamed.txt", data); !renamed.Key.Equals(&key.Key) {
+		t.Fatal("renamed file has another key")
+	}
+
+	// Random keys of older versions are moved to the keystore and preferred over the seed
+	legacy, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacyData := []byte("file injected by an older version")
+	keyFilePath := legacyKeyPath("legacy.txt", legacyData)
+	if err := ioutil.WriteFile(keyFilePath, legacy.Serialize(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if loaded := loadInjectionKey("legacy.txt", legacyData); !loaded.Key.Equals(&legacy.Key) {
+			t.Fatal("legacy key was not loaded")
+		}
+
+		if _, err := os.Stat(keyFilePath); !os.IsNotExist(err) {
+			t.Fatal("legacy key file was not removed")
+		}
+
+		entries, err := ks.Find(sha256.Sum256(legacyData), netParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entries) != 1 || entries[0].Origin != keystore.OriginLegacy {
+			t.Fatalf("found %d entries", len(entries))
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/aureleoules/bitcandle/injector"
+	"github.com/aureleoules/bitcandle/keystore"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/guumaster/logsymbols"
+	"github.com/spf13/cobra"
+	"github.com/thediveo/enumflag"
+)
+
+// keystorePassphraseEnv allows providing the passphrase of the keystore without a terminal
+// It is distinct from the passphrase of encrypted payloads, which are shared with other people
+const keystorePassphraseEnv = "BITCANDLE_KEYSTORE_PASSPHRASE"
+
+var (
+	keystorePassphrase string
+	forceRemove        bool
+
+	// injectionEntry is the keystore entry of the key loaded for the current file, if any
+	injectionEntry *keystore.Entry
+)
+
+func init() {
+	keysImportCmd.Flags().StringVarP(&filePath, "file", "f", "", "path of the file injected with the key")
+	keysImportCmd.PersistentFlags().VarP(
+		enumflag.New(&network, "network", NetworkIds, enumflag.EnumCaseInsensitive), "network", "n", "bitcoin network; can be 'mainnet', 'testnet' or 'regtest'")
+
+	keysRemoveCmd.Flags().BoolVar(&forceRemove, "force", false, "remove a key that cannot be derived from the seed")
+
+	keysCmd.AddCommand(keysListCmd)
+	keysCmd.AddCommand(keysExportCmd)
+	keysCmd.AddCommand(keysImportCmd)
+	keysCmd.AddCommand(keysRemoveCmd)
+	rootCmd.AddCommand(keysCmd)
+}
+
+// openKeystore returns the keystore holding the seed and the keys of injected files
+func openKeystore() *keystore.Keystore {
+	return keystore.New(keyDir)
+}
+
+// unlockKeystore reads the passphrase of the keystore once
+// The first passphrase is asked twice, later ones are checked against the existing keys
+func unlockKeystore(ks *keystore.Keystore) string {
+	if keystorePassphrase != "" {
+		return keystorePassphrase
+	}
+
+	empty, err := ks.IsEmpty()
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not read keystore.")
+		os.Exit(1)
+	}
+
+	passphrase, err := promptPassphrase(keystorePassphraseEnv, "Keystore passphrase", empty)
+	if err != nil {
+		fmt.Println(logsymbols.Error, err.Error())
+		os.Exit(1)
+	}
+
+	if !empty {
+		err = ks.Verify(passphrase)
+		if err != nil {
+			fmt.Println(logsymbols.Error, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	keystorePassphrase = passphrase
+	return passphrase
+}
+
+// storeKey encrypts the key of a file in the keystore
+func storeKey(ks *keystore.Keystore, name string, data []byte, origin keystore.Origin, key *btcec.PrivateKey) *keystore.Entry {
+	entry, err := keystore.NewEntry(name, sha256.Sum256(data), loadChainParams(network), origin, key, unlockKeystore(ks))
+	if err == nil {
+		err = ks.Add(entry)
+	}
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not store key.")
+		os.Exit(1)
+	}
+
+	return entry
+}
+
+// recordPlan records the payload of a plan, its addresses and the deposit address in the keystore entry of the file
+// Encrypted payloads are randomized, the recorded payload is the only way to rebuild their addresses
+func recordPlan(data []byte, plan *injector.Plan, signer injector.Signer, netParams *chaincfg.Params) {
+	var addresses []*injector.InjectionAddress
+	for _, part := range plan.Parts {
+		addresses = append(addresses, part.Addresses...)
+	}
+
+	deposit, err := injector.DepositAddress(signer.PubKey(), netParams)
+	if err == nil {
+		addresses = append(addresses, &injector.InjectionAddress{Address: deposit})
+	}
+
+	recordPayload(data, keystore.Payload{
+		Method:       plan.Encoder.Name(),
+		DataShards:   plan.DataShards,
+		ParityShards: plan.ParityShards,
+	}, addresses)
+}
+
+// recordManifest records the manifest of a plan and its addresses, they depend on the txids of the parts
+func recordManifest(data []byte, manifest *injector.Injection) {
+	recordPayload(data, keystore.Payload{Method: manifest.Encoder.Name(), Manifest: true}, manifest.Addresses)
+}
+
+// recordPayload stores a payload before it is funded, with the options shared by all its injections
+func recordPayload(data []byte, p keystore.Payload, addresses []*injector.InjectionAddress) {
+	if injectionEntry == nil {
+		return
+	}
+
+	ks := openKeystore()
+	passphrase := unlockKeystore(ks)
+
+	hash := sha256.Sum256(data)
+	p.SHA256 = hex.EncodeToString(hash[:])
+	p.NonStandard = nonStandard
+	if backup != nil {
+		p.BackupPubKey = hex.EncodeToString(backup.PubKey.SerializeCompressed())
+		p.BackupDelay = backup.Delay
+	}
+
+	err := ks.SavePayload(data, passphrase)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not store payload.")
+		os.Exit(1)
+	}
+
+	changed := injectionEntry.AddPayload(p)
+	for _, address := range addresses {
+		changed = injectionEntry.AddAddress(address.Address.EncodeAddress()) || changed
+	}
+
+	if !changed {
+		return
+	}
+
+	err = ks.Save(injectionEntry)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not update keystore.")
+		os.Exit(1)
+	}
+}
+
+// loadEntry loads a keystore entry by ID
+func loadEntry(cmd *cobra.Command, ks *keystore.Keystore, id string) *keystore.Entry {
+	entry, err := ks.Get(id)
+	if err == keystore.ErrNotFound {
+		errCommandHelp(cmd, "unknown key: "+id)
+	}
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not read keystore.")
+		os.Exit(1)
+	}
+
+	return entry
+}
+
+// loadEntryKey decrypts the key of a keystore entry
+func loadEntryKey(ks *keystore.Keystore, entry *keystore.Entry) *btcec.PrivateKey {
+	key, err := entry.Key(unlockKeystore(ks))
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not decrypt key "+entry.ID+".")
+		os.Exit(1)
+	}
+
+	return key
+}
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the encrypted keys of injected files",
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the keys of the keystore",
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := openKeystore().List()
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not read keystore.")
+			os.Exit(1)
+		}
+
+		if len(entries) == 0 {
+			fmt.Println(logsymbols.Info, "No keys found.")
+			return
+		}
+
+		for _, entry := range entries {
+			fmt.Println(entry.ID, entry.Network, entry.Origin, entry.Created.Format("2006-01-02 15:04:05"), entry.Name)
+			fmt.Println("  sha256:", entry.Hash)
+			fmt.Println("  pubkey:", entry.PubKey)
+			for _, p := range entry.Payloads {
+				kind := "payload"
+				if p.Manifest {
+					kind = "manifest"
+				}
+				fmt.Println("  "+kind+":", p.SHA256, p.Method)
+			}
+			for _, address := range entry.Addresses {
+				fmt.Println("  " + address)
+			}
+		}
+	},
+}
+
+var keysExportCmd = &cobra.Command{
+	Use:   "export <id>",
+	Short: "Print a key in WIF",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ks := openKeystore()
+		entry := loadEntry(cmd, ks, args[0])
+
+		netParams, err := entry.Params()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		wif, err := btcutil.NewWIF(loadEntryKey(ks, entry), netParams, true)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Println(wif.String())
+	},
+}
+
+var keysImportCmd = &cobra.Command{
+	Use:   "import <wif|key file>",
+	Short: "Import the key of an injected file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if filePath == "" {
+			errCommandHelp(cmd, "missing file path")
+		}
+
+		netParams := loadChainParams(network)
+
+		// Keys are given in WIF or as the raw key files of older versions
+		var key *btcec.PrivateKey
+		wif, err := btcutil.DecodeWIF(strings.TrimSpace(args[0]))
+		if err == nil {
+			if !wif.IsForNet(netParams) {
+				errCommandHelp(cmd, "key is not for the "+netParams.Name+" network")
+			}
+			key = wif.PrivKey
+		} else {
+			raw, err := ioutil.ReadFile(args[0])
+			if err != nil || len(raw) != btcec.PrivKeyBytesLen {
+				errCommandHelp(cmd, "invalid key: expected a WIF or a key file")
+			}
+			key, _ = btcec.PrivKeyFromBytes(raw)
+		}
+
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			errCommandHelp(cmd, err.Error())
+		}
+
+		raw, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			errCommandHelp(cmd, err.Error())
+		}
+
+		// Entries are looked up by the hash of the file
+		entry := storeKey(openKeystore(), fileInfo.Name(), raw, keystore.OriginImported, key)
+		fmt.Println(logsymbols.Success, "Imported key "+entry.ID+".")
+	},
+}
+
+var keysRemoveCmd = &cobra.Command{
+	Use:   "rm <id>",
+	Short: "Remove a key from the keystore",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ks := openKeystore()
+		entry := loadEntry(cmd, ks, args[0])
+
+		// Keys derived from the seed can be derived again, others are lost with their funds
+		if entry.Origin != keystore.OriginSeed && !forceRemove {
+			errCommandHelp(cmd, "key "+entry.ID+" cannot be derived from the seed, export it first and use --force")
+		}
+
+		err := ks.Remove(entry.ID)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not remove key.")
+			os.Exit(1)
+		}
+
+		fmt.Println(logsymbols.Success, "Removed key "+entry.ID+".")
+	},
+}
@@ -0,0 +1,391 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/aureleoules/bitcandle/injector"
+	"github.com/aureleoules/bitcandle/keystore"
+	"github.com/aureleoules/bitcandle/payload"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guumaster/logsymbols"
+	"github.com/spf13/cobra"
+	"github.com/thediveo/enumflag"
+)
+
+var (
+	refundKey     string
+	refundAddress string
+)
+
+func init() {
+	refundCmd.Flags().StringVar(&refundKey, "key", "", "ID of the injection key in the keystore (see 'bitcandle keys list'), defaults to the key of the file")
+	refundCmd.Flags().StringVarP(&filePath, "file", "f", "", "path of the file whose injection is refunded, optional with --key")
+	refundCmd.Flags().StringVar(&refundAddress, "to", "", "address receiving the refunded coins")
+	refundCmd.Flags().IntVar(&feeRate, "fee", 5, "fee rate (sat/B)")
+	refundCmd.Flags().StringVar(&method, "method", "auto", "injection method used; can be 'auto' (all methods) or "+encoderNames())
+	refundCmd.Flags().StringVar(&compress, "compress", "none", "compression used by the injection; can be 'none', 'gzip', 'zstd' or 'brotli'")
+	refundCmd.Flags().StringVar(&shards, "shards", "", "erasure coding used by the injection (e.g. '4-of-6')")
+	refundCmd.Flags().BoolVar(&nonStandard, "non-standard", false, "the injection used 520 bytes chunks (requires --export)")
+	refundCmd.Flags().StringVar(&backupPubKey, "backup-pubkey", "", "public key (hex) of the backup key of the injection, if any")
+	refundCmd.Flags().StringVar(&backupKey, "backup-key", "", "reclaim the funds with the backup private key (WIF) instead of the key of the file")
+	refundCmd.Flags().IntVar(&backupDelay, "backup-delay", defaultBackupDelay, "number of blocks after which the backup key may reclaim the funds")
+	refundCmd.Flags().StringVar(&signerPubKey, "pubkey", "", "public key (hex) of the file, when reclaiming with the backup key")
+	refundCmd.Flags().StringVar(&exportPath, "export", "", "write signed refund transactions to this file instead of broadcasting them")
+	refundCmd.Flags().StringVarP(&electrumServer, "server", "s", "", "electrum server")
+	refundCmd.PersistentFlags().VarP(
+		enumflag.New(&network, "network", NetworkIds, enumflag.EnumCaseInsensitive), "network", "n", "bitcoin network; can be 'mainnet', 'testnet' or 'regtest'")
+
+	rootCmd.AddCommand(refundCmd)
+}
+
+// refundGroup holds the funded addresses of an encoder, they are spent by the same transaction
+// The group of the deposit address has no encoder
+type refundGroup struct {
+	encoder   injector.Encoder
+	addresses []*injector.InjectionAddress
+}
+
+var refundCmd = &cobra.Command{
+	Use:   "refund",
+	Short: "Send the funds of an abandoned injection back to an address",
+	Run: func(cmd *cobra.Command, args []string) {
+		if refundKey != "" && backupKey != "" {
+			errCommandHelp(cmd, "--key and --backup-key cannot be used together")
+		}
+
+		if backupKey != "" && signerPubKey == "" {
+			errCommandHelp(cmd, "--backup-key requires the public key of the file (--pubkey)")
+		}
+
+		// Payloads recorded with a key are rebuilt without the file
+		if filePath == "" && refundKey == "" {
+			errCommandHelp(cmd, "missing file path")
+		}
+
+		if refundAddress == "" {
+			errCommandHelp(cmd, "missing refund address")
+		}
+
+		if nonStandard && exportPath == "" {
+			errCommandHelp(cmd, "non-standard transactions must be exported with --export")
+		}
+
+		compression, err := payload.ParseCompression(compress)
+		if err != nil {
+			errCommandHelp(cmd, err.Error())
+		}
+
+		if shards != "" {
+			dataShards, parityShards, err = parseShards(shards)
+			if err != nil {
+				errCommandHelp(cmd, err.Error())
+			}
+		}
+
+		netParams := loadChainParams(network)
+
+		addr, err := btcutil.DecodeAddress(refundAddress, netParams)
+		if err != nil || !addr.IsForNet(netParams) {
+			errCommandHelp(cmd, "invalid refund address")
+		}
+
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			errCommandHelp(cmd, err.Error())
+		}
+
+		var backupPrivKey *btcec.PrivateKey
+		backup, backupPrivKey, err = loadBackup()
+		if err != nil {
+			errCommandHelp(cmd, err.Error())
+		}
+
+		var name string
+		var raw, data []byte
+		if filePath != "" {
+			fileInfo, err := os.Stat(filePath)
+			if err != nil {
+				errCommandHelp(cmd, err.Error())
+			}
+
+			raw, err = ioutil.ReadFile(filePath)
+			if err != nil {
+				errCommandHelp(cmd, err.Error())
+			}
+
+			// The witness scripts commit to the payload, it is rebuilt the same way as during the injection
+			name = fileInfo.Name()
+			header := payload.NewHeader(raw, name, detectMIMEType(filePath, raw), compression)
+			data, err = payload.Encode(header, raw)
+			if err != nil {
+				fmt.Println(err)
+				fmt.Println(logsymbols.Error, "Could not compress file.")
+				os.Exit(1)
+			}
+		}
+
+		var signer injector.Signer
+		var backupSigner injector.Signer
+		var entry *keystore.Entry
+		switch {
+		case backupKey != "":
+			b, err := hex.DecodeString(signerPubKey)
+			if err != nil {
+				errCommandHelp(cmd, "invalid public key: "+signerPubKey)
+			}
+
+			pubKey, err := btcec.ParsePubKey(b)
+			if err != nil {
+				errCommandHelp(cmd, "invalid public key: "+signerPubKey)
+			}
+
+			// The key of the file is lost, addresses only need its public key
+			signer = injector.NewPSBTSigner(pubKey, nil)
+
+			backupSigner = injector.NewKeySigner(backupPrivKey)
+		case refundKey != "":
+			ks := openKeystore()
+			entry = loadEntry(cmd, ks, refundKey)
+			if entry.Network != netParams.Name {
+				errCommandHelp(cmd, "key "+entry.ID+" is for the "+entry.Network+" network")
+			}
+			signer = injector.NewKeySigner(loadEntryKey(ks, entry))
+		default:
+			signer = injector.NewKeySigner(loadInjectionKey(name, raw))
+			entry = injectionEntry
+		}
+
+		// Non-standard transactions are not relayed by nodes, they must be submitted to a miner
+		if entry != nil && exportPath == "" {
+			for _, p := range entry.Payloads {
+				if p.NonStandard {
+					errCommandHelp(cmd, "key "+entry.ID+" injected non-standard transactions, refunds must be exported with --export")
+				}
+			}
+		}
+
+		groups, err := refundGroups(data, entry, signer)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not rebuild injection addresses.")
+			os.Exit(1)
+		}
+
+		connectElectrum()
+
+		txs := buildRefunds(groups, signer, backupSigner, pkScript, netParams)
+
+		if len(txs) == 0 {
+			fmt.Println(logsymbols.Warn, "No funds to refund.")
+			return
+		}
+
+		if backupSigner != nil {
+			fmt.Println(logsymbols.Info, fmt.Sprintf("Reclaim transactions are only valid once the funds are %d blocks old.", backup.Delay))
+		}
+
+		if exportPath != "" {
+			exportTXs(txs)
+			return
+		}
+
+		for _, tx := range txs {
+			broadcastTX(tx, fmt.Sprintf("Refunded %.8f BTC.", float64(tx.TxOut[0].Value)/consensus.BTCSats))
+		}
+	},
+}
+
+// refundGroups rebuilds the addresses a file may have been injected with, grouped by encoder
+// Payloads recorded in the keystore entry of the key are rebuilt with the options of their injection, encrypted payloads and manifests included
+// The file, if any, is rebuilt with every method unless one is given
+// The deposit address is refunded in its own group as it is spent with a P2WPKH witness
+// Only witness scripts with a backup branch are rebuilt when reclaiming with the backup key
+func refundGroups(data []byte, entry *keystore.Entry, signer injector.Signer) ([]*refundGroup, error) {
+	netParams := loadChainParams(network)
+
+	var groups []*refundGroup
+	seen := make(map[string]bool)
+	addGroup := func(encoder injector.Encoder, injections []*injector.Injection) {
+		group := refundGroup{encoder: encoder}
+		for _, injection := range injections {
+			for _, address := range injection.Addresses {
+				if !seen[address.Address.EncodeAddress()] {
+					seen[address.Address.EncodeAddress()] = true
+					group.addresses = append(group.addresses, address)
+				}
+			}
+		}
+
+		groups = append(groups, &group)
+	}
+
+	if entry != nil {
+		ks := openKeystore()
+		for _, p := range entry.Payloads {
+			recorded, err := ks.Payload(p.SHA256, unlockKeystore(ks))
+			if err != nil {
+				return nil, err
+			}
+
+			encoder, err := recordedEncoder(p)
+			if err != nil {
+				return nil, err
+			}
+
+			// Manifests are a single injection, they are not split like files
+			if p.Manifest {
+				injection, err := injector.NewInjection(recorded, encoder, feeRate, signer, netParams)
+				if err != nil {
+					return nil, err
+				}
+				addGroup(encoder, []*injector.Injection{injection})
+				continue
+			}
+
+			var plan *injector.Plan
+			if p.DataShards > 0 {
+				plan, err = injector.NewErasurePlan(recorded, encoder, p.DataShards, p.ParityShards, feeRate, signer, netParams)
+			} else {
+				plan, err = injector.NewPlan(recorded, encoder, feeRate, signer, netParams)
+			}
+			if err != nil {
+				return nil, err
+			}
+			addGroup(encoder, plan.Parts)
+		}
+	}
+
+	if data != nil {
+		var encoders []injector.Encoder
+		if method == "auto" {
+			encoders = injector.Encoders()
+		} else {
+			encoder, err := injector.EncoderByName(method)
+			if err != nil {
+				return nil, err
+			}
+			encoders = []injector.Encoder{encoder}
+		}
+
+		for _, encoder := range encoders {
+			backupEncoder, ok := applyBackup(applyProfile(encoder))
+			if !ok {
+				if method != "auto" {
+					return nil, errors.New("the " + method + " method does not support backup keys")
+				}
+				continue
+			}
+
+			plan, err := newPlan(data, backupEncoder, signer, netParams)
+			if err != nil {
+				// Shards may not fit in the transactions of every encoder
+				if method == "auto" && dataShards > 0 {
+					continue
+				}
+				return nil, err
+			}
+			addGroup(backupEncoder, plan.Parts)
+		}
+	}
+
+	// The deposit address can only be spent with the key of the file
+	if backupKey != "" {
+		return groups, nil
+	}
+
+	deposit, err := injector.DepositAddress(signer.PubKey(), netParams)
+	if err != nil {
+		return nil, err
+	}
+
+	// Funds sent to OP_RETURN injections of older versions are refunded with the deposit, they share its script
+	groups = append(groups, &refundGroup{addresses: []*injector.InjectionAddress{{Address: deposit}}})
+
+	return groups, nil
+}
+
+// recordedEncoder returns the encoder of a recorded payload, with the profile and the backup key of its injection
+func recordedEncoder(p keystore.Payload) (injector.Encoder, error) {
+	encoder, err := injector.EncoderByName(p.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	if profileEncoder, ok := encoder.(injector.ProfileEncoder); ok && p.NonStandard {
+		encoder = profileEncoder.WithProfile(consensus.NonStandard)
+	}
+
+	if p.BackupPubKey == "" {
+		return encoder, nil
+	}
+
+	b, err := hex.DecodeString(p.BackupPubKey)
+	if err != nil {
+		return nil, errors.New("invalid backup public key: " + p.BackupPubKey)
+	}
+
+	pubKey, err := btcec.ParsePubKey(b)
+	if err != nil {
+		return nil, errors.New("invalid backup public key: " + p.BackupPubKey)
+	}
+
+	backupEncoder, ok := encoder.(injector.BackupEncoder)
+	if !ok {
+		return nil, errors.New("the " + p.Method + " method does not support backup keys")
+	}
+
+	return backupEncoder.WithBackup(&injector.Backup{PubKey: pubKey, Delay: p.BackupDelay}), nil
+}
+
+// buildRefunds spends the funded addresses of every group to a script, with one transaction per group
+// Funds are reclaimed through the backup branch of witness scripts when a backup signer is given
+func buildRefunds(groups []*refundGroup, signer injector.Signer, backupSigner injector.Signer, pkScript []byte, netParams *chaincfg.Params) []*wire.MsgTx {
+	var txs []*wire.MsgTx
+	for _, group := range groups {
+		var funded []*injector.InjectionAddress
+		for _, address := range group.addresses {
+			unspent, err := address.Unspent()
+			if err != nil {
+				fmt.Println(logsymbols.Error, "Could not list unspent outputs.")
+				os.Exit(1)
+			}
+
+			for _, u := range unspent {
+				fmt.Println(logsymbols.Info, fmt.Sprintf("Found %.8f BTC on %s.", float64(u.Amount)/consensus.BTCSats, u.Address.EncodeAddress()))
+			}
+			funded = append(funded, unspent...)
+		}
+
+		if len(funded) == 0 {
+			continue
+		}
+
+		var tx *wire.MsgTx
+		var err error
+		if backupSigner != nil {
+			tx, err = group.encoder.(*injector.WitnessScriptEncoder).BuildReclaim(funded, signer.PubKey(), backupSigner, pkScript, feeRate)
+		} else if group.encoder == nil {
+			tx, err = injector.NewDepositRefund(funded, feeRate, signer, netParams).BuildRefund(pkScript)
+		} else {
+			tx, err = injector.NewRefund(group.encoder, funded, feeRate, signer, netParams).BuildRefund(pkScript)
+		}
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not build refund transaction.")
+			os.Exit(1)
+		}
+
+		txs = append(txs, tx)
+	}
+
+	return txs
+}
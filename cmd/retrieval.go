@@ -1,15 +1,24 @@
 package cmd
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/aureleoules/bitcandle/electrum"
 	"github.com/aureleoules/bitcandle/injector"
+	"github.com/aureleoules/bitcandle/payload"
 	"github.com/briandowns/spinner"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/guumaster/logsymbols"
 	"github.com/spf13/cobra"
 	"github.com/thediveo/enumflag"
@@ -19,12 +28,20 @@ var (
 	txHash         string
 	outputFile     string
 	electrumServer string
+	decrypt        bool
+	wif            string
+	author         string
+	forceSave      bool
 )
 
 func init() {
 	retrieveCmd.Flags().StringVar(&txHash, "tx", "", "txid of the file to retrieve")
-	retrieveCmd.Flags().StringVarP(&outputFile, "output", "o", "", "output file path")
+	retrieveCmd.Flags().StringVarP(&outputFile, "output", "o", "", "output file path (defaults to the original file name)")
 	retrieveCmd.Flags().StringVarP(&electrumServer, "server", "s", "", "electrum server")
+	retrieveCmd.Flags().BoolVar(&decrypt, "decrypt", false, "decrypt the file with a passphrase")
+	retrieveCmd.Flags().StringVar(&wif, "key", "", "private key (WIF) of a recipient of the file")
+	retrieveCmd.Flags().StringVar(&author, "author", "", "only accept files injected by this public key (hex)")
+	retrieveCmd.Flags().BoolVar(&forceSave, "force", false, "save the file even if it does not match its checksum")
 
 	retrieveCmd.PersistentFlags().VarP(
 		enumflag.New(&network, "network", NetworkIds, enumflag.EnumCaseInsensitive), "network", "n", "bitcoin network; can be 'mainnet', 'testnet' or 'regtest'")
@@ -40,8 +57,15 @@ var retrieveCmd = &cobra.Command{
 			errRetrieveHelp("no txid was provided")
 		}
 
-		if outputFile == "" {
-			errRetrieveHelp("no output path was specified")
+		var authorKey *btcec.PublicKey
+		if author != "" {
+			b, err := hex.DecodeString(author)
+			if err == nil {
+				authorKey, err = btcec.ParsePubKey(b)
+			}
+			if err != nil {
+				errRetrieveHelp("invalid author public key")
+			}
 		}
 
 		if electrumServer == "" {
@@ -60,35 +84,276 @@ var retrieveCmd = &cobra.Command{
 		s.Stop()
 		fmt.Println(logsymbols.Success, "Connected to electrum server ("+electrumServer+").")
 
-		rawtx, err := electrum.Client.GetRawTransaction(txHash)
+		data := retrieveFile(txHash, authorKey)
+
+		fmt.Println(logsymbols.Success, "Retrieved file.")
+
+		data, err = decryptPayload(data)
 		if err != nil {
-			fmt.Println(logsymbols.Error, "Could not retrieve transaction.")
+			errRetrieveHelp(err.Error())
+		}
+
+		header, data, err := payload.Decode(data)
+		switch {
+		case err == payload.ErrNoHeader:
+			// Files injected before payloads were introduced cannot be verified
+			fmt.Println(logsymbols.Warn, "File has no header, its integrity cannot be verified.")
+		case err != nil:
+			fmt.Println(logsymbols.Error, "Could not parse payload header.")
 			os.Exit(1)
+		default:
+			fmt.Println(logsymbols.Info, fmt.Sprintf("Name: %s, type: %s, size: %d bytes.", header.Name, header.MIMEType, header.Length))
+
+			if header.Compression != payload.NoCompression {
+				fmt.Println(logsymbols.Info, "Decompressed "+header.Compression.String()+" payload.")
+			}
+
+			err = header.Verify(data)
+			if err != nil && !forceSave {
+				fmt.Println(logsymbols.Error, "File is corrupted: "+err.Error()+", use --force to save it anyway.")
+				os.Exit(1)
+			} else if err != nil {
+				fmt.Println(logsymbols.Warn, "File is corrupted: "+err.Error()+", saving it anyway.")
+			} else {
+				fmt.Println(logsymbols.Success, "File is intact (SHA-256 "+hex.EncodeToString(header.Checksum[:])+").")
+			}
+
+			// Suggest the original file name
+			if outputFile == "" && header.Name != "" {
+				outputFile = filepath.Base(header.Name)
+			}
 		}
 
-		rawtxBytes, err := hex.DecodeString(rawtx)
+		if outputFile == "" {
+			errRetrieveHelp("no output path was specified")
+		}
+
+		err = ioutil.WriteFile(outputFile, data, 0644)
 		if err != nil {
-			s.Stop()
-			fmt.Println(logsymbols.Error, "Could not decode transaction hex.")
+			fmt.Println(logsymbols.Error, "Could not write to output file.")
 			os.Exit(1)
 		}
 
-		data, err := injector.P2SHRetrieveData(rawtxBytes)
+		fmt.Println(logsymbols.Success, "Saved file to \""+outputFile+"\".")
+	},
+}
+
+// decryptPayload decrypts retrieved data with the passphrase or the key of a recipient if it is encrypted
+// An error is returned if the required flag is missing
+func decryptPayload(data []byte) ([]byte, error) {
+	switch {
+	case payload.IsEncrypted(data):
+		if !decrypt {
+			return nil, errors.New("file is encrypted, use --decrypt")
+		}
+
+		passphrase, err := readPassphrase(false)
 		if err != nil {
-			fmt.Println(logsymbols.Error, "Could not parse data.")
+			return nil, err
+		}
+
+		data, err = payload.Decrypt(data, passphrase)
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Could not decrypt file: "+err.Error()+".")
 			os.Exit(1)
 		}
 
-		fmt.Println(logsymbols.Success, "Retrieved file.")
+		fmt.Println(logsymbols.Success, "Decrypted file.")
+	case payload.IsEncryptedToRecipients(data):
+		if wif == "" {
+			return nil, errors.New("file is encrypted to recipients, use --key")
+		}
 
-		err = ioutil.WriteFile(outputFile, data, 0644)
+		key, err := btcutil.DecodeWIF(wif)
 		if err != nil {
-			fmt.Println(logsymbols.Error, "Could not write to output file.")
+			return nil, errors.New("invalid private key")
+		}
+
+		data, err = payload.DecryptWithKey(data, key.PrivKey)
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Could not decrypt file: "+err.Error()+".")
 			os.Exit(1)
 		}
 
-		fmt.Println(logsymbols.Success, "Saved file to \""+outputFile+"\".")
-	},
+		fmt.Println(logsymbols.Success, "Decrypted file.")
+	case decrypt || wif != "":
+		fmt.Println(logsymbols.Warn, "File is not encrypted.")
+	}
+
+	return data, nil
+}
+
+// retrieveFile fetches the data injected in a transaction
+// Files injected in several transactions are referenced by a manifest, all parts are fetched
+// If an author is given, only data injected by this public key is accepted
+func retrieveFile(txid string, author *btcec.PublicKey) []byte {
+	retrieval, err := retrieveData(txid, author)
+	if err != nil {
+		fmt.Println(logsymbols.Error, err.Error())
+		os.Exit(1)
+	}
+
+	if retrieval.Author != nil {
+		fmt.Println(logsymbols.Success, "Signatures verified, injected by "+hex.EncodeToString(retrieval.Author.SerializeCompressed())+".")
+	} else {
+		fmt.Println(logsymbols.Warn, "Could not identify the author of the file.")
+	}
+
+	// Data that does not parse as a manifest is the file itself
+	manifest, err := injector.DecodeManifest(retrieval.Data)
+	if err != nil {
+		return retrieval.Data
+	}
+
+	// Parts must be injected by the author of the manifest
+	if manifest.IsErasureCoded() {
+		return retrieveShards(manifest, retrieval.Author)
+	}
+
+	fmt.Println(logsymbols.Info, fmt.Sprintf("Found manifest of %d parts.", len(manifest.TxIDs)))
+
+	var data []byte
+	for k, txid := range manifest.TxIDs {
+		part, err := retrieveData(txid.String(), retrieval.Author)
+		if err != nil {
+			fmt.Println(logsymbols.Error, err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Println(logsymbols.Success, fmt.Sprintf("Retrieved part %d/%d.", k+1, len(manifest.TxIDs)))
+		data = append(data, part.Data...)
+	}
+
+	return data
+}
+
+// retrieveShards fetches as many shards as possible and rebuilds the file
+// Shards that cannot be fetched, are injected by another key or do not match their checksum are reported and skipped
+func retrieveShards(manifest *injector.Manifest, author *btcec.PublicKey) []byte {
+	fmt.Println(logsymbols.Info, fmt.Sprintf("Found manifest of %d shards, %d required.", len(manifest.TxIDs), manifest.DataShards))
+
+	shards := make([][]byte, len(manifest.TxIDs))
+	for k, txid := range manifest.TxIDs {
+		shard, err := retrieveData(txid.String(), author)
+		if err != nil {
+			fmt.Println(logsymbols.Warn, fmt.Sprintf("Shard %d/%d is missing (%s): %s", k+1, len(shards), txid, err))
+			continue
+		}
+
+		shards[k] = shard.Data
+	}
+
+	for _, k := range injector.CheckShards(manifest, shards) {
+		fmt.Println(logsymbols.Warn, fmt.Sprintf("Shard %d/%d is corrupted (%s).", k+1, len(shards), manifest.TxIDs[k]))
+	}
+
+	data, err := injector.JoinShards(manifest, shards)
+	if err != nil {
+		fmt.Println(logsymbols.Error, "Could not rebuild file: "+err.Error()+".")
+		os.Exit(1)
+	}
+
+	fmt.Println(logsymbols.Success, "Rebuilt file from shards.")
+
+	return data
+}
+
+// retrieveData fetches a transaction, decodes the data it holds and verifies the signatures of its author
+func retrieveData(txid string, author *btcec.PublicKey) (*injector.Retrieval, error) {
+	rawTx, err := fetchTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	retrieval, err := injector.RetrieveData(rawTx)
+	if _, ok := err.(*injector.IntegrityError); ok {
+		return nil, errors.New("Data of transaction " + txid + " is corrupted: " + err.Error() + ".")
+	}
+	if err != nil {
+		return nil, errors.New("Could not parse data.")
+	}
+
+	// The server could return any transaction
+	if retrieval.Tx.TxHash().String() != txid {
+		return nil, errors.New("Transaction " + txid + " does not match the requested txid.")
+	}
+
+	height, blockHash, err := proveInclusion(retrieval.Tx)
+	if err != nil {
+		return nil, errors.New("Could not prove inclusion of transaction " + txid + " in a block: " + err.Error() + ".")
+	}
+
+	if height == 0 {
+		fmt.Println(logsymbols.Warn, "Transaction "+txid+" is not confirmed yet.")
+	} else {
+		fmt.Println(logsymbols.Success, fmt.Sprintf("Transaction %s is included in block %s at height %d.", txid, blockHash, height))
+	}
+
+	if author != nil && !retrieval.IsAuthor(author) {
+		return nil, errors.New("Transaction " + txid + " was not injected by " + hex.EncodeToString(author.SerializeCompressed()) + ".")
+	}
+
+	if retrieval.Author == nil {
+		return retrieval, nil
+	}
+
+	prevOuts, err := fetchPrevOuts(retrieval.PrevOuts())
+	if err != nil {
+		return nil, err
+	}
+
+	err = retrieval.VerifySignatures(prevOuts)
+	if err != nil {
+		return nil, errors.New("Invalid signatures in transaction " + txid + ": " + err.Error() + ".")
+	}
+
+	return retrieval, nil
+}
+
+// fetchTransaction fetches a raw transaction from the electrum server
+func fetchTransaction(txid string) ([]byte, error) {
+	rawtx, err := electrum.Client.GetRawTransaction(txid)
+	if err != nil {
+		return nil, errors.New("Could not retrieve transaction.")
+	}
+
+	rawtxBytes, err := hex.DecodeString(rawtx)
+	if err != nil {
+		return nil, errors.New("Could not decode transaction hex.")
+	}
+
+	return rawtxBytes, nil
+}
+
+// fetchPrevOuts fetches the outputs spent by a transaction, they are needed to verify signatures
+func fetchPrevOuts(outPoints []wire.OutPoint) (txscript.PrevOutputFetcher, error) {
+	prevOuts := txscript.NewMultiPrevOutFetcher(nil)
+	txs := make(map[chainhash.Hash]*wire.MsgTx)
+
+	for _, outPoint := range outPoints {
+		tx, ok := txs[outPoint.Hash]
+		if !ok {
+			rawTx, err := fetchTransaction(outPoint.Hash.String())
+			if err != nil {
+				return nil, err
+			}
+
+			tx = wire.NewMsgTx(wire.TxVersion)
+			err = tx.Deserialize(bytes.NewReader(rawTx))
+			if err != nil {
+				return nil, errors.New("Could not decode transaction.")
+			}
+			txs[outPoint.Hash] = tx
+		}
+
+		if int(outPoint.Index) >= len(tx.TxOut) {
+			return nil, errors.New("Could not find output " + outPoint.String() + ".")
+		}
+
+		prevOuts.AddPrevOut(outPoint, tx.TxOut[outPoint.Index])
+	}
+
+	return prevOuts, nil
 }
 
 func errRetrieveHelp(err string) {
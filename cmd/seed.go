@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/aureleoules/bitcandle/injector"
+	"github.com/aureleoules/bitcandle/keystore"
+	"github.com/aureleoules/bitcandle/payload"
+	"github.com/aureleoules/bitcandle/seed"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/guumaster/logsymbols"
+	"github.com/spf13/cobra"
+	"github.com/thediveo/enumflag"
+)
+
+func init() {
+	seedMigrateCmd.Flags().StringVarP(&filePath, "file", "f", "", "path of the file whose legacy key is migrated")
+	seedMigrateCmd.Flags().IntVar(&feeRate, "fee", 5, "fee rate (sat/B)")
+	seedMigrateCmd.Flags().StringVar(&method, "method", "auto", "injection method used; can be 'auto' (all methods) or "+encoderNames())
+	seedMigrateCmd.Flags().StringVar(&compress, "compress", "none", "compression used by the injection; can be 'none', 'gzip', 'zstd' or 'brotli'")
+	seedMigrateCmd.Flags().StringVar(&shards, "shards", "", "erasure coding used by the injection (e.g. '4-of-6')")
+	seedMigrateCmd.Flags().BoolVar(&nonStandard, "non-standard", false, "the injection used 520 bytes chunks (requires --export)")
+	seedMigrateCmd.Flags().StringVar(&exportPath, "export", "", "write signed transactions to this file instead of broadcasting them")
+	seedMigrateCmd.Flags().StringVarP(&electrumServer, "server", "s", "", "electrum server")
+	seedMigrateCmd.PersistentFlags().VarP(
+		enumflag.New(&network, "network", NetworkIds, enumflag.EnumCaseInsensitive), "network", "n", "bitcoin network; can be 'mainnet', 'testnet' or 'regtest'")
+
+	seedCmd.AddCommand(seedInitCmd)
+	seedCmd.AddCommand(seedShowCmd)
+	seedCmd.AddCommand(seedMigrateCmd)
+	rootCmd.AddCommand(seedCmd)
+}
+
+// loadSeed decrypts the seed injection keys are derived from, it is created on first use
+func loadSeed() *seed.Seed {
+	ks := openKeystore()
+	if !ks.HasSeed() {
+		return createSeed(ks)
+	}
+
+	mnemonic, err := ks.Seed(unlockKeystore(ks))
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not decrypt seed.")
+		os.Exit(1)
+	}
+
+	s, err := seed.FromMnemonic(mnemonic)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not load seed.")
+		os.Exit(1)
+	}
+
+	return s
+}
+
+// createSeed generates a new seed, saves it to the keystore and prints its mnemonic
+func createSeed(ks *keystore.Keystore) *seed.Seed {
+	s, err := seed.New()
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not generate seed.")
+		os.Exit(1)
+	}
+
+	err = ks.SaveSeed(s.Mnemonic, unlockKeystore(ks))
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not save seed.")
+		os.Exit(1)
+	}
+
+	fmt.Println(logsymbols.Success, "Saved new encrypted seed to the keystore.")
+	fmt.Println(s.Mnemonic)
+	fmt.Println(logsymbols.Warn, "Write down this mnemonic, it recovers the keys of all your injections.")
+
+	return s
+}
+
+// seedKey derives the injection key of a file from the seed and the hash of its content
+func seedKey(data []byte) *btcec.PrivateKey {
+	key, err := loadSeed().Key(sha256.Sum256(data), loadChainParams(network))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return key
+}
+
+var seedCmd = &cobra.Command{
+	Use:   "seed",
+	Short: "Manage the mnemonic injection keys are derived from",
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+var seedInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generate a new seed",
+	Run: func(cmd *cobra.Command, args []string) {
+		ks := openKeystore()
+		if ks.HasSeed() {
+			fmt.Println(logsymbols.Error, "A seed already exists, see \"bitcandle seed show\".")
+			os.Exit(1)
+		}
+
+		createSeed(ks)
+	},
+}
+
+var seedShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the mnemonic of the seed",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !openKeystore().HasSeed() {
+			fmt.Println(logsymbols.Error, "No seed found, create one with \"bitcandle seed init\".")
+			os.Exit(1)
+		}
+
+		fmt.Println(loadSeed().Mnemonic)
+	},
+}
+
+var seedMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move the funds of a legacy key to the deposit address of the key derived from the seed",
+	Run: func(cmd *cobra.Command, args []string) {
+		if filePath == "" {
+			errCommandHelp(cmd, "missing file path")
+		}
+
+		if nonStandard && exportPath == "" {
+			errCommandHelp(cmd, "non-standard transactions must be exported with --export")
+		}
+
+		compression, err := payload.ParseCompression(compress)
+		if err != nil {
+			errCommandHelp(cmd, err.Error())
+		}
+
+		if shards != "" {
+			dataShards, parityShards, err = parseShards(shards)
+			if err != nil {
+				errCommandHelp(cmd, err.Error())
+			}
+		}
+
+		netParams := loadChainParams(network)
+
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			errCommandHelp(cmd, err.Error())
+		}
+
+		raw, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			errCommandHelp(cmd, err.Error())
+		}
+
+		header := payload.NewHeader(raw, fileInfo.Name(), detectMIMEType(filePath, raw), compression)
+		data, err := payload.Encode(header, raw)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not compress file.")
+			os.Exit(1)
+		}
+
+		ks := openKeystore()
+		legacy := findLegacyEntry(ks, fileInfo.Name(), raw)
+		legacyKey := loadEntryKey(ks, legacy)
+
+		// Funds are sent to the deposit address of the new key so that the injection can be resumed from them
+		key := seedKey(raw)
+		deposit, err := injector.DepositAddress(key.PubKey(), netParams)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		pkScript, err := txscript.PayToAddrScript(deposit)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		signer := injector.NewKeySigner(legacyKey)
+		groups, err := refundGroups(data, legacy, signer)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not rebuild injection addresses.")
+			os.Exit(1)
+		}
+
+		connectElectrum()
+
+		txs := buildRefunds(groups, signer, nil, pkScript, netParams)
+
+		if exportPath != "" && len(txs) > 0 {
+			exportTXs(txs)
+		} else {
+			for _, tx := range txs {
+				broadcastTX(tx, fmt.Sprintf("Moved %.8f BTC to %s.", float64(tx.TxOut[0].Value)/consensus.BTCSats, deposit.EncodeAddress()))
+			}
+		}
+
+		// The legacy key is kept, it is no longer preferred over the key derived from the seed
+		legacy.Origin = keystore.OriginMigrated
+		err = ks.Save(legacy)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not update keystore.")
+			os.Exit(1)
+		}
+
+		fmt.Println(logsymbols.Success, "Migrated legacy key "+legacy.ID+".")
+	},
+}
+
+// findLegacyEntry returns the keystore entry of the legacy key of a file, a key file in clear is moved to the keystore first
+func findLegacyEntry(ks *keystore.Keystore, name string, raw []byte) *keystore.Entry {
+	entries, err := ks.Find(sha256.Sum256(raw), loadChainParams(network))
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println(logsymbols.Error, "Could not read keystore.")
+		os.Exit(1)
+	}
+
+	for _, entry := range entries {
+		if entry.Origin == keystore.OriginLegacy {
+			return entry
+		}
+	}
+
+	keyFilePath := legacyKeyPath(name, raw)
+	key, err := loadKey(keyFilePath)
+	if err != nil {
+		fmt.Println(logsymbols.Error, "No legacy key found for this file.")
+		os.Exit(1)
+	}
+
+	entry := storeKey(ks, name, raw, keystore.OriginLegacy, key)
+	err = os.Remove(keyFilePath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return entry
+}
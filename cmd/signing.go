@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/aureleoules/bitcandle/funding"
+	"github.com/aureleoules/bitcandle/injector"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/guumaster/logsymbols"
+)
+
+var (
+	signerPubKey  string
+	signerOrigin  string
+	unsignedPSBTs string
+	signedPSBTs   string
+)
+
+// loadSigner returns the signer of the injection transactions
+// The private key of a file is derived from the seed, unless an external signer holds it
+func loadSigner(name string, data []byte) injector.Signer {
+	if signerPubKey == "" {
+		if unsignedPSBTs != "" || signedPSBTs != "" || signerOrigin != "" {
+			errInjectHelp("--unsigned-psbts, --signed-psbts and --pubkey-origin require --pubkey")
+		}
+
+		return injector.NewKeySigner(loadInjectionKey(name, data))
+	}
+
+	if unsignedPSBTs == "" && signedPSBTs == "" {
+		errInjectHelp("--pubkey requires --unsigned-psbts or --signed-psbts")
+	}
+
+	// Origins are written as in descriptors, brackets are optional
+	var fingerprint uint32
+	var path []uint32
+	if signerOrigin != "" {
+		var err error
+		fingerprint, path, err = funding.ParseKeyOrigin(strings.TrimSuffix(strings.TrimPrefix(signerOrigin, "["), "]"))
+		if err != nil {
+			errInjectHelp(err.Error())
+		}
+	}
+
+	b, err := hex.DecodeString(signerPubKey)
+	if err != nil {
+		errInjectHelp("invalid public key: " + signerPubKey)
+	}
+
+	pubKey, err := btcec.ParsePubKey(b)
+	if err != nil {
+		errInjectHelp("invalid public key: " + signerPubKey)
+	}
+
+	var signed []*psbt.Packet
+	if signedPSBTs != "" {
+		signed = readPSBTs(signedPSBTs)
+		fmt.Println(logsymbols.Success, fmt.Sprintf("Loaded %d signed PSBTs.", len(signed)))
+	}
+
+	signer := injector.NewPSBTSigner(pubKey, signed)
+	if signerOrigin != "" {
+		signer = signer.WithOrigin(fingerprint, path)
+	}
+
+	return signer
+}
+
+// requestSignatures writes the PSBTs of the transactions an external signer must sign
+// It returns false if all transactions are signed
+func requestSignatures(signer injector.Signer) bool {
+	psbtSigner, ok := signer.(*injector.PSBTSigner)
+	if !ok || len(psbtSigner.Unsigned()) == 0 {
+		return false
+	}
+
+	// Transactions built again from the same payments must match the signed PSBTs
+	if signedPSBTs != "" {
+		fmt.Println(logsymbols.Error, "Signed PSBTs do not match the injection transactions.")
+		os.Exit(1)
+	}
+
+	var lines []string
+	for _, packet := range psbtSigner.Unsigned() {
+		var buf bytes.Buffer
+		err := packet.Serialize(&buf)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		lines = append(lines, base64.StdEncoding.EncodeToString(buf.Bytes()))
+	}
+
+	err := ioutil.WriteFile(unsignedPSBTs, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		fmt.Println(logsymbols.Error, "Could not write unsigned PSBTs.")
+		os.Exit(1)
+	}
+
+	fmt.Println(logsymbols.Success, fmt.Sprintf("Wrote %d unsigned PSBTs to \"%s\".", len(lines), unsignedPSBTs))
+	fmt.Println(logsymbols.Info, "Sign them with the injection key and run again with --signed-psbts.")
+	return true
+}
+
+// readPSBTs loads PSBTs encoded in base64, one per line
+func readPSBTs(path string) []*psbt.Packet {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		errInjectHelp(err.Error())
+	}
+
+	var packets []*psbt.Packet
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		packet, err := psbt.NewFromRawBytes(strings.NewReader(line), true)
+		if err != nil {
+			errInjectHelp("invalid PSBT: " + err.Error())
+		}
+
+		packets = append(packets, packet)
+	}
+
+	return packets
+}
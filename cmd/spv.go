@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/aureleoules/bitcandle/electrum"
+	"github.com/aureleoules/bitcandle/spv"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// proveInclusion checks the Merkle branch of a transaction against the header of its block
+// The header is verified from a checkpoint, but the server could still hide a better chain
+// It returns the height and hash of the block, or a height of 0 if the transaction is unconfirmed
+func proveInclusion(tx *wire.MsgTx) (int32, *chainhash.Hash, error) {
+	proof, err := fetchProof(tx)
+	if err != nil || proof == nil {
+		return 0, nil, err
+	}
+
+	err = proof.Verify(tx.TxHash(), loadChainParams(network))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	blockHash := proof.Header.BlockHash()
+	return proof.Height, &blockHash, nil
+}
+
+// fetchProof fetches the Merkle branch of a transaction and the verified header of its block
+// No proof is returned if the transaction is unconfirmed
+func fetchProof(tx *wire.MsgTx) (*spv.Proof, error) {
+	txid := tx.TxHash()
+
+	height, err := txHeight(tx)
+	if err != nil || height == 0 {
+		return nil, err
+	}
+
+	res, err := electrum.Client.GetMerkleProof(txid.String(), uint32(height))
+	if err != nil {
+		return nil, errors.New("could not fetch merkle proof")
+	}
+
+	if res.Height != uint32(height) {
+		return nil, errors.New("merkle proof is for another block")
+	}
+
+	proof := spv.Proof{Height: height, Position: res.Position}
+	for _, h := range res.Merkle {
+		hash, err := chainhash.NewHashFromStr(h)
+		if err != nil {
+			return nil, errors.New("invalid merkle proof")
+		}
+		proof.Branch = append(proof.Branch, *hash)
+	}
+
+	header, err := fetchBlockHeader(height)
+	if err != nil {
+		return nil, err
+	}
+	proof.Header = *header
+
+	return &proof, nil
+}
+
+// headerChain holds the headers verified by the last call to fetchBlockHeader, so that parts of a file do not fetch them again
+var headerChain struct {
+	start   int32
+	headers []wire.BlockHeader
+}
+
+// maxHeadersPerRequest is the number of headers electrum servers return at most per request
+const maxHeadersPerRequest = 2016
+
+// fetchBlockHeader fetches the header of the block at a height along with the chain from the last checkpoint below it
+// The header is only returned if the chain meets the difficulty rules of the network, which takes a few hundred thousand headers on mainnet
+// The chain is kept to check the following headers
+func fetchBlockHeader(height int32) (*wire.BlockHeader, error) {
+	params := loadChainParams(network)
+
+	if len(headerChain.headers) == 0 || height < headerChain.start {
+		headerChain.start = spv.Anchor(height, params)
+		headerChain.headers = nil
+	}
+
+	if height < headerChain.start+int32(len(headerChain.headers)) {
+		return &headerChain.headers[height-headerChain.start], nil
+	}
+
+	headers := headerChain.headers
+	for next := headerChain.start + int32(len(headers)); next <= height; next = headerChain.start + int32(len(headers)) {
+		count := height - next + 1
+		if count > maxHeadersPerRequest {
+			count = maxHeadersPerRequest
+		}
+
+		res, err := electrum.Client.GetBlockHeaders(uint32(next), uint32(count))
+		if err != nil {
+			return nil, errors.New("could not fetch block headers")
+		}
+
+		raw, err := hex.DecodeString(res.Headers)
+		if err != nil || len(raw) == 0 || len(raw)%wire.MaxBlockHeaderPayload != 0 {
+			return nil, errors.New("invalid block headers")
+		}
+
+		r := bytes.NewReader(raw)
+		for r.Len() > 0 {
+			var header wire.BlockHeader
+			err = header.Deserialize(r)
+			if err != nil {
+				return nil, errors.New("invalid block headers")
+			}
+			headers = append(headers, header)
+		}
+	}
+
+	err := spv.VerifyChain(headerChain.start, headers, params)
+	if err != nil {
+		headerChain.headers = nil
+		return nil, errors.New("invalid header chain: " + err.Error())
+	}
+	headerChain.headers = headers
+
+	return &headers[height-headerChain.start], nil
+}
+
+// confirmationHeight looks up the height of a transaction
+func confirmationHeight(txid string) (int32, error) {
+	rawTx, err := fetchTransaction(txid)
+	if err != nil {
+		return 0, err
+	}
+
+	var tx wire.MsgTx
+	err = tx.Deserialize(bytes.NewReader(rawTx))
+	if err != nil {
+		return 0, err
+	}
+
+	return txHeight(&tx)
+}
+
+// txHeight looks up the height of a transaction using one of its outputs
+func txHeight(tx *wire.MsgTx) (int32, error) {
+	// OP_RETURN outputs are not indexed by electrum servers
+	for _, output := range tx.TxOut {
+		if txscript.GetScriptClass(output.PkScript) != txscript.NullDataTy {
+			return electrum.ConfirmationHeight(tx.TxHash().String(), output.PkScript)
+		}
+	}
+
+	return 0, errors.New("no indexed output")
+}
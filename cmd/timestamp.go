@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aureleoules/bitcandle/timestamp"
+	"github.com/guumaster/logsymbols"
+	"github.com/spf13/cobra"
+	"github.com/thediveo/enumflag"
+)
+
+var (
+	proofDir  string
+	proofPath string
+)
+
+func init() {
+	timestampCmd.Flags().StringVar(&proofDir, "proofs", ".", "directory where the inclusion proofs are written")
+	timestampCmd.Flags().StringVarP(&changeAddress, "change-address", "c", "", "address to receive change (548 sats)")
+	timestampCmd.Flags().IntVar(&feeRate, "fee", 5, "fee rate (sat/B)")
+	timestampCmd.Flags().StringVar(&method, "method", "auto", "injection method; can be 'auto' (cheapest) or "+encoderNames())
+	timestampCmd.Flags().BoolVar(&payToMany, "pay-to-many", false, "fund every injection address separately instead of a single deposit address")
+	timestampCmd.Flags().StringVar(&exportPath, "export", "", "write signed transactions to this file instead of broadcasting them")
+	timestampCmd.Flags().StringVar(&signerPubKey, "pubkey", "", "public key (hex) of an external signer holding the injection key, instead of a local key")
+	timestampCmd.Flags().StringVar(&signerOrigin, "pubkey-origin", "", "origin of the external signer key, as in descriptors: [fingerprint/path]")
+	timestampCmd.Flags().StringVar(&unsignedPSBTs, "unsigned-psbts", "", "write the PSBTs of the transactions the external signer must sign to this file")
+	timestampCmd.Flags().StringVar(&signedPSBTs, "signed-psbts", "", "finalize the transactions with the PSBTs signed by the external signer")
+	timestampCmd.Flags().StringVarP(&electrumServer, "server", "s", "", "electrum server")
+	timestampCmd.PersistentFlags().VarP(
+		enumflag.New(&network, "network", NetworkIds, enumflag.EnumCaseInsensitive), "network", "n", "bitcoin network; can be 'mainnet', 'testnet' or 'regtest'")
+
+	verifyTimestampCmd.Flags().StringVarP(&filePath, "file", "f", "", "path of the timestamped file")
+	verifyTimestampCmd.Flags().StringVar(&proofPath, "proof", "", "path of the inclusion proof of the file")
+	verifyTimestampCmd.Flags().StringVarP(&electrumServer, "server", "s", "", "electrum server")
+	verifyTimestampCmd.PersistentFlags().VarP(
+		enumflag.New(&network, "network", NetworkIds, enumflag.EnumCaseInsensitive), "network", "n", "bitcoin network; can be 'mainnet', 'testnet' or 'regtest'")
+
+	rootCmd.AddCommand(timestampCmd)
+	rootCmd.AddCommand(verifyTimestampCmd)
+}
+
+var timestampCmd = &cobra.Command{
+	Use:   "timestamp <files...>",
+	Short: "Timestamp many files by injecting the Merkle root of their hashes",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var hashes [][sha256.Size]byte
+		for _, path := range args {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				errCommandHelp(cmd, err.Error())
+			}
+
+			hashes = append(hashes, sha256.Sum256(data))
+		}
+
+		root, paths := timestamp.Tree(hashes)
+		record := timestamp.EncodeRecord(root, len(hashes))
+
+		fmt.Println(logsymbols.Success, fmt.Sprintf("Hashed %d files, Merkle root is %s.", len(hashes), hex.EncodeToString(root[:])))
+
+		netParams := loadChainParams(network)
+		signer := loadSigner("timestamp", record)
+		payToAddrScript := changeScript(signer.PubKey(), netParams)
+
+		plan, err := buildPlan(record, signer, netParams)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(logsymbols.Error, "Could not prepare injection data.")
+			os.Exit(1)
+		}
+
+		printMethod(plan)
+
+		recordPlan(record, plan, signer, netParams)
+
+		connectElectrum()
+
+		estimateCost(plan)
+
+		txs, broadcast := executePlan(plan, signer, payToAddrScript)
+		txid := txs[len(txs)-1].TxHash().String()
+
+		// Transactions waiting for the external signer may never be broadcast, proofs are written when running again with the signed PSBTs
+		if !broadcast && exportPath == "" {
+			fmt.Println(logsymbols.Info, "Proofs will be written once the transactions are signed and broadcast with --signed-psbts.")
+			return
+		}
+
+		err = os.MkdirAll(proofDir, 0755)
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Could not create proofs directory.")
+			os.Exit(1)
+		}
+
+		for i, path := range args {
+			proof := timestamp.Proof{
+				Version: timestamp.Version,
+				Network: netParams.Name,
+				TxID:    txid,
+				Root:    hex.EncodeToString(root[:]),
+				Name:    filepath.Base(path),
+				SHA256:  hex.EncodeToString(hashes[i][:]),
+				Path:    paths[i],
+			}
+
+			b, err := json.MarshalIndent(proof, "", "  ")
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			// Files of the same name in different directories get different proofs
+			proofFile := filepath.Join(proofDir, fmt.Sprintf("%s.%d.timestamp.json", filepath.Base(path), i))
+			err = ioutil.WriteFile(proofFile, b, 0644)
+			if err != nil {
+				fmt.Println(logsymbols.Error, "Could not write proof of "+path+".")
+				os.Exit(1)
+			}
+		}
+
+		fmt.Println(logsymbols.Success, fmt.Sprintf("Saved %d proofs to \"%s\".", len(args), proofDir))
+
+		// Exported transactions are broadcast by the user, proofs refer to a transaction that may not exist yet
+		if !broadcast {
+			fmt.Println(logsymbols.Warn, "Proofs are only valid once the exported transaction "+txid+" is broadcast and confirmed.")
+		}
+	},
+}
+
+var verifyTimestampCmd = &cobra.Command{
+	Use:   "verify-timestamp",
+	Short: "Check that a file was timestamped using its inclusion proof",
+	Run: func(cmd *cobra.Command, args []string) {
+		if filePath == "" {
+			errCommandHelp(cmd, "no file was provided")
+		}
+
+		if proofPath == "" {
+			errCommandHelp(cmd, "no proof was provided")
+		}
+
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Could not read file.")
+			os.Exit(1)
+		}
+
+		b, err := ioutil.ReadFile(proofPath)
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Could not read proof.")
+			os.Exit(1)
+		}
+
+		var proof timestamp.Proof
+		err = json.Unmarshal(b, &proof)
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Could not parse proof.")
+			os.Exit(1)
+		}
+
+		err = proof.Verify(sha256.Sum256(data))
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Invalid proof: "+err.Error()+".")
+			os.Exit(1)
+		}
+
+		fmt.Println(logsymbols.Success, "File is committed by Merkle root "+proof.Root+".")
+
+		connectElectrum()
+
+		retrieval, err := retrieveData(proof.TxID, nil)
+		if err != nil {
+			fmt.Println(logsymbols.Error, err.Error())
+			os.Exit(1)
+		}
+
+		root, _, err := timestamp.DecodeRecord(retrieval.Data)
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Transaction does not hold a timestamp: "+err.Error()+".")
+			os.Exit(1)
+		}
+
+		if hex.EncodeToString(root[:]) != proof.Root {
+			fmt.Println(logsymbols.Error, "Merkle root does not match the one injected on chain.")
+			os.Exit(1)
+		}
+
+		fmt.Println(logsymbols.Success, "File was timestamped in transaction "+proof.TxID+".")
+	},
+}
@@ -1,12 +1,47 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/btcsuite/btcd/btcec"
+	"github.com/aureleoules/bitcandle/electrum"
+	"github.com/briandowns/spinner"
+	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/guumaster/logsymbols"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
+// connectElectrum connects to the electrum server of the network, or to the one specified
+func connectElectrum() {
+	if electrumServer == "" {
+		electrumServer = getDefaultElectrumServer(network)
+	}
+
+	s := spinner.New(spinner.CharSets[9], 100*time.Millisecond, spinner.WithSuffix(" Connecting to electrum server..."))
+	s.Start()
+
+	err := electrum.Connect(electrumServer)
+	s.Stop()
+	if err != nil {
+		fmt.Println(logsymbols.Error, "Could not connect to electrum server.")
+		os.Exit(1)
+	}
+
+	fmt.Println(logsymbols.Success, "Connected to electrum server ("+electrumServer+").")
+}
+
+// passphraseEnv allows providing the passphrase without a terminal, e.g. in scripts
+const passphraseEnv = "BITCANDLE_PASSPHRASE"
+
 func loadChainParams(net Network) *chaincfg.Params {
 	switch net {
 	case Mainnet:
@@ -26,7 +61,7 @@ func loadKey(path string) (*btcec.PrivateKey, error) {
 		return nil, err
 	}
 
-	pKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), key)
+	pKey, _ := btcec.PrivKeyFromBytes(key)
 	return pKey, nil
 }
 
@@ -41,3 +76,59 @@ func getDefaultElectrumServer(network Network) string {
 	}
 	return ""
 }
+
+// detectMIMEType guesses the content type of a file from its extension, or from its content otherwise
+func detectMIMEType(path string, data []byte) string {
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType != "" {
+		return mimeType
+	}
+
+	return http.DetectContentType(data)
+}
+
+// readPassphrase prompts for the passphrase of a payload without echoing it
+// New passphrases are asked twice to prevent typos, data encrypted with a mistyped passphrase would be lost
+func readPassphrase(confirm bool) (string, error) {
+	return promptPassphrase(passphraseEnv, "Passphrase", confirm)
+}
+
+// promptPassphrase reads a passphrase from an environment variable or from the terminal
+func promptPassphrase(env string, prompt string, confirm bool) (string, error) {
+	if passphrase, ok := os.LookupEnv(env); ok {
+		return passphrase, nil
+	}
+
+	fmt.Print(prompt + ": ")
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+
+	if len(passphrase) == 0 {
+		return "", errors.New("empty passphrase")
+	}
+
+	if confirm {
+		fmt.Print("Confirm " + strings.ToLower(prompt) + ": ")
+		confirmation, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			return "", err
+		}
+
+		if string(confirmation) != string(passphrase) {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+
+	return string(passphrase), nil
+}
+
+// errCommandHelp prints an error and refers to the help of the command
+func errCommandHelp(cmd *cobra.Command, err string) {
+	fmt.Println("error: " + err)
+	fmt.Println(`Please see "` + cmd.CommandPath() + ` --help" for more information.`)
+	os.Exit(1)
+}
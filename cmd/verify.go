@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aureleoules/bitcandle/payload"
+	"github.com/guumaster/logsymbols"
+	"github.com/spf13/cobra"
+	"github.com/thediveo/enumflag"
+)
+
+func init() {
+	verifyCmd.Flags().StringVar(&txHash, "tx", "", "txid of the injected file")
+	verifyCmd.Flags().StringVarP(&filePath, "file", "f", "", "path of the local file to compare")
+	verifyCmd.Flags().StringVarP(&electrumServer, "server", "s", "", "electrum server")
+	verifyCmd.Flags().BoolVar(&decrypt, "decrypt", false, "decrypt the file with a passphrase")
+	verifyCmd.Flags().StringVar(&wif, "key", "", "private key (WIF) of a recipient of the file")
+	verifyCmd.PersistentFlags().VarP(
+		enumflag.New(&network, "network", NetworkIds, enumflag.EnumCaseInsensitive), "network", "n", "bitcoin network; can be 'mainnet', 'testnet' or 'regtest'")
+
+	rootCmd.AddCommand(verifyCmd)
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that a local file is the one injected in a transaction",
+	Run: func(cmd *cobra.Command, args []string) {
+		if txHash == "" {
+			errVerifyHelp("no txid was provided")
+		}
+
+		if filePath == "" {
+			errVerifyHelp("no file was provided")
+		}
+
+		local, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			fmt.Println(logsymbols.Error, "Could not read file.")
+			os.Exit(1)
+		}
+
+		connectElectrum()
+
+		data, err := decryptPayload(retrieveFile(txHash, nil))
+		if err != nil {
+			errVerifyHelp(err.Error())
+		}
+
+		header, data, err := payload.Decode(data)
+		switch {
+		case err == payload.ErrNoHeader:
+			// Files injected before payloads were introduced are compared as is
+		case err != nil:
+			fmt.Println(logsymbols.Error, "Could not parse payload header.")
+			os.Exit(1)
+		default:
+			if header.Verify(data) != nil {
+				fmt.Println(logsymbols.Warn, "Injected file does not match its header.")
+			}
+		}
+
+		height, err := confirmationHeight(txHash)
+		switch {
+		case err != nil:
+			fmt.Println(logsymbols.Warn, "Could not find confirmation height.")
+		case height == 0:
+			fmt.Println(logsymbols.Warn, "Transaction is not confirmed yet.")
+		default:
+			fmt.Println(logsymbols.Info, fmt.Sprintf("Transaction confirmed at height %d.", height))
+		}
+
+		localHash := sha256.Sum256(local)
+		onChainHash := sha256.Sum256(data)
+		fmt.Println(logsymbols.Info, "Local SHA-256:    "+hex.EncodeToString(localHash[:]))
+		fmt.Println(logsymbols.Info, "On-chain SHA-256: "+hex.EncodeToString(onChainHash[:]))
+
+		if bytes.Equal(local, data) {
+			fmt.Println(logsymbols.Success, "File matches the injected file.")
+			return
+		}
+
+		fmt.Println(logsymbols.Error, "File does not match the injected file.")
+		if len(local) != len(data) {
+			fmt.Println(logsymbols.Error, fmt.Sprintf("Length mismatch: %d bytes locally, %d bytes on chain.", len(local), len(data)))
+		}
+		if offset := firstDifference(local, data); offset >= 0 {
+			fmt.Println(logsymbols.Error, fmt.Sprintf("First difference at offset %d.", offset))
+		}
+		os.Exit(1)
+	},
+}
+
+// firstDifference returns the offset of the first differing byte of the common prefix, or -1 if one is a prefix of the other
+func firstDifference(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func errVerifyHelp(err string) {
+	fmt.Println("error: " + err)
+	fmt.Println(`Please see "bitcandle verify --help" for more information.`)
+	os.Exit(1)
+}
@@ -14,3 +14,63 @@ const ECDSAMaxSignatureSize = 73
 
 // BTCSats represents a Bitcoin in sats
 const BTCSats = 100_000_000
+
+// MaxInjectionSize represents the maximum size of data that can be stored in a single standard transaction
+const MaxInjectionSize = 285 * 1024
+
+// TaprootPushDataLimit represents the maximum size of data that can be pushed at a time in a tapscript
+const TaprootPushDataLimit = 520
+
+// SchnorrSignatureSize represents the size of a schnorr signature using the default sighash type
+const SchnorrSignatureSize = 64
+
+// MaxTaprootInjectionSize represents the maximum size of data that can be stored in a single standard taproot transaction
+const MaxTaprootInjectionSize = 380 * 1024
+
+// OPReturnPushDataLimit represents the maximum size of data that can be pushed in a single OP_RETURN output by older nodes
+const OPReturnPushDataLimit = 80
+
+// MaxOPReturnInjectionSize represents the maximum size of data that can be stored in the OP_RETURN outputs of a single standard transaction
+const MaxOPReturnInjectionSize = 95 * 1024
+
+// NonStandardPushDataLimit represents the maximum size of data that can be pushed on the stack at a time by consensus rules
+const NonStandardPushDataLimit = 520
+
+// NonStandardStackItems represents the amount of items pushed in the witness script by non-standard transactions
+// Witness scripts are limited to 201 opcodes by consensus rules, each chunk costs 2 opcodes and OP_CHECKSIG costs 1
+const NonStandardStackItems = 101
+
+// MaxOpsPerScript represents the maximum amount of opcodes other than pushes in a witness script by consensus rules
+const MaxOpsPerScript = 201
+
+// BackupBranchOps represents the amount of opcodes added to a witness script by a timelocked backup branch
+// OP_IF OP_ELSE OP_CHECKSEQUENCEVERIFY OP_DROP OP_CHECKSIG OP_ENDIF
+const BackupBranchOps = 6
+
+// NonStandardMaxInjectionSize represents the maximum size of data stored in a single non-standard transaction
+// The transaction must fit in a block of 4,000,000 weight units
+const NonStandardMaxInjectionSize = 3 * 1024 * 1024
+
+// Profile represents the limits of data that can be stored in witness scripts
+type Profile struct {
+	// PushDataLimit represents the maximum size of data that can be pushed on the stack at a time
+	PushDataLimit int
+	// StackItems represents the maximum amount of items that can be pushed in the witness script
+	StackItems int
+	// MaxInjectionSize represents the maximum size of data that can be stored in a single transaction
+	MaxInjectionSize int
+}
+
+// Standard profile respects the relay policy of nodes
+var Standard = Profile{
+	PushDataLimit:    P2SHP2WSHPushDataLimit,
+	StackItems:       P2SHP2WSHStackItems,
+	MaxInjectionSize: MaxInjectionSize,
+}
+
+// NonStandard profile only respects consensus rules, transactions must be submitted directly to miners
+var NonStandard = Profile{
+	PushDataLimit:    NonStandardPushDataLimit,
+	StackItems:       NonStandardStackItems,
+	MaxInjectionSize: NonStandardMaxInjectionSize,
+}
@@ -1,6 +1,11 @@
 package electrum
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/aureleoules/bitcandle/util"
 	"github.com/aureleoules/go-electrum/electrum"
 )
 
@@ -9,3 +14,33 @@ var Client = electrum.NewServer()
 func Connect(addr string) error {
 	return Client.ConnectTCP(addr)
 }
+
+// ScriptHash returns the electrum script hash of an output script (reversed SHA-256)
+func ScriptHash(pkScript []byte) string {
+	scriptHash := sha256.Sum256(pkScript)
+	return hex.EncodeToString(util.ReverseBytes(scriptHash[:]))
+}
+
+// ConfirmationHeight returns the height of the block including a transaction, or 0 if it is unconfirmed
+// Electrum servers index transactions by script, one of the output scripts of the transaction is needed
+func ConfirmationHeight(txid string, pkScript []byte) (int32, error) {
+	history, err := Client.GetHistory(ScriptHash(pkScript))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, tx := range history {
+		if tx.Hash != txid {
+			continue
+		}
+
+		// Unconfirmed transactions have a height of 0, or -1 if they have unconfirmed parents
+		if tx.Height <= 0 {
+			return 0, nil
+		}
+
+		return tx.Height, nil
+	}
+
+	return 0, errors.New("transaction not found")
+}
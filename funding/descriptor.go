@@ -0,0 +1,399 @@
+package funding
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// Supported script types
+const (
+	typeAddress = iota
+	typePKH
+	typeWPKH
+	typeSHWPKH
+	typeTR
+)
+
+// Descriptor is an output script descriptor of the wallet funding an injection
+// Supported descriptors are addr(), pkh(), wpkh(), sh(wpkh()) and tr() without script tree
+type Descriptor struct {
+	scriptType int
+	address    btcutil.Address
+	key        *keyExpression
+	network    *chaincfg.Params
+}
+
+// keyExpression is a public key, or an extended public key with a derivation path
+type keyExpression struct {
+	fingerprint uint32
+	origin      []uint32
+
+	pubKey   *btcec.PublicKey
+	extended *hdkeychain.ExtendedKey
+	path     []uint32
+	ranged   bool
+}
+
+// Script is an output script of a descriptor along with what signers need to recognize it
+type Script struct {
+	PkScript     []byte
+	RedeemScript []byte
+
+	// The key and its derivation are unknown for addr() descriptors
+	PubKey      *btcec.PublicKey
+	Fingerprint uint32
+	Path        []uint32
+	Taproot     bool
+}
+
+// ParseDescriptor parses an output script descriptor, its checksum is verified if present
+func ParseDescriptor(s string, network *chaincfg.Params) (*Descriptor, error) {
+	s = strings.TrimSpace(s)
+
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		if descriptorChecksum(s[:i]) != s[i+1:] {
+			return nil, errors.New("invalid descriptor checksum")
+		}
+		s = s[:i]
+	}
+
+	descriptor := Descriptor{network: network}
+
+	var inner string
+	switch {
+	case strings.HasPrefix(s, "addr(") && strings.HasSuffix(s, ")"):
+		address, err := btcutil.DecodeAddress(s[len("addr("):len(s)-1], network)
+		if err != nil || !address.IsForNet(network) {
+			return nil, errors.New("invalid address in descriptor")
+		}
+
+		descriptor.scriptType = typeAddress
+		descriptor.address = address
+		return &descriptor, nil
+	case strings.HasPrefix(s, "pkh(") && strings.HasSuffix(s, ")"):
+		descriptor.scriptType = typePKH
+		inner = s[len("pkh(") : len(s)-1]
+	case strings.HasPrefix(s, "wpkh(") && strings.HasSuffix(s, ")"):
+		descriptor.scriptType = typeWPKH
+		inner = s[len("wpkh(") : len(s)-1]
+	case strings.HasPrefix(s, "sh(wpkh(") && strings.HasSuffix(s, "))"):
+		descriptor.scriptType = typeSHWPKH
+		inner = s[len("sh(wpkh(") : len(s)-2]
+	case strings.HasPrefix(s, "tr(") && strings.HasSuffix(s, ")") && !strings.Contains(s, ","):
+		descriptor.scriptType = typeTR
+		inner = s[len("tr(") : len(s)-1]
+	default:
+		return nil, errors.New("unsupported descriptor")
+	}
+
+	key, err := parseKeyExpression(inner, network)
+	if err != nil {
+		return nil, err
+	}
+	descriptor.key = key
+
+	return &descriptor, nil
+}
+
+// IsRanged returns true if the descriptor derives many scripts
+func (d *Descriptor) IsRanged() bool {
+	return d.key != nil && d.key.ranged
+}
+
+// Scripts returns the output scripts of the descriptor, the first count ones if it is ranged
+func (d *Descriptor) Scripts(count int) ([]*Script, error) {
+	if d.scriptType == typeAddress {
+		pkScript, err := txscript.PayToAddrScript(d.address)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*Script{{PkScript: pkScript}}, nil
+	}
+
+	if !d.IsRanged() {
+		count = 1
+	}
+
+	var scripts []*Script
+	for i := 0; i < count; i++ {
+		pubKey, path, err := d.key.derive(uint32(i))
+		if err != nil {
+			return nil, err
+		}
+
+		script, err := d.script(pubKey)
+		if err != nil {
+			return nil, err
+		}
+
+		script.PubKey = pubKey
+		script.Fingerprint = d.key.fingerprint
+		script.Path = path
+		scripts = append(scripts, script)
+	}
+
+	return scripts, nil
+}
+
+// script builds the output script paying a public key
+func (d *Descriptor) script(pubKey *btcec.PublicKey) (*Script, error) {
+	var address btcutil.Address
+	var script Script
+	var err error
+
+	switch d.scriptType {
+	case typePKH:
+		address, err = btcutil.NewAddressPubKeyHash(btcutil.Hash160(pubKey.SerializeCompressed()), d.network)
+	case typeWPKH:
+		address, err = btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pubKey.SerializeCompressed()), d.network)
+	case typeSHWPKH:
+		script.RedeemScript, err = txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(btcutil.Hash160(pubKey.SerializeCompressed())).Script()
+		if err != nil {
+			return nil, err
+		}
+		address, err = btcutil.NewAddressScriptHash(script.RedeemScript, d.network)
+	case typeTR:
+		// Key path only, the output key commits to an empty script tree
+		script.Taproot = true
+		address, err = btcutil.NewAddressTaproot(schnorr.SerializePubKey(txscript.ComputeTaprootKeyNoScript(pubKey)), d.network)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	script.PkScript, err = txscript.PayToAddrScript(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &script, nil
+}
+
+// parseKeyExpression parses a key with an optional origin: [fingerprint/path]key/path
+func parseKeyExpression(s string, network *chaincfg.Params) (*keyExpression, error) {
+	var key keyExpression
+	hasOrigin := false
+
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return nil, errors.New("invalid key origin")
+		}
+
+		var err error
+		key.fingerprint, key.origin, err = ParseKeyOrigin(s[1:end])
+		if err != nil {
+			return nil, err
+		}
+
+		hasOrigin = true
+		s = s[end+1:]
+	}
+
+	elements := strings.Split(s, "/")
+
+	if b, err := hex.DecodeString(elements[0]); err == nil {
+		if len(elements) > 1 {
+			return nil, errors.New("only extended keys can be derived")
+		}
+
+		switch len(b) {
+		case btcec.PubKeyBytesLenCompressed:
+			key.pubKey, err = btcec.ParsePubKey(b)
+		case schnorr.PubKeyBytesLen:
+			key.pubKey, err = schnorr.ParsePubKey(b)
+		default:
+			err = errors.New("invalid public key length")
+		}
+		if err != nil {
+			return nil, errors.New("invalid public key in descriptor")
+		}
+
+		if !hasOrigin {
+			key.fingerprint = binary.LittleEndian.Uint32(btcutil.Hash160(key.pubKey.SerializeCompressed())[:4])
+		}
+
+		return &key, nil
+	}
+
+	extended, err := hdkeychain.NewKeyFromString(elements[0])
+	if err != nil {
+		return nil, errors.New("invalid key in descriptor")
+	}
+
+	if extended.IsPrivate() {
+		return nil, errors.New("descriptor must not contain private keys")
+	}
+
+	if !extended.IsForNet(network) {
+		return nil, errors.New("extended key is for another network")
+	}
+	key.extended = extended
+
+	if !hasOrigin {
+		pubKey, err := extended.ECPubKey()
+		if err != nil {
+			return nil, err
+		}
+		key.fingerprint = binary.LittleEndian.Uint32(btcutil.Hash160(pubKey.SerializeCompressed())[:4])
+	}
+
+	elements = elements[1:]
+	if len(elements) > 0 && elements[len(elements)-1] == "*" {
+		key.ranged = true
+		elements = elements[:len(elements)-1]
+	}
+
+	key.path, err = parsePath(elements)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hardened children of an extended public key cannot be derived
+	for _, index := range key.path {
+		if index >= hdkeychain.HardenedKeyStart {
+			return nil, errors.New("hardened derivation requires a private key")
+		}
+	}
+
+	return &key, nil
+}
+
+// ParseKeyOrigin parses the origin of a key: the fingerprint of its master key followed by its derivation path (e.g. d34db33f/84h/0h/0h)
+func ParseKeyOrigin(s string) (uint32, []uint32, error) {
+	origin := strings.Split(s, "/")
+	fingerprint, err := hex.DecodeString(origin[0])
+	if err != nil || len(fingerprint) != 4 {
+		return 0, nil, errors.New("invalid key origin fingerprint")
+	}
+
+	path, err := parsePath(origin[1:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// PSBT fields store fingerprints in little endian
+	return binary.LittleEndian.Uint32(fingerprint), path, nil
+}
+
+// derive returns the public key at an index of a ranged key, and its full derivation path
+func (k *keyExpression) derive(index uint32) (*btcec.PublicKey, []uint32, error) {
+	path := append([]uint32{}, k.origin...)
+
+	if k.extended == nil {
+		return k.pubKey, path, nil
+	}
+
+	steps := append([]uint32{}, k.path...)
+	if k.ranged {
+		steps = append(steps, index)
+	}
+
+	child := k.extended
+	for _, step := range steps {
+		var err error
+		child, err = child.Derive(step)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	pubKey, err := child.ECPubKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pubKey, append(path, steps...), nil
+}
+
+// parsePath parses BIP32 path elements, hardened with ' or h
+func parsePath(elements []string) ([]uint32, error) {
+	var path []uint32
+
+	for _, element := range elements {
+		hardened := strings.HasSuffix(element, "'") || strings.HasSuffix(element, "h")
+		if hardened {
+			element = element[:len(element)-1]
+		}
+
+		index, err := strconv.ParseUint(element, 10, 31)
+		if err != nil {
+			return nil, errors.New("invalid derivation path")
+		}
+
+		if hardened {
+			index += hdkeychain.HardenedKeyStart
+		}
+		path = append(path, uint32(index))
+	}
+
+	return path, nil
+}
+
+// Checksum alphabets and generator defined by BIP380
+const (
+	checksumInputCharset = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	checksumCharset      = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+var checksumGenerator = []uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+
+// descriptorChecksum computes the checksum of a descriptor, or returns an empty string if it contains invalid characters
+func descriptorChecksum(s string) string {
+	polymod := func(chk uint64, value int) uint64 {
+		top := chk >> 35
+		chk = (chk&0x7ffffffff)<<5 ^ uint64(value)
+		for i, g := range checksumGenerator {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= g
+			}
+		}
+		return chk
+	}
+
+	chk := uint64(1)
+	var groups []int
+	for _, c := range s {
+		v := strings.IndexRune(checksumInputCharset, c)
+		if v < 0 {
+			return ""
+		}
+
+		chk = polymod(chk, v&31)
+		groups = append(groups, v>>5)
+		if len(groups) == 3 {
+			chk = polymod(chk, groups[0]*9+groups[1]*3+groups[2])
+			groups = groups[:0]
+		}
+	}
+
+	switch len(groups) {
+	case 1:
+		chk = polymod(chk, groups[0])
+	case 2:
+		chk = polymod(chk, groups[0]*3+groups[1])
+	}
+
+	for i := 0; i < 8; i++ {
+		chk = polymod(chk, 0)
+	}
+	chk ^= 1
+
+	var checksum strings.Builder
+	for i := 0; i < 8; i++ {
+		checksum.WriteByte(checksumCharset[(chk>>(5*(7-uint(i))))&31])
+	}
+
+	return checksum.String()
+}
@@ -0,0 +1,233 @@
+package funding
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// Account keys of the BIP84 and BIP86 test vectors, derived from the "abandon ... about" mnemonic
+const (
+	bip84Zpub = "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"
+	bip86Xpub = "xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ"
+)
+
+const h = hdkeychain.HardenedKeyStart
+
+// bip84Xpub returns the BIP84 account key with the version bytes of an xpub
+func bip84Xpub(t *testing.T) string {
+	t.Helper()
+
+	key, err := hdkeychain.NewKeyFromString(bip84Zpub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err = key.CloneWithVersion(chaincfg.MainNetParams.HDPublicKeyID[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key.String()
+}
+
+func scriptAddress(t *testing.T, pkScript []byte) string {
+	t.Helper()
+
+	_, addresses, _, err := txscript.ExtractPkScriptAddrs(pkScript, &chaincfg.MainNetParams)
+	if err != nil || len(addresses) != 1 {
+		t.Fatalf("cannot extract address from %x", pkScript)
+	}
+
+	return addresses[0].EncodeAddress()
+}
+
+func TestDescriptorChecksum(t *testing.T) {
+	tests := []struct {
+		descriptor string
+		checksum   string
+	}{
+		{"raw(deadbeef)", "89f8spxm"},
+		{"pkh([d34db33f/44'/0'/0']xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL/1/*)", "ml40v0wf"},
+		{"invalidé", ""},
+	}
+
+	for _, test := range tests {
+		if checksum := descriptorChecksum(test.descriptor); checksum != test.checksum {
+			t.Fatalf("checksum of %s = %q, want %q", test.descriptor, checksum, test.checksum)
+		}
+	}
+}
+
+func TestParseDescriptorChecksum(t *testing.T) {
+	descriptor := "pkh([d34db33f/44'/0'/0']xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL/1/*)"
+
+	if _, err := ParseDescriptor(descriptor+"#ml40v0wf", &chaincfg.MainNetParams); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, checksum := range []string{"ml40v0wg", "ml40v0w", ""} {
+		if _, err := ParseDescriptor(descriptor+"#"+checksum, &chaincfg.MainNetParams); err == nil {
+			t.Fatalf("expected an error for checksum %q", checksum)
+		}
+	}
+}
+
+func TestDescriptorScripts(t *testing.T) {
+	tests := []struct {
+		name        string
+		descriptor  string
+		addresses   []string
+		fingerprint uint32
+		path        []uint32
+	}{
+		{
+			"wpkh",
+			"wpkh([73c5da0a/84h/0h/0h]" + bip84Xpub(t) + "/0/*)",
+			[]string{"bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu", "bc1qnjg0jd8228aq7egyzacy8cys3knf9xvrerkf9g"},
+			0x0adac573,
+			[]uint32{84 + h, h, h, 0, 1},
+		},
+		{
+			"tr",
+			"tr([73c5da0a/86'/0'/0']" + bip86Xpub + "/0/*)",
+			[]string{"bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr", "bc1p4qhjn9zdvkux4e44uhx8tc55attvtyu358kutcqkudyccelu0was9fqzwh"},
+			0x0adac573,
+			[]uint32{86 + h, h, h, 0, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			descriptor, err := ParseDescriptor(test.descriptor, &chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !descriptor.IsRanged() {
+				t.Fatal("descriptor is not ranged")
+			}
+
+			scripts, err := descriptor.Scripts(len(test.addresses))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, script := range scripts {
+				if address := scriptAddress(t, script.PkScript); address != test.addresses[i] {
+					t.Fatalf("address %d = %s, want %s", i, address, test.addresses[i])
+				}
+			}
+
+			last := scripts[len(scripts)-1]
+			if last.Fingerprint != test.fingerprint || !reflect.DeepEqual(last.Path, test.path) {
+				t.Fatalf("origin = %08x %v, want %08x %v", last.Fingerprint, last.Path, test.fingerprint, test.path)
+			}
+		})
+	}
+}
+
+func TestDescriptorSingleKey(t *testing.T) {
+	// Public key of the first BIP84 receiving address and its hash
+	pubKey := "0330d54fd0dd420a6e5f8d3624f5f3482cae350f79d5f0753bf5beef9c2d91af3c"
+	hash, _ := hex.DecodeString("c0cebcd6c3d3ca8c75dc5ec62ebe55330ef910e2")
+
+	tests := []struct {
+		descriptor string
+		address    func() (btcutil.Address, error)
+	}{
+		{"pkh(" + pubKey + ")", func() (btcutil.Address, error) {
+			return btcutil.NewAddressPubKeyHash(hash, &chaincfg.MainNetParams)
+		}},
+		{"wpkh(" + pubKey + ")", func() (btcutil.Address, error) {
+			return btcutil.DecodeAddress("bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu", &chaincfg.MainNetParams)
+		}},
+		{"sh(wpkh(" + pubKey + "))", func() (btcutil.Address, error) {
+			redeemScript, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(hash).Script()
+			return btcutil.NewAddressScriptHash(redeemScript, &chaincfg.MainNetParams)
+		}},
+		{"addr(bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu)", func() (btcutil.Address, error) {
+			return btcutil.DecodeAddress("bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu", &chaincfg.MainNetParams)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.descriptor, func(t *testing.T) {
+			descriptor, err := ParseDescriptor(test.descriptor, &chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if descriptor.IsRanged() {
+				t.Fatal("descriptor is ranged")
+			}
+
+			scripts, err := descriptor.Scripts(5)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(scripts) != 1 {
+				t.Fatalf("%d scripts, want 1", len(scripts))
+			}
+
+			address, err := test.address()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := scriptAddress(t, scripts[0].PkScript); got != address.EncodeAddress() {
+				t.Fatalf("address = %s, want %s", got, address.EncodeAddress())
+			}
+		})
+	}
+}
+
+func TestParseDescriptorInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		descriptor string
+	}{
+		{"unsupported", "wsh(pk(0330d54fd0dd420a6e5f8d3624f5f3482cae350f79d5f0753bf5beef9c2d91af3c))"},
+		{"script tree", "tr(" + bip86Xpub + "/0/*,pk(0330d54fd0dd420a6e5f8d3624f5f3482cae350f79d5f0753bf5beef9c2d91af3c))"},
+		{"private key", "wpkh(xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi/0/*)"},
+		{"hardened derivation", "wpkh(" + bip86Xpub + "/0h/*)"},
+		{"other network", "wpkh(tpubD6NzVbkrYhZ4XgiXtGrdW5XDAPFCL9h7we1vwNCpn8tGbBcgfVYjXyhWo4E1xkh56hjod1RhGjxbaTLV3X4FyWuejifB9jusQ46QzG87VKp/0/*)"},
+		{"derived public key", "wpkh(0330d54fd0dd420a6e5f8d3624f5f3482cae350f79d5f0753bf5beef9c2d91af3c/0)"},
+		{"invalid public key", "wpkh(0330d54f)"},
+		{"invalid path", "wpkh(" + bip86Xpub + "/a/*)"},
+		{"unterminated origin", "wpkh([73c5da0a/84h" + bip86Xpub + ")"},
+		{"invalid address", "addr(tb1qcr8te4kr609gcawutmrza0j4xv80jy8zq2ajhf)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseDescriptor(test.descriptor, &chaincfg.MainNetParams)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseKeyOrigin(t *testing.T) {
+	fingerprint, path, err := ParseKeyOrigin("d34db33f/84h/0'/0h/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fingerprint != 0x3fb34dd3 || !reflect.DeepEqual(path, []uint32{84 + h, h, h, 1}) {
+		t.Fatalf("origin = %08x %v", fingerprint, path)
+	}
+
+	for _, origin := range []string{"d34db3", "d34db33g/0", "d34db33f/x", "d34db33f/2147483648"} {
+		if _, _, err := ParseKeyOrigin(origin); err == nil {
+			t.Fatalf("expected an error for %q", origin)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package funding
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Estimated weight of a signed input of each script type
+const (
+	pkhInputWeight    = 4 * (41 + 1 + consensus.ECDSAMaxSignatureSize + 1 + 33)
+	wpkhInputWeight   = 4*41 + 1 + 1 + consensus.ECDSAMaxSignatureSize + 1 + 33
+	shwpkhInputWeight = 4*(41+23) + 1 + 1 + consensus.ECDSAMaxSignatureSize + 1 + 33
+	trInputWeight     = 4*41 + 1 + 1 + consensus.SchnorrSignatureSize
+)
+
+// UTXO is an unspent output of the funding wallet
+type UTXO struct {
+	OutPoint wire.OutPoint
+	Value    int64
+	Script   *Script
+	// Signers of segwit v0 inputs need the previous transaction to check the amount
+	PrevTx *wire.MsgTx
+}
+
+// NewPSBT creates an unsigned PSBT paying outputs from the largest UTXOs first
+// The change returns to the script of the first selected UTXO
+func NewPSBT(utxos []*UTXO, outputs []*wire.TxOut, feeRate int) (*psbt.Packet, error) {
+	if len(utxos) == 0 {
+		return nil, errors.New("no funds available")
+	}
+
+	sorted := append([]*UTXO{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Value > sorted[j].Value
+	})
+
+	var total int64
+	for _, output := range outputs {
+		total += output.Value
+	}
+
+	for _, utxo := range sorted {
+		if _, err := inputWeight(utxo.Script); err != nil {
+			return nil, err
+		}
+	}
+
+	change := sorted[0].Script
+
+	var selected []*UTXO
+	var value int64
+	for _, utxo := range sorted {
+		selected = append(selected, utxo)
+		value += utxo.Value
+
+		if value >= total+fee(selected, outputs, change.PkScript, feeRate) {
+			break
+		}
+	}
+
+	changeValue := value - total - fee(selected, outputs, change.PkScript, feeRate)
+
+	// Change below the dust limit is left to the miners
+	txOuts := append([]*wire.TxOut{}, outputs...)
+	if changeValue >= consensus.P2PKHDustLimit {
+		txOuts = append(txOuts, wire.NewTxOut(changeValue, change.PkScript))
+	} else if value-total-fee(selected, outputs, nil, feeRate) < 0 {
+		return nil, errors.New("insufficient funds")
+	}
+
+	var outPoints []*wire.OutPoint
+	var sequences []uint32
+	for _, utxo := range selected {
+		outPoints = append(outPoints, &utxo.OutPoint)
+		sequences = append(sequences, wire.MaxTxInSequenceNum)
+	}
+
+	packet, err := psbt.New(outPoints, txOuts, wire.TxVersion, 0, sequences)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, utxo := range selected {
+		packet.Inputs[i] = *newInput(utxo)
+	}
+
+	if len(txOuts) > len(outputs) {
+		packet.Outputs[len(outputs)] = *newOutput(change)
+	}
+
+	return packet, nil
+}
+
+// newInput describes a spent UTXO for signers
+func newInput(utxo *UTXO) *psbt.PInput {
+	input := psbt.PInput{
+		WitnessUtxo:  wire.NewTxOut(utxo.Value, utxo.Script.PkScript),
+		RedeemScript: utxo.Script.RedeemScript,
+	}
+
+	if txscript.IsPayToPubKeyHash(utxo.Script.PkScript) {
+		input.WitnessUtxo = nil
+	}
+
+	// Taproot signatures commit to the amounts of all inputs, the previous transaction is not needed
+	if !utxo.Script.Taproot {
+		input.NonWitnessUtxo = utxo.PrevTx
+	}
+
+	if utxo.Script.PubKey == nil {
+		return &input
+	}
+
+	if utxo.Script.Taproot {
+		xOnly := schnorr.SerializePubKey(utxo.Script.PubKey)
+		input.TaprootInternalKey = xOnly
+		input.TaprootBip32Derivation = []*psbt.TaprootBip32Derivation{{
+			XOnlyPubKey:          xOnly,
+			MasterKeyFingerprint: utxo.Script.Fingerprint,
+			Bip32Path:            utxo.Script.Path,
+		}}
+		return &input
+	}
+
+	input.Bip32Derivation = []*psbt.Bip32Derivation{{
+		PubKey:               utxo.Script.PubKey.SerializeCompressed(),
+		MasterKeyFingerprint: utxo.Script.Fingerprint,
+		Bip32Path:            utxo.Script.Path,
+	}}
+	return &input
+}
+
+// newOutput describes the change output so that signers recognize it as their own
+func newOutput(script *Script) *psbt.POutput {
+	output := psbt.POutput{RedeemScript: script.RedeemScript}
+
+	if script.PubKey == nil {
+		return &output
+	}
+
+	if script.Taproot {
+		xOnly := schnorr.SerializePubKey(script.PubKey)
+		output.TaprootInternalKey = xOnly
+		output.TaprootBip32Derivation = []*psbt.TaprootBip32Derivation{{
+			XOnlyPubKey:          xOnly,
+			MasterKeyFingerprint: script.Fingerprint,
+			Bip32Path:            script.Path,
+		}}
+		return &output
+	}
+
+	output.Bip32Derivation = []*psbt.Bip32Derivation{{
+		PubKey:               script.PubKey.SerializeCompressed(),
+		MasterKeyFingerprint: script.Fingerprint,
+		Bip32Path:            script.Path,
+	}}
+	return &output
+}
+
+// fee estimates the fee of a transaction spending inputs to outputs, with a change output unless changeScript is nil
+func fee(inputs []*UTXO, outputs []*wire.TxOut, changeScript []byte, feeRate int) int64 {
+	txOuts := append([]*wire.TxOut{}, outputs...)
+	if changeScript != nil {
+		txOuts = append(txOuts, wire.NewTxOut(0, changeScript))
+	}
+
+	// Version, locktime and segwit marker
+	weight := 4*(4+4+wire.VarIntSerializeSize(uint64(len(inputs)))+wire.VarIntSerializeSize(uint64(len(txOuts)))) + 2
+	for _, input := range inputs {
+		w, _ := inputWeight(input.Script)
+		weight += w
+	}
+	for _, output := range txOuts {
+		weight += 4 * output.SerializeSize()
+	}
+
+	return int64((weight+3)/4) * int64(feeRate)
+}
+
+// inputWeight returns the estimated weight of a signed input spending a script
+func inputWeight(script *Script) (int, error) {
+	switch txscript.GetScriptClass(script.PkScript) {
+	case txscript.PubKeyHashTy:
+		return pkhInputWeight, nil
+	case txscript.WitnessV0PubKeyHashTy:
+		return wpkhInputWeight, nil
+	case txscript.ScriptHashTy:
+		// Only P2SH-P2WPKH can be signed
+		return shwpkhInputWeight, nil
+	case txscript.WitnessV1TaprootTy:
+		return trInputWeight, nil
+	}
+
+	return 0, errors.New("unsupported funding script")
+}
+
+// Extract finalizes a signed PSBT and checks that it pays every output
+func Extract(packet *psbt.Packet, outputs []*wire.TxOut) (*wire.MsgTx, error) {
+	// Several outputs may pay the same address
+	used := make([]bool, len(packet.UnsignedTx.TxOut))
+	for _, output := range outputs {
+		found := false
+		for k, txOut := range packet.UnsignedTx.TxOut {
+			if !used[k] && bytes.Equal(txOut.PkScript, output.PkScript) && txOut.Value >= output.Value {
+				used[k] = true
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, errors.New("PSBT does not pay every injection address")
+		}
+	}
+
+	err := psbt.MaybeFinalizeAll(packet)
+	if err != nil {
+		return nil, errors.New("PSBT is not fully signed")
+	}
+
+	return psbt.Extract(packet)
+}
@@ -1,16 +1,38 @@
 module github.com/aureleoules/bitcandle
 
-go 1.16
+go 1.17
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/aureleoules/go-electrum v0.0.0-20210508155210-3b8646520771
 	github.com/briandowns/spinner v1.12.0
-	github.com/btcsuite/btcd v0.21.0-beta
-	github.com/btcsuite/btcutil v1.0.2
+	github.com/btcsuite/btcd v0.24.2
+	github.com/btcsuite/btcd/btcec/v2 v2.3.2
+	github.com/btcsuite/btcd/btcutil v1.1.5
+	github.com/btcsuite/btcd/btcutil/psbt v1.1.8
+	github.com/btcsuite/btcd/chaincfg/chainhash v1.1.0
 	github.com/guumaster/logsymbols v0.3.1
+	github.com/klauspost/compress v1.15.15
+	github.com/klauspost/reedsolomon v1.11.8
 	github.com/mdp/qrterminal v1.0.1
 	github.com/spf13/cobra v1.1.3
 	github.com/thediveo/enumflag v0.10.1
-	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b // indirect
-	golang.org/x/sys v0.0.0-20210503173754-0981d6026fa6 // indirect
+	github.com/tyler-smith/go-bip39 v1.1.0
+	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
+)
+
+require (
+	github.com/btcsuite/btclog v0.0.0-20170628155309-84c8d2346e9f // indirect
+	github.com/decred/dcrd/crypto/blake256 v1.0.0 // indirect
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
+	github.com/fatih/color v1.7.0 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.1.1 // indirect
+	github.com/mattn/go-colorable v0.1.2 // indirect
+	github.com/mattn/go-isatty v0.0.12 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/sys v0.0.0-20220704084225-05e143d24a9e // indirect
+	gopkg.in/gookit/color.v1 v1.1.6 // indirect
+	rsc.io/qr v0.2.0 // indirect
 )
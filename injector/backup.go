@@ -0,0 +1,151 @@
+package injector
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Backup lets a backup key spend injection inputs once they are Delay blocks old
+// It allows the funds to be reclaimed if the key of a file is lost
+type Backup struct {
+	PubKey *btcec.PublicKey
+	Delay  uint16
+}
+
+// addBackupBranch appends the timelocked branch of the backup key to a witness script
+// OP_ELSE <delay> OP_CHECKSEQUENCEVERIFY OP_DROP <backup pubkey> OP_CHECKSIG OP_ENDIF
+func addBackupBranch(builder *txscript.ScriptBuilder, backup *Backup) {
+	builder.AddOp(txscript.OP_ELSE)
+	builder.AddInt64(int64(backup.Delay))
+	builder.AddOp(txscript.OP_CHECKSEQUENCEVERIFY)
+	builder.AddOp(txscript.OP_DROP)
+	builder.AddData(backup.PubKey.SerializeCompressed())
+	builder.AddOp(txscript.OP_CHECKSIG)
+	builder.AddOp(txscript.OP_ENDIF)
+}
+
+// parseBackupBranch checks that the tokens following the first branch of a witness script follow the template of addBackupBranch
+func parseBackupBranch(tokenizer *txscript.ScriptTokenizer) bool {
+	if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_ELSE {
+		return false
+	}
+
+	// Delays are small integers or pushes of at most 3 bytes
+	if !tokenizer.Next() {
+		return false
+	}
+	op := tokenizer.Opcode()
+	if (op < txscript.OP_1 || op > txscript.OP_16) && (op < txscript.OP_DATA_1 || op > txscript.OP_DATA_3) {
+		return false
+	}
+
+	for _, expected := range []byte{txscript.OP_CHECKSEQUENCEVERIFY, txscript.OP_DROP, txscript.OP_DATA_33, txscript.OP_CHECKSIG, txscript.OP_ENDIF} {
+		if !tokenizer.Next() || tokenizer.Opcode() != expected {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isMainBranch returns true if the witness of a script with a backup branch reveals the chunks
+func isMainBranch(witness wire.TxWitness) bool {
+	return bytes.Equal(witness[len(witness)-2], []byte{1})
+}
+
+// BuildReclaim constructs the transaction sending funded injection addresses to a single output through the backup branch
+// The public key is the key of the file the addresses were derived from, the signer holds the backup key
+// Inputs are only valid once they are Delay blocks old, their chunks are not revealed
+func (e *WitnessScriptEncoder) BuildReclaim(addresses []*InjectionAddress, pubKey *btcec.PublicKey, signer Signer, pkScript []byte, feeRate int) (*wire.MsgTx, error) {
+	if e.Backup == nil {
+		return nil, errors.New("witness scripts have no backup branch")
+	}
+
+	var total int64
+	for _, addr := range addresses {
+		total += addr.Amount
+	}
+
+	dummyTx, err := e.buildReclaim(addresses, pubKey, signer, wire.NewTxOut(0, pkScript), true)
+	if err != nil {
+		return nil, err
+	}
+
+	value := total - int64(virtualSize(dummyTx)*feeRate)
+	if value < consensus.P2PKHDustLimit {
+		return nil, errors.New("reclaimed amount does not cover the fee")
+	}
+
+	return e.buildReclaim(addresses, pubKey, signer, wire.NewTxOut(value, pkScript), false)
+}
+
+func (e *WitnessScriptEncoder) buildReclaim(addresses []*InjectionAddress, pubKey *btcec.PublicKey, signer Signer, txOut *wire.TxOut, dummy bool) (*wire.MsgTx, error) {
+	// Relative timelocks are only enforced from version 2
+	tx := wire.NewMsgTx(2)
+	tx.AddTxOut(txOut)
+
+	prevOuts := txscript.NewMultiPrevOutFetcher(nil)
+	spentOutputs := make([]*wire.TxOut, len(addresses))
+	for k, addr := range addresses {
+		txIn := wire.NewTxIn(addr.UTXO, nil, nil)
+		txIn.Sequence = uint32(e.Backup.Delay)
+		tx.AddTxIn(txIn)
+
+		pkScript, err := txscript.PayToAddrScript(addr.Address)
+		if err != nil {
+			return nil, err
+		}
+		spentOutputs[k] = wire.NewTxOut(addr.Amount, pkScript)
+		prevOuts.AddPrevOut(*addr.UTXO, spentOutputs[k])
+	}
+
+	var sigHashes *txscript.TxSigHashes
+	if !dummy {
+		sigHashes = txscript.NewTxSigHashes(tx, prevOuts)
+	}
+
+	var witnesses []wire.TxWitness
+	var sigScripts [][]byte
+	for k, addr := range addresses {
+		witnessScript, err := buildWitnessScript(pubKey, addr.Chunks, e.Backup)
+		if err != nil {
+			return nil, err
+		}
+
+		var sig []byte
+		if dummy {
+			// Empty signature of max possible size
+			sig = make([]byte, consensus.ECDSAMaxSignatureSize)
+		} else {
+			sig, err = signer.SignWitness(tx, sigHashes, k, spentOutputs[k], witnessScript)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Witness is: [SIG] [EMPTY] [WITNESS SCRIPT], the empty item selects the backup branch
+		witnesses = append(witnesses, wire.TxWitness{sig, {}, witnessScript})
+
+		var sigScript []byte
+		if e.Nested {
+			sigScript, err = buildSigScript(witnessScript)
+			if err != nil {
+				return nil, err
+			}
+		}
+		sigScripts = append(sigScripts, sigScript)
+	}
+
+	// Once all inputs are signed, add script signatures to their corresponding inputs
+	for k := range witnesses {
+		tx.TxIn[k].Witness = witnesses[k]
+		tx.TxIn[k].SignatureScript = sigScripts[k]
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,111 @@
+package injector
+
+import (
+	"testing"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+func TestBuildReclaim(t *testing.T) {
+	signer := testSigner(t)
+	backupSigner := testSigner(t)
+	data := make([]byte, 12000)
+
+	for _, nested := range []bool{false, true} {
+		encoder := &WitnessScriptEncoder{Nested: nested, Profile: consensus.Standard, Backup: &Backup{PubKey: backupSigner.PubKey(), Delay: 144}}
+
+		t.Run(encoder.Name(), func(t *testing.T) {
+			addresses, pkScripts := testFundedAddresses(t, encoder, signer, encoder.Chunks(data))
+
+			tx, err := encoder.BuildReclaim(addresses, signer.PubKey(), backupSigner, []byte{txscript.OP_TRUE}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tx.Version < 2 || tx.TxIn[0].Sequence != 144 {
+				t.Fatalf("version %d and sequence %d do not enforce the delay", tx.Version, tx.TxIn[0].Sequence)
+			}
+
+			if err := executeInputs(t, tx, pkScripts); err != nil {
+				t.Fatal(err)
+			}
+
+			// Reclaimed inputs reveal no chunks and no author
+			if _, err := encoder.Decode(tx); err == nil {
+				t.Fatal("expected no data in a reclaim transaction")
+			}
+
+			if _, err := encoder.Author(tx); err == nil {
+				t.Fatal("expected no author in a reclaim transaction")
+			}
+		})
+	}
+}
+
+func TestBuildReclaimInvalid(t *testing.T) {
+	signer := testSigner(t)
+	backupSigner := testSigner(t)
+	encoder := &WitnessScriptEncoder{Profile: consensus.Standard, Backup: &Backup{PubKey: backupSigner.PubKey(), Delay: 144}}
+	addresses, pkScripts := testFundedAddresses(t, encoder, signer, encoder.Chunks([]byte("reclaimed by the backup key")))
+
+	// Only the backup key may spend the backup branch
+	tx, err := encoder.BuildReclaim(addresses, signer.PubKey(), signer, []byte{txscript.OP_TRUE}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if executeInputs(t, tx, pkScripts) == nil {
+		t.Fatal("expected an invalid signature")
+	}
+
+	if _, err := encoder.BuildReclaim(addresses, signer.PubKey(), backupSigner, []byte{txscript.OP_TRUE}, 1000); err == nil {
+		t.Fatal("expected an error for a fee above the reclaimed amount")
+	}
+
+	noBackup := &WitnessScriptEncoder{Profile: consensus.Standard}
+	if _, err := noBackup.BuildReclaim(addresses, signer.PubKey(), backupSigner, []byte{txscript.OP_TRUE}, 1); err == nil {
+		t.Fatal("expected an error without a backup branch")
+	}
+}
+
+func TestParseBackupBranchDelays(t *testing.T) {
+	pubKey := testSigner(t).PubKey()
+	chunks := [][]byte{[]byte("chunk")}
+
+	// Delays are encoded as small integers or pushes of up to 3 bytes
+	for _, delay := range []uint16{1, 16, 17, 144, 65535} {
+		script, err := buildWitnessScript(pubKey, chunks, &Backup{PubKey: pubKey, Delay: delay})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, backup, ok := parseWitnessScript(script); !ok || !backup {
+			t.Fatalf("witness script with a delay of %d is not recognized", delay)
+		}
+	}
+
+	// A four byte delay does not follow the template
+	builder := txscript.NewScriptBuilder().AddOp(txscript.OP_IF).AddOp(txscript.OP_HASH160).AddData(make([]byte, 20)).AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddData(pubKey.SerializeCompressed()).AddOp(txscript.OP_CHECKSIG).AddOp(txscript.OP_ELSE).AddInt64(1 << 24)
+	builder.AddOp(txscript.OP_CHECKSEQUENCEVERIFY).AddOp(txscript.OP_DROP).AddData(pubKey.SerializeCompressed()).AddOp(txscript.OP_CHECKSIG).AddOp(txscript.OP_ENDIF)
+	script, err := builder.Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, ok := parseWitnessScript(script); ok {
+		t.Fatal("witness script with a four byte delay should not be recognized")
+	}
+}
+
+func TestBackupChunksFitOpsLimit(t *testing.T) {
+	encoder := &WitnessScriptEncoder{Profile: consensus.NonStandard, Backup: &Backup{PubKey: testSigner(t).PubKey(), Delay: 144}}
+
+	for _, chunks := range encoder.Chunks(make([]byte, 100000)) {
+		// Each chunk costs two opcodes, the first branch one more for its signature check
+		if ops := 2*len(chunks) + 1 + consensus.BackupBranchOps; ops > consensus.MaxOpsPerScript {
+			t.Fatalf("%d opcodes in a witness script", ops)
+		}
+	}
+}
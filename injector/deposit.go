@@ -0,0 +1,327 @@
+package injector
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/aureleoules/bitcandle/electrum"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Deposit is a single P2WPKH address controlled by the injection key
+// Once it is funded, fan-out transactions pay every injection address of a plan
+type Deposit struct {
+	Address *btcutil.AddressWitnessPubKeyHash
+	// Amount to send to the deposit address, fees of the fan-out transactions included
+	Amount int64
+	// Fee paid by the fan-out transactions
+	Fee int64
+
+	UTXO  *wire.OutPoint
+	Value int64
+
+	pkScript []byte
+	feeRate  int
+	signer   Signer
+}
+
+// NewDeposit creates the deposit address funding all injections of a plan
+// The address of the manifest depends on the txids of the parts, so it is funded by a second fan-out transaction spending the change of the first one
+func (p *Plan) NewDeposit() (*Deposit, error) {
+	return p.newDeposit(true)
+}
+
+// NewManifestDeposit creates the deposit address funding only the manifest of a plan, for parts funded by other means
+func (p *Plan) NewManifestDeposit() (*Deposit, error) {
+	if !p.NeedsManifest() {
+		return nil, errors.New("plan has no manifest")
+	}
+
+	return p.newDeposit(false)
+}
+
+func (p *Plan) newDeposit(fundParts bool) (*Deposit, error) {
+	addr, err := DepositAddress(p.signer.PubKey(), p.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	deposit := Deposit{
+		Address:  addr,
+		pkScript: pkScript,
+		feeRate:  p.FeeRate,
+		signer:   p.signer,
+	}
+
+	if fundParts {
+		var addresses []*InjectionAddress
+		for _, part := range p.Parts {
+			addresses = append(addresses, part.Addresses...)
+		}
+
+		err = deposit.addFanOut(addresses)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.NeedsManifest() {
+		// The manifest size only depends on the number of parts
+		manifest, err := p.BuildManifest(make([]chainhash.Hash, len(p.Parts)))
+		if err != nil {
+			return nil, err
+		}
+
+		err = deposit.addFanOut(manifest.Addresses)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &deposit, nil
+}
+
+// DepositAddress derives the P2WPKH address of the injection key
+// Injection addresses commit to their data, so this script is only used by the deposit and its change
+func DepositAddress(pubKey *btcec.PublicKey, network *chaincfg.Params) (*btcutil.AddressWitnessPubKeyHash, error) {
+	return btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pubKey.SerializeCompressed()), network)
+}
+
+// NewDepositRefund creates an injection spending funded deposit outputs back to the user
+func NewDepositRefund(addresses []*InjectionAddress, feeRate int, signer Signer, network *chaincfg.Params) *Injection {
+	return NewRefund(&depositEncoder{}, addresses, feeRate, signer, network)
+}
+
+// PkScript returns the output script of the deposit address
+func (d *Deposit) PkScript() []byte {
+	return d.pkScript
+}
+
+// addFanOut adds the amounts of a fan-out transaction funding addresses and its fee to the deposit
+// The fee is estimated with a change output, which is dropped if the change is below the dust limit
+func (d *Deposit) addFanOut(addresses []*InjectionAddress) error {
+	tx, err := d.buildFanOut(addresses, d.pkScript, 0, true)
+	if err != nil {
+		return err
+	}
+
+	fee := int64(virtualSize(tx) * d.feeRate)
+
+	for _, addr := range addresses {
+		d.Amount += addr.Amount
+	}
+	d.Amount += fee
+	d.Fee += fee
+
+	return nil
+}
+
+// FanOut builds and signs the transaction spending the deposit to fund addresses
+// The remaining funds are sent to changeScript, if the change returns to the deposit address it can fund the next fan-out transaction
+func (d *Deposit) FanOut(addresses []*InjectionAddress, changeScript []byte) (*wire.MsgTx, error) {
+	if d.UTXO == nil {
+		return nil, errors.New("deposit is not funded")
+	}
+
+	var total int64
+	for _, addr := range addresses {
+		total += addr.Amount
+	}
+
+	change, err := d.fanOutChange(addresses, changeScript, total)
+	if err != nil {
+		return nil, err
+	}
+
+	// Change below the dust limit is left to the miners
+	if change < consensus.P2PKHDustLimit {
+		changeScript = nil
+
+		change, err = d.fanOutChange(addresses, nil, total)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if change < 0 {
+		return nil, errors.New("deposit does not cover the fan-out transaction")
+	}
+
+	tx, err := d.buildFanOut(addresses, changeScript, change, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Injections spend the outputs of the fan-out transaction in the same order as the addresses
+	txid := tx.TxHash()
+	for k, addr := range addresses {
+		addr.UTXO = wire.NewOutPoint(&txid, uint32(k))
+	}
+
+	d.UTXO = nil
+	d.Value = 0
+	if changeScript != nil && bytes.Equal(changeScript, d.pkScript) {
+		d.UTXO = wire.NewOutPoint(&txid, uint32(len(addresses)))
+		d.Value = change
+	}
+
+	return tx, nil
+}
+
+// fanOutChange computes the change left once the addresses and the fee of the fan-out transaction are paid
+func (d *Deposit) fanOutChange(addresses []*InjectionAddress, changeScript []byte, total int64) (int64, error) {
+	tx, err := d.buildFanOut(addresses, changeScript, 0, true)
+	if err != nil {
+		return 0, err
+	}
+
+	return d.Value - total - int64(virtualSize(tx)*d.feeRate), nil
+}
+
+// buildFanOut creates a transaction spending the deposit to the addresses, with a change output unless changeScript is nil
+func (d *Deposit) buildFanOut(addresses []*InjectionAddress, changeScript []byte, change int64, dummy bool) (*wire.MsgTx, error) {
+	tx := wire.NewMsgTx(wire.TxVersion)
+
+	utxo := d.UTXO
+	if dummy {
+		utxo = wire.NewOutPoint(chaincfg.MainNetParams.GenesisHash, 0)
+	}
+	tx.AddTxIn(wire.NewTxIn(utxo, nil, nil))
+
+	for _, addr := range addresses {
+		pkScript, err := txscript.PayToAddrScript(addr.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		tx.AddTxOut(wire.NewTxOut(addr.Amount, pkScript))
+	}
+
+	if changeScript != nil {
+		tx.AddTxOut(wire.NewTxOut(change, changeScript))
+	}
+
+	var sigHashes *txscript.TxSigHashes
+	prevOut := wire.NewTxOut(d.Value, d.pkScript)
+	if !dummy {
+		prevOuts := txscript.NewCannedPrevOutputFetcher(d.pkScript, d.Value)
+		sigHashes = txscript.NewTxSigHashes(tx, prevOuts)
+	}
+
+	witness, err := buildDepositWitness(tx, sigHashes, d.signer, 0, prevOut, dummy)
+	if err != nil {
+		return nil, err
+	}
+	tx.TxIn[0].Witness = witness
+
+	return tx, nil
+}
+
+// WaitPayment waits until the deposit address holds an unspent output of at least the expected amount
+// Spent outputs of earlier injections of the same file are ignored
+func (d *Deposit) WaitPayment() error {
+	for {
+		unspent, err := electrum.Client.ListUnspent(electrum.ScriptHash(d.pkScript))
+		if err != nil {
+			return err
+		}
+
+		for _, u := range unspent {
+			if int64(u.Value) < d.Amount {
+				continue
+			}
+
+			txHash, err := chainhash.NewHashFromStr(u.Hash)
+			if err != nil {
+				return err
+			}
+
+			d.UTXO = wire.NewOutPoint(txHash, u.Position)
+			// The fan-out signature commits to the exact amount received
+			d.Value = int64(u.Value)
+			return nil
+		}
+
+		// Check for new payments every second
+		time.Sleep(time.Second)
+	}
+}
+
+func buildDepositWitness(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, signer Signer, inputIndex int, prevOut *wire.TxOut, dummy bool) (wire.TxWitness, error) {
+	pubKey := signer.PubKey().SerializeCompressed()
+
+	if dummy {
+		// Empty signature of max possible size
+		return wire.TxWitness{make([]byte, consensus.ECDSAMaxSignatureSize), pubKey}, nil
+	}
+
+	// P2WPKH inputs are signed with their output script
+	sig, err := signer.SignWitness(tx, sigHashes, inputIndex, prevOut, prevOut.PkScript)
+	if err != nil {
+		return nil, err
+	}
+
+	// Witness is: [SIG] [PUBKEY]
+	return wire.TxWitness{sig, pubKey}, nil
+}
+
+// depositEncoder spends deposit outputs when refunding them, it holds no data
+type depositEncoder struct{}
+
+// Name identifies deposit inputs
+func (e *depositEncoder) Name() string {
+	return "deposit"
+}
+
+// MaxPartSize returns 0 as deposits hold no data
+func (e *depositEncoder) MaxPartSize() int {
+	return 0
+}
+
+// Chunks returns no inputs as deposits hold no data
+func (e *depositEncoder) Chunks(data []byte) [][][]byte {
+	return nil
+}
+
+// Address derives the deposit address
+func (e *depositEncoder) Address(pubKey *btcec.PublicKey, chunks [][]byte, network *chaincfg.Params) (btcutil.Address, error) {
+	return DepositAddress(pubKey, network)
+}
+
+// Outputs returns no outputs as deposits hold no data
+func (e *depositEncoder) Outputs(chunks [][]byte) ([]*wire.TxOut, error) {
+	return nil, nil
+}
+
+// Spend builds the P2WPKH witness of a deposit input
+func (e *depositEncoder) Spend(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, chunks [][]byte, signer Signer, dummy bool) (wire.TxWitness, []byte, error) {
+	witness, err := buildDepositWitness(tx, sigHashes, signer, inputIndex, prevOut, dummy)
+	return witness, nil, err
+}
+
+// Decode always fails as deposits hold no data
+func (e *depositEncoder) Decode(tx *wire.MsgTx) ([]byte, error) {
+	return nil, errors.New("deposits hold no data")
+}
+
+// Author always fails as deposits hold no data
+func (e *depositEncoder) Author(tx *wire.MsgTx) (*btcec.PublicKey, error) {
+	return nil, errors.New("deposits hold no data")
+}
+
+// virtualSize computes the virtual size of a segwit transaction
+func virtualSize(tx *wire.MsgTx) int {
+	return (3*tx.SerializeSizeStripped() + tx.SerializeSize() + 3) / 4
+}
@@ -0,0 +1,164 @@
+package injector
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// executeFanOut runs the script engine on the deposit input of a fan-out transaction
+func executeFanOut(t *testing.T, tx *wire.MsgTx, deposit *Deposit, value int64) error {
+	t.Helper()
+
+	prevOuts := txscript.NewCannedPrevOutputFetcher(deposit.PkScript(), value)
+	vm, err := txscript.NewEngine(deposit.PkScript(), tx, 0, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx, prevOuts), value, prevOuts)
+	if err != nil {
+		return err
+	}
+
+	return vm.Execute()
+}
+
+// checkFanOut checks that a fan-out transaction pays every address its amount, in order
+func checkFanOut(t *testing.T, tx *wire.MsgTx, addresses []*InjectionAddress) {
+	t.Helper()
+
+	txid := tx.TxHash()
+	for k, addr := range addresses {
+		pkScript, err := txscript.PayToAddrScript(addr.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(tx.TxOut[k].PkScript, pkScript) || tx.TxOut[k].Value != addr.Amount {
+			t.Fatalf("output %d does not pay address %s", k, addr.Address)
+		}
+
+		if *addr.UTXO != *wire.NewOutPoint(&txid, uint32(k)) {
+			t.Fatalf("address %s does not spend output %d", addr.Address, k)
+		}
+	}
+}
+
+func TestDepositFanOut(t *testing.T) {
+	signer := testSigner(t)
+	encoder := &WitnessScriptEncoder{Profile: consensus.Standard}
+	data := make([]byte, 3000)
+
+	tests := []struct {
+		name   string
+		parity int
+	}{
+		{"single part", 0},
+		{"parts and manifest", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var plan *Plan
+			var err error
+			if test.parity > 0 {
+				plan, err = NewErasurePlan(data, encoder, 2, test.parity, 2, signer, &chaincfg.RegressionNetParams)
+			} else {
+				plan, err = NewPlan(data, encoder, 2, signer, &chaincfg.RegressionNetParams)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			deposit, err := plan.NewDeposit()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var addresses []*InjectionAddress
+			var total int64
+			for _, part := range plan.Parts {
+				addresses = append(addresses, part.Addresses...)
+				for _, addr := range part.Addresses {
+					total += addr.Amount
+				}
+			}
+
+			// The quote pays every address and the fees of the fan-out transactions
+			if deposit.Fee <= 0 || (!plan.NeedsManifest() && deposit.Amount != total+deposit.Fee) {
+				t.Fatalf("deposit of %d sats with %d sats of fees for %d sats of addresses", deposit.Amount, deposit.Fee, total)
+			}
+
+			if _, err := deposit.FanOut(addresses, deposit.PkScript()); err == nil {
+				t.Fatal("expected an unfunded deposit error")
+			}
+
+			deposit.UTXO = &wire.OutPoint{Hash: testTxIDs(1)[0]}
+			deposit.Value = deposit.Amount
+
+			tx, err := deposit.FanOut(addresses, deposit.PkScript())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := executeFanOut(t, tx, deposit, deposit.Amount); err != nil {
+				t.Fatal(err)
+			}
+			checkFanOut(t, tx, addresses)
+
+			if !plan.NeedsManifest() {
+				// The exact amount leaves no change
+				if len(tx.TxOut) != len(addresses) || deposit.UTXO != nil {
+					t.Fatalf("%d outputs for %d addresses", len(tx.TxOut), len(addresses))
+				}
+				return
+			}
+
+			// The change returns to the deposit address to fund the manifest
+			change := deposit.Value
+			txid := tx.TxHash()
+			if deposit.UTXO == nil || *deposit.UTXO != *wire.NewOutPoint(&txid, uint32(len(addresses))) {
+				t.Fatal("change does not fund the manifest")
+			}
+
+			manifest, err := plan.BuildManifest(testTxIDs(len(plan.Parts)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			manifestTx, err := deposit.FanOut(manifest.Addresses, deposit.PkScript())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := executeFanOut(t, manifestTx, deposit, change); err != nil {
+				t.Fatal(err)
+			}
+			checkFanOut(t, manifestTx, manifest.Addresses)
+
+			if len(manifestTx.TxOut) != len(manifest.Addresses) || deposit.UTXO != nil {
+				t.Fatal("change below the dust limit should be left to the miners")
+			}
+		})
+	}
+}
+
+func TestDepositFanOutUnderfunded(t *testing.T) {
+	signer := testSigner(t)
+	plan, err := NewPlan(make([]byte, 3000), &WitnessScriptEncoder{Profile: consensus.Standard}, 2, signer, &chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deposit, err := plan.NewDeposit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deposit.UTXO = &wire.OutPoint{Hash: testTxIDs(1)[0]}
+	deposit.Value = deposit.Amount - deposit.Fee
+
+	if _, err := deposit.FanOut(plan.Parts[0].Addresses, nil); err == nil {
+		t.Fatal("expected the deposit not to cover the fan-out transaction")
+	}
+}
@@ -0,0 +1,83 @@
+package injector
+
+import (
+	"errors"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Encoder represents a way of storing data in a transaction using a specific script template
+type Encoder interface {
+	// Name identifies the encoder, it is used to select an injection method
+	Name() string
+
+	// MaxPartSize returns the maximum size of data that can be stored in a single standard transaction
+	MaxPartSize() int
+
+	// Chunks splits data into the chunks held by each input
+	Chunks(data []byte) [][][]byte
+
+	// Address derives the address the user must fund so that an input can hold the chunks
+	Address(pubKey *btcec.PublicKey, chunks [][]byte, network *chaincfg.Params) (btcutil.Address, error)
+
+	// Outputs returns the outputs holding the chunks, if data is not stored in the inputs
+	Outputs(chunks [][]byte) ([]*wire.TxOut, error)
+
+	// Spend builds the witness and the signature script of the input holding the chunks
+	// Dummy inputs hold placeholder signatures of the maximum size, they are used to estimate the size of the transaction
+	Spend(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, chunks [][]byte, signer Signer, dummy bool) (wire.TxWitness, []byte, error)
+
+	// Decode extracts the data stored in a transaction
+	// An error is returned if the transaction does not use this encoder
+	Decode(tx *wire.MsgTx) ([]byte, error)
+
+	// Author extracts the public key signing the inputs that hold or fund the data
+	Author(tx *wire.MsgTx) (*btcec.PublicKey, error)
+}
+
+// ProfileEncoder is implemented by encoders whose limits depend on the policy of the nodes relaying the transaction
+type ProfileEncoder interface {
+	// WithProfile returns a copy of the encoder using different limits
+	WithProfile(profile consensus.Profile) Encoder
+}
+
+// BackupEncoder is implemented by encoders whose scripts can let a backup key reclaim the funds
+type BackupEncoder interface {
+	// WithBackup returns a copy of the encoder adding a timelocked branch spendable by the backup key
+	WithBackup(backup *Backup) Encoder
+}
+
+var encoders []Encoder
+
+func init() {
+	RegisterEncoder(&WitnessScriptEncoder{Nested: true, Profile: consensus.Standard})
+	RegisterEncoder(&WitnessScriptEncoder{Profile: consensus.Standard})
+	RegisterEncoder(&TaprootEncoder{})
+	RegisterEncoder(&OPReturnEncoder{})
+}
+
+// RegisterEncoder makes an encoder available for injection and retrieval
+func RegisterEncoder(encoder Encoder) {
+	encoders = append(encoders, encoder)
+}
+
+// Encoders returns all registered encoders
+func Encoders() []Encoder {
+	return encoders
+}
+
+// EncoderByName returns the registered encoder with the given name
+func EncoderByName(name string) (Encoder, error) {
+	for _, encoder := range encoders {
+		if encoder.Name() == name {
+			return encoder, nil
+		}
+	}
+
+	return nil, errors.New("unknown injection method: " + name)
+}
@@ -0,0 +1,95 @@
+package injector
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// maxShards is the maximum number of shards supported by Reed-Solomon over GF(2^8)
+const maxShards = 256
+
+// splitShards splits data into data shards and computes the parity shards
+// Any dataShards of the returned shards are enough to rebuild the data
+func splitShards(data []byte, dataShards, parityShards int) ([][]byte, error) {
+	if dataShards < 1 || parityShards < 1 || dataShards+parityShards > maxShards {
+		return nil, errors.New("invalid number of shards")
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("no data to shard")
+	}
+
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	shards, err := enc.Split(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = enc.Encode(shards)
+	if err != nil {
+		return nil, err
+	}
+
+	return shards, nil
+}
+
+// CheckShards discards retrieved shards whose checksum does not match the manifest
+// Missing shards are nil, the indices of corrupted shards are returned
+func CheckShards(manifest *Manifest, shards [][]byte) []int {
+	var corrupted []int
+
+	for i, shard := range shards {
+		if shard != nil && sha256.Sum256(shard) != manifest.Checksums[i] {
+			shards[i] = nil
+			corrupted = append(corrupted, i)
+		}
+	}
+
+	return corrupted
+}
+
+// JoinShards rebuilds a file from the shards listed by a manifest
+// Missing or discarded shards are nil, at least DataShards shards must be present
+func JoinShards(manifest *Manifest, shards [][]byte) ([]byte, error) {
+	if len(shards) != manifest.DataShards+manifest.ParityShards {
+		return nil, errors.New("invalid number of shards")
+	}
+
+	var present int
+	for _, shard := range shards {
+		if shard != nil {
+			present++
+		}
+	}
+
+	if present < manifest.DataShards {
+		return nil, errors.New("not enough shards to rebuild the file")
+	}
+
+	enc, err := reedsolomon.New(manifest.DataShards, manifest.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	err = enc.ReconstructData(shards)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for _, shard := range shards[:manifest.DataShards] {
+		data = append(data, shard...)
+	}
+
+	if uint64(len(data)) < manifest.Length {
+		return nil, errors.New("shards are too short")
+	}
+
+	return data[:manifest.Length], nil
+}
@@ -0,0 +1,122 @@
+package injector
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+)
+
+func testErasureManifest(t *testing.T, data []byte, dataShards, parityShards int) (*Manifest, [][]byte) {
+	t.Helper()
+
+	// Shards may share the memory of the data
+	shards, err := splitShards(append([]byte{}, data...), dataShards, parityShards)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := Manifest{
+		TxIDs:        testTxIDs(len(shards)),
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+		Length:       uint64(len(data)),
+	}
+	for _, shard := range shards {
+		manifest.Checksums = append(manifest.Checksums, sha256.Sum256(shard))
+	}
+
+	return &manifest, shards
+}
+
+func TestJoinShardsReconstruction(t *testing.T) {
+	data := make([]byte, 10007)
+	_, _ = rand.Read(data)
+
+	tests := []struct {
+		name    string
+		missing []int
+	}{
+		{"all shards", nil},
+		{"missing data shard", []int{0}},
+		{"missing parity shard", []int{5}},
+		{"missing two data shards", []int{1, 3}},
+		{"missing data and parity shards", []int{2, 4}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manifest, shards := testErasureManifest(t, data, 4, 2)
+			for _, i := range test.missing {
+				shards[i] = nil
+			}
+
+			rebuilt, err := JoinShards(manifest, shards)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(rebuilt, data) {
+				t.Fatal("rebuilt data does not match")
+			}
+		})
+	}
+}
+
+func TestJoinShardsNotEnough(t *testing.T) {
+	manifest, shards := testErasureManifest(t, []byte("not enough shards to rebuild this"), 3, 2)
+	shards[0], shards[2], shards[4] = nil, nil, nil
+
+	_, err := JoinShards(manifest, shards)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	_, err = JoinShards(manifest, shards[:4])
+	if err == nil {
+		t.Fatal("expected an error for a wrong number of shards")
+	}
+}
+
+func TestCheckShardsDiscardsCorrupted(t *testing.T) {
+	data := []byte("corrupted shards are discarded before reconstruction")
+	manifest, shards := testErasureManifest(t, data, 3, 2)
+	shards[1][0] ^= 0xff
+	shards[3] = nil
+
+	corrupted := CheckShards(manifest, shards)
+	if len(corrupted) != 1 || corrupted[0] != 1 || shards[1] != nil {
+		t.Fatalf("corrupted = %v, want [1]", corrupted)
+	}
+
+	rebuilt, err := JoinShards(manifest, shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(rebuilt, data) {
+		t.Fatal("rebuilt data does not match")
+	}
+}
+
+func TestSplitShardsInvalid(t *testing.T) {
+	tests := []struct {
+		name                     string
+		data                     []byte
+		dataShards, parityShards int
+	}{
+		{"no data", nil, 2, 1},
+		{"no data shards", []byte("data"), 0, 1},
+		{"no parity shards", []byte("data"), 2, 0},
+		{"too many shards", []byte("data"), 200, 57},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := splitShards(test.data, test.dataShards, test.parityShards)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
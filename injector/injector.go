@@ -2,28 +2,25 @@ package injector
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
 	"sync"
 	"time"
 
 	"github.com/aureleoules/bitcandle/consensus"
 	"github.com/aureleoules/bitcandle/electrum"
-	"github.com/aureleoules/bitcandle/util"
-	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/btcsuite/btcutil"
 )
 
-// InjectionAddress holds informations about a script hash address
-// The P2SH-P2WSH address is derived from the user's public key and the file's data
-// The user must sends coins to this script hash address.
-// The UTXO can be redeemed by providing a signature script containing the corresponding file
+// InjectionAddress holds informations about an address funding an input
+// The address is derived by the encoder from the user's public key and the file's data
+// The user must sends coins to this address.
+// The UTXO can be redeemed by providing a witness containing the corresponding file
 type InjectionAddress struct {
-	Address *btcutil.AddressScriptHash
+	Address btcutil.Address
 	UTXO    *wire.OutPoint
 	Amount  int64
 	Chunks  [][]byte
@@ -31,44 +28,53 @@ type InjectionAddress struct {
 
 // Injection holds all necessary information to inject arbitrary data on the Bitcoin network
 type Injection struct {
+	Encoder   Encoder
 	Network   *chaincfg.Params
 	FeeRate   int
 	Addresses []*InjectionAddress
 
-	parts      [][]byte
-	privateKey *btcec.PrivateKey
+	signer  Signer
+	claimed *outPointSet
 }
 
-// NewInjection creates a new data injection structure
-func NewInjection(data []byte, feeRate int, key *btcec.PrivateKey, network *chaincfg.Params) (*Injection, error) {
-	injection := Injection{
-		// Create as many inputs as needed
-		parts:      dataToChunks(data, (consensus.P2SHP2WSHStackItems-1)*consensus.P2SHP2WSHPushDataLimit),
-		Network:    network,
-		FeeRate:    feeRate,
-		privateKey: key,
-		Addresses:  make([]*InjectionAddress, 0),
-	}
+// outPointSet holds the outpoints received by the injections of a plan
+// Parts holding the same data share their address, each payment must fund a single input
+type outPointSet struct {
+	sync.Mutex
+	outPoints map[wire.OutPoint]bool
+}
 
-	for _, p := range injection.parts {
-		// Split data into chunks of 520 bytes
-		// This is the maximum of data that can be pushed on the stack at a time
-		chunks := dataToChunks(p, consensus.P2SHP2WSHPushDataLimit)
+func newOutPointSet() *outPointSet {
+	return &outPointSet{outPoints: make(map[wire.OutPoint]bool)}
+}
 
-		// Build witness script
-		witnessScript, err := buildWitnessScript(key.PubKey(), chunks)
-		if err != nil {
-			return nil, err
-		}
+// claim marks an outpoint as used, it returns false if it was already claimed
+func (s *outPointSet) claim(outPoint wire.OutPoint) bool {
+	s.Lock()
+	defer s.Unlock()
 
-		// Build redeem script (OP_0 0x20 [witness prog])
-		redeemScript, err := buildRedeemScript(buildWitnessProg(witnessScript))
-		if err != nil {
-			return nil, err
-		}
+	if s.outPoints[outPoint] {
+		return false
+	}
 
-		// Hash redeemscript to build the scriptHash address
-		addr, err := btcutil.NewAddressScriptHash(redeemScript, network)
+	s.outPoints[outPoint] = true
+	return true
+}
+
+// NewInjection creates a new data injection structure
+func NewInjection(data []byte, encoder Encoder, feeRate int, signer Signer, network *chaincfg.Params) (*Injection, error) {
+	injection := Injection{
+		Encoder:   encoder,
+		Network:   network,
+		FeeRate:   feeRate,
+		signer:    signer,
+		claimed:   newOutPointSet(),
+		Addresses: make([]*InjectionAddress, 0),
+	}
+
+	// Create as many inputs as needed
+	for _, chunks := range encoder.Chunks(data) {
+		addr, err := encoder.Address(signer.PubKey(), chunks, network)
 		if err != nil {
 			return nil, err
 		}
@@ -78,16 +84,16 @@ func NewInjection(data []byte, feeRate int, key *btcec.PrivateKey, network *chai
 			Address: addr,
 			Chunks:  chunks,
 		})
+	}
 
-		_, amount, err := injection.EstimateCost()
-		if err != nil {
-			return nil, err
-		}
+	_, amount, err := injection.EstimateCost()
+	if err != nil {
+		return nil, err
+	}
 
-		// Set required UTXO amount for each address
-		for i := range injection.Addresses {
-			injection.Addresses[i].Amount = amount
-		}
+	// Set required UTXO amount for each address
+	for i := range injection.Addresses {
+		injection.Addresses[i].Amount = amount
 	}
 
 	return &injection, nil
@@ -95,14 +101,14 @@ func NewInjection(data []byte, feeRate int, key *btcec.PrivateKey, network *chai
 
 // NumInputs counts the number of inputs required to store the file
 func (i *Injection) NumInputs() int {
-	return len(i.parts)
+	return len(i.Addresses)
 }
 
 // EstimateCost creates a dummy transaction containing all signature scripts required to store the file
 // This allows us to estimate the final transaction size in bytes
 func (i *Injection) EstimateCost() (int64, int64, error) {
 	// Generate dummy private key
-	dummyKey, err := btcec.NewPrivateKey(btcec.S256())
+	dummyKey, err := btcec.NewPrivateKey()
 	if err != nil {
 		return 0, 0, err
 	}
@@ -158,129 +164,81 @@ func (i *Injection) buildTX(txOut *wire.TxOut, dummy bool) (*wire.MsgTx, error)
 	// Add mandatory txout
 	tx.AddTxOut(txOut)
 
+	// Some encoders store data in the outputs following the mandatory txout
 	for _, addr := range i.Addresses {
-		// Create a dummy UTXO for estimation purposes
-		if dummy {
-			addr.UTXO = wire.NewOutPoint(chaincfg.MainNetParams.GenesisHash, 0)
-		}
-
-		// Add UTXO to the transaction
-		txIn := wire.NewTxIn(addr.UTXO, nil, nil)
-		tx.AddTxIn(txIn)
-	}
-
-	var witnesses []wire.TxWitness
-	for k, addr := range i.Addresses {
-		// Sign each input individually
-		witness, err := buildWitness(tx, addr.Chunks, i.privateKey, k, addr.Amount, dummy)
+		outputs, err := i.Encoder.Outputs(addr.Chunks)
 		if err != nil {
 			return nil, err
 		}
-		// Store script signature separately
-		witnesses = append(witnesses, witness)
-	}
-
-	// Once all inputs are signed, add script signatures to their corresponding inputs
-	for k := range witnesses {
-		tx.TxIn[k].Witness = witnesses[k]
 
-		// Build witness script
-		witnessScript, err := buildWitnessScript(i.privateKey.PubKey(), i.Addresses[k].Chunks)
-		if err != nil {
-			return nil, err
+		for _, output := range outputs {
+			tx.AddTxOut(output)
 		}
+	}
 
-		// Push redeem script only
-		s := txscript.NewScriptBuilder()
-		redeemScript, _ := buildRedeemScript(buildWitnessProg(witnessScript))
-		s.AddData(redeemScript)
-
-		tx.TxIn[k].SignatureScript, err = s.Script()
-		if err != nil {
-			return nil, err
-		}
+	err := i.addInputs(tx, dummy)
+	if err != nil {
+		return nil, err
 	}
 
 	return tx, nil
 }
 
-func buildWitness(tx *wire.MsgTx, chunks [][]byte, key *btcec.PrivateKey, inputIndex int, inputAmount int64, dummy bool) ([][]byte, error) {
-	// The script signature must contain the original redeem script (not hashed)
-	witnessScript, err := buildWitnessScript(key.PubKey(), chunks)
-	if err != nil {
-		return nil, err
-	}
+// addInputs adds the inputs spending every address to a transaction and signs them
+func (i *Injection) addInputs(tx *wire.MsgTx, dummy bool) error {
+	for _, addr := range i.Addresses {
+		utxo := addr.UTXO
+		// Use a dummy UTXO for estimation purposes
+		// Received UTXOs must not be overwritten as the cost may be estimated after payments
+		if dummy {
+			utxo = wire.NewOutPoint(chaincfg.MainNetParams.GenesisHash, 0)
+		}
 
-	var sig []byte
-	if dummy {
-		// Empty signature of max possible size
-		sig = make([]byte, consensus.ECDSAMaxSignatureSize)
-	} else {
-		sigHash := txscript.NewTxSigHashes(tx)
+		// Add UTXO to the transaction
+		txIn := wire.NewTxIn(utxo, nil, nil)
+		tx.AddTxIn(txIn)
+	}
 
-		// Sign transaction pre-image
-		sig, err = txscript.RawTxInWitnessSignature(tx, sigHash, inputIndex, inputAmount, witnessScript, txscript.SigHashAll, key)
+	// Amounts and scripts of all spent outputs are committed to by taproot signatures
+	prevOuts := txscript.NewMultiPrevOutFetcher(nil)
+	spentOutputs := make([]*wire.TxOut, len(i.Addresses))
+	for k, addr := range i.Addresses {
+		pkScript, err := txscript.PayToAddrScript(addr.Address)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		spentOutputs[k] = wire.NewTxOut(addr.Amount, pkScript)
+		prevOuts.AddPrevOut(tx.TxIn[k].PreviousOutPoint, spentOutputs[k])
 	}
 
-	// Create input script
-	var witness wire.TxWitness
-
-	// Push raw signature
-	witness = append(witness, sig)
-
-	// Push each chunk of data
-	for _, chunk := range chunks {
-		witness = append(witness, chunk)
+	var sigHashes *txscript.TxSigHashes
+	if !dummy {
+		sigHashes = txscript.NewTxSigHashes(tx, prevOuts)
 	}
 
-	// Push witness script on the top of the stack
-	witness = append(witness, witnessScript)
-
-	// Return serialized P2SH-P2WSH input script
-	return witness, nil
-}
-
-func buildWitnessScript(pubKey *btcec.PublicKey, chunks [][]byte) ([]byte, error) {
-	witnessScript := txscript.NewScriptBuilder()
-
-	// Reverse traversal of chunks such that the stack is popped in the correct order
-	for i := len(chunks) - 1; i >= 0; i-- {
-		// Hash each chunk of data such that chunks cannot be ordered differently by tx relay nodes or miners
-		// This ensures integrity of the data
-		witnessScript.AddOp(txscript.OP_HASH160)
-		witnessScript.AddData(btcutil.Hash160(chunks[i]))
-		witnessScript.AddOp(txscript.OP_EQUALVERIFY)
+	var witnesses []wire.TxWitness
+	var sigScripts [][]byte
+	for k, addr := range i.Addresses {
+		// Sign each input individually
+		witness, sigScript, err := i.Encoder.Spend(tx, sigHashes, k, spentOutputs[k], addr.Chunks, i.signer, dummy)
+		if err != nil {
+			return err
+		}
+		// Store script signature separately
+		witnesses = append(witnesses, witness)
+		sigScripts = append(sigScripts, sigScript)
 	}
 
-	// Verify tx signature such that the transaction output cannot be redirected to another address
-	// This may not be useful if vout value is equal or close to a dust amount as removing the signature verification would save at most 107 bytes (73 sig + 33 pub + 1 opcode)
-	witnessScript.AddData(pubKey.SerializeCompressed())
-	witnessScript.AddOp(txscript.OP_CHECKSIG)
-
-	// Return serialized P2SH-P2WSH witness script
-	return witnessScript.Script()
-}
-
-func buildWitnessProg(witnessScript []byte) []byte {
-	// witness program is simply sha256(witnessScript)
-	h := sha256.Sum256(witnessScript)
-	return h[:]
-}
-
-func buildRedeemScript(witnessProg []byte) ([]byte, error) {
-	redeemScript := txscript.NewScriptBuilder()
-
-	// redeem script is simply OP_0 0x20 [witness prog]
-	redeemScript.AddOp(txscript.OP_0)
-	redeemScript.AddData(witnessProg)
+	// Once all inputs are signed, add script signatures to their corresponding inputs
+	for k := range witnesses {
+		tx.TxIn[k].Witness = witnesses[k]
+		tx.TxIn[k].SignatureScript = sigScripts[k]
+	}
 
-	return redeemScript.Script()
+	return nil
 }
 
-// WaitPayments waits until all required UTXOs are created on all pre-generated P2SH-P2WSH addresses
+// WaitPayments waits until all required UTXOs are created on all pre-generated addresses
 func (i *Injection) WaitPayments(onPayment func(addr string, num int)) error {
 	var wg sync.WaitGroup
 	// Add number of utxos to wait for
@@ -296,7 +254,7 @@ func (i *Injection) WaitPayments(onPayment func(addr string, num int)) error {
 	var paymentsReceivedMutex sync.Mutex
 
 	for j, address := range i.Addresses {
-		go func(addr *btcutil.AddressScriptHash, j int) {
+		go func(addr btcutil.Address, j int) {
 			// Mark job as done
 			defer wg.Done()
 
@@ -305,54 +263,40 @@ func (i *Injection) WaitPayments(onPayment func(addr string, num int)) error {
 				if err != nil {
 					return
 				}
-				// Electrum servers only accept the hash of the scriptPubKey (in reverse)
-				scriptHash := sha256.Sum256(script)
-				reversedScriptHash := util.ReverseBytes(scriptHash[:])
-				reversedScriptHashHex := hex.EncodeToString(reversedScriptHash)
-
-				// Check all received transactions of a P2SH-P2WSH address
-				history, err := electrum.Client.GetHistory(reversedScriptHashHex)
+				// Only unspent outputs are considered, outputs spent by earlier injections are listed in the history as well
+				unspent, err := electrum.Client.ListUnspent(electrum.ScriptHash(script))
 				if err != nil {
 					return
 				}
 
-				for _, h := range history {
-					// Some electrum servers do not support GetTransaction, so we have to decode the raw transaction manually
-					rawtx, err := electrum.Client.GetRawTransaction(h.Hash)
-					if err != nil {
+				for _, u := range unspent {
+					// Check that enough bitcoins were sent
+					if int64(u.Value) < costPerInput {
 						continue
 					}
 
-					// Decode raw transaction
-					var tx wire.MsgTx
-					rawtxBytes, err := hex.DecodeString(rawtx)
-					if err != nil {
-						return
-					}
-					err = tx.Deserialize(bytes.NewReader(rawtxBytes))
+					txHash, err := chainhash.NewHashFromStr(u.Hash)
 					if err != nil {
 						return
 					}
 
-					for k, vout := range tx.TxOut {
-						// Check that the payment address corresponds to the P2SH-P2WSH address and that enough bitcoins were sent
-						if bytes.Equal(vout.PkScript, script) && vout.Value >= costPerInput {
-							txHash, err := chainhash.NewHashFromStr(h.Hash)
-							if err != nil {
-								return
-							}
-							// Add utxo to corresponding P2SH-P2WSH address
-							i.Addresses[j].UTXO = wire.NewOutPoint(txHash, uint32(k))
-
-							paymentsReceivedMutex.Lock()
-							paymentsReceived++
-							paymentsReceivedMutex.Unlock()
-
-							// Event
-							onPayment(addr.EncodeAddress(), paymentsReceived)
-							return
-						}
+					// The output may already fund another input with the same address
+					outPoint := wire.NewOutPoint(txHash, u.Position)
+					if !i.claimed.claim(*outPoint) {
+						continue
 					}
+					// Add utxo to corresponding injection address
+					i.Addresses[j].UTXO = outPoint
+					// Signatures commit to the exact amount received
+					i.Addresses[j].Amount = int64(u.Value)
+
+					paymentsReceivedMutex.Lock()
+					paymentsReceived++
+					paymentsReceivedMutex.Unlock()
+
+					// Event
+					onPayment(addr.EncodeAddress(), paymentsReceived)
+					return
 				}
 
 				// Check for new payments every second
@@ -0,0 +1,132 @@
+package injector
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// manifestMagic identifies a manifest among retrieved data
+var manifestMagic = []byte("BCMF")
+
+// manifestVersion is the current version of the manifest format
+// Version 2 added erasure coding, manifests without parity shards are still encoded as version 1
+const manifestVersion = 2
+
+// Manifest lists the transactions holding the parts of a file
+type Manifest struct {
+	TxIDs []chainhash.Hash
+
+	// Parts are shards when the file is erasure coded
+	DataShards   int
+	ParityShards int
+	Length       uint64
+	Checksums    [][sha256.Size]byte
+}
+
+// IsErasureCoded returns true if the parts are Reed-Solomon shards
+func (m *Manifest) IsErasureCoded() bool {
+	return m.ParityShards > 0
+}
+
+// EncodeManifest serializes a manifest
+// Version 1: magic (4 bytes) | version (1 byte) | number of parts (varint) | txids (32 bytes each)
+// Version 2: magic (4 bytes) | version (1 byte) | data shards (varint) | parity shards (varint) | length (varint) | number of parts (varint) | txid and SHA-256 of shard (64 bytes each)
+func EncodeManifest(manifest *Manifest) []byte {
+	var buf bytes.Buffer
+
+	buf.Write(manifestMagic)
+
+	if !manifest.IsErasureCoded() {
+		buf.WriteByte(1)
+		_ = wire.WriteVarInt(&buf, 0, uint64(len(manifest.TxIDs)))
+
+		for _, txid := range manifest.TxIDs {
+			buf.Write(txid[:])
+		}
+
+		return buf.Bytes()
+	}
+
+	buf.WriteByte(manifestVersion)
+	_ = wire.WriteVarInt(&buf, 0, uint64(manifest.DataShards))
+	_ = wire.WriteVarInt(&buf, 0, uint64(manifest.ParityShards))
+	_ = wire.WriteVarInt(&buf, 0, manifest.Length)
+	_ = wire.WriteVarInt(&buf, 0, uint64(len(manifest.TxIDs)))
+
+	for i, txid := range manifest.TxIDs {
+		buf.Write(txid[:])
+		buf.Write(manifest.Checksums[i][:])
+	}
+
+	return buf.Bytes()
+}
+
+// DecodeManifest parses a manifest
+// Files may start with the magic bytes as well, retrieved data must only be treated as a manifest if it parses entirely
+func DecodeManifest(data []byte) (*Manifest, error) {
+	if len(data) <= len(manifestMagic) || !bytes.Equal(data[:len(manifestMagic)], manifestMagic) {
+		return nil, errors.New("not a manifest")
+	}
+
+	r := bytes.NewReader(data[len(manifestMagic):])
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, errors.New("truncated manifest")
+	}
+
+	var manifest Manifest
+
+	switch version {
+	case 1:
+		count, err := wire.ReadVarInt(r, 0)
+		if err != nil {
+			return nil, errors.New("truncated manifest")
+		}
+
+		if count == 0 || uint64(r.Len()) != count*chainhash.HashSize {
+			return nil, errors.New("invalid manifest length")
+		}
+
+		manifest.TxIDs = make([]chainhash.Hash, count)
+		for i := range manifest.TxIDs {
+			_, _ = r.Read(manifest.TxIDs[i][:])
+		}
+	case 2:
+		fields := make([]uint64, 4)
+		for i := range fields {
+			fields[i], err = wire.ReadVarInt(r, 0)
+			if err != nil {
+				return nil, errors.New("truncated manifest")
+			}
+		}
+
+		dataShards, parityShards, count := fields[0], fields[1], fields[3]
+		if dataShards == 0 || parityShards == 0 || dataShards+parityShards != count || count > maxShards {
+			return nil, errors.New("invalid manifest shards")
+		}
+
+		if uint64(r.Len()) != count*(chainhash.HashSize+sha256.Size) {
+			return nil, errors.New("invalid manifest length")
+		}
+
+		manifest.DataShards = int(dataShards)
+		manifest.ParityShards = int(parityShards)
+		manifest.Length = fields[2]
+		manifest.TxIDs = make([]chainhash.Hash, count)
+		manifest.Checksums = make([][sha256.Size]byte, count)
+		for i := range manifest.TxIDs {
+			_, _ = io.ReadFull(r, manifest.TxIDs[i][:])
+			_, _ = io.ReadFull(r, manifest.Checksums[i][:])
+		}
+	default:
+		return nil, errors.New("unsupported manifest version")
+	}
+
+	return &manifest, nil
+}
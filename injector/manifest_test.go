@@ -0,0 +1,91 @@
+package injector
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+func testTxIDs(n int) []chainhash.Hash {
+	txids := make([]chainhash.Hash, n)
+	for i := range txids {
+		txids[i] = chainhash.HashH([]byte{byte(i)})
+	}
+	return txids
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	checksums := make([][sha256.Size]byte, 6)
+	for i := range checksums {
+		checksums[i] = sha256.Sum256([]byte{byte(i)})
+	}
+
+	tests := []struct {
+		name     string
+		manifest Manifest
+		version  byte
+	}{
+		{"parts", Manifest{TxIDs: testTxIDs(3)}, 1},
+		{"single part", Manifest{TxIDs: testTxIDs(1)}, 1},
+		{"shards", Manifest{TxIDs: testTxIDs(6), DataShards: 4, ParityShards: 2, Length: 1234, Checksums: checksums}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := EncodeManifest(&test.manifest)
+			if data[len(manifestMagic)] != test.version {
+				t.Fatalf("version = %d, want %d", data[len(manifestMagic)], test.version)
+			}
+
+			manifest, err := DecodeManifest(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(*manifest, test.manifest) {
+				t.Fatalf("decoded %+v, want %+v", *manifest, test.manifest)
+			}
+		})
+	}
+}
+
+func TestDecodeManifestInvalid(t *testing.T) {
+	v1 := EncodeManifest(&Manifest{TxIDs: testTxIDs(2)})
+	v2 := EncodeManifest(&Manifest{TxIDs: testTxIDs(3), DataShards: 2, ParityShards: 1, Length: 10, Checksums: make([][sha256.Size]byte, 3)})
+
+	// Shard counts that do not add up to the number of txids
+	badShards := append([]byte{}, v2...)
+	badShards[len(manifestMagic)+1] = 3
+
+	unknownVersion := append([]byte{}, v1...)
+	unknownVersion[len(manifestMagic)] = 3
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a manifest", []byte("hello world")},
+		{"magic only", manifestMagic},
+		{"file starting with the magic", []byte("BCMF is how this text file starts")},
+		{"zeros", bytes.Repeat([]byte{0}, 10)},
+		{"no parts", EncodeManifest(&Manifest{})},
+		{"unknown version", unknownVersion},
+		{"truncated v1", v1[:len(v1)-1]},
+		{"trailing data v1", append(append([]byte{}, v1...), 0)},
+		{"truncated v2", v2[:len(v2)-1]},
+		{"truncated v2 fields", v2[:len(manifestMagic)+3]},
+		{"invalid shards", badShards},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeManifest(test.data)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package injector
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// OPReturnEncoder stores chunks of 80 bytes in OP_RETURN outputs funded by a single input
+// Data outputs are not discounted like witness data but the input is smaller, which is cheaper for very small files
+// The funding script commits to the SHA-256 of the data so that every part of a file has its own address
+type OPReturnEncoder struct{}
+
+// Name returns the name of the encoder
+func (e *OPReturnEncoder) Name() string {
+	return "op-return"
+}
+
+// MaxPartSize returns the maximum size of data that can be stored in a single standard transaction
+func (e *OPReturnEncoder) MaxPartSize() int {
+	return consensus.MaxOPReturnInjectionSize
+}
+
+// Chunks splits data into chunks of 80 bytes funded by a single input
+func (e *OPReturnEncoder) Chunks(data []byte) [][][]byte {
+	return [][][]byte{dataToChunks(data, consensus.OPReturnPushDataLimit)}
+}
+
+// Address derives the P2WSH address funding the injection
+func (e *OPReturnEncoder) Address(pubKey *btcec.PublicKey, chunks [][]byte, network *chaincfg.Params) (btcutil.Address, error) {
+	return buildOPReturnAddress(pubKey, chunks, network)
+}
+
+// Outputs creates one OP_RETURN output per chunk
+func (e *OPReturnEncoder) Outputs(chunks [][]byte) ([]*wire.TxOut, error) {
+	return buildOPReturnOutputs(chunks)
+}
+
+// Spend builds the P2WSH witness of the funding input
+func (e *OPReturnEncoder) Spend(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, chunks [][]byte, signer Signer, dummy bool) (wire.TxWitness, []byte, error) {
+	witness, err := buildOPReturnWitness(tx, sigHashes, chunks, signer, inputIndex, prevOut, dummy)
+	return witness, nil, err
+}
+
+// Decode concatenates the data pushed by the OP_RETURN outputs
+// The data is checked against the commitment of the funding script, outputs of transactions without one are not injected data
+func (e *OPReturnEncoder) Decode(tx *wire.MsgTx) ([]byte, error) {
+	var data []byte
+	var found bool
+
+	for _, output := range tx.TxOut {
+		if txscript.GetScriptClass(output.PkScript) != txscript.NullDataTy {
+			continue
+		}
+
+		tokenizer := txscript.MakeScriptTokenizer(0, output.PkScript)
+		// Skip OP_RETURN
+		tokenizer.Next()
+
+		for tokenizer.Next() {
+			chunk, ok := pushedData(tokenizer.Opcode(), tokenizer.Data())
+			if !ok {
+				break
+			}
+
+			found = true
+			data = append(data, chunk...)
+		}
+	}
+
+	if !found {
+		return nil, errors.New("no OP_RETURN output found")
+	}
+
+	hash := sha256.Sum256(data)
+	committed := false
+	for inputIndex, input := range tx.TxIn {
+		// Witness is: [SIG] [SCRIPT]
+		if len(input.Witness) != 2 {
+			continue
+		}
+
+		commitment, _, ok := parseOPReturnScript(input.Witness[1])
+		if !ok {
+			continue
+		}
+
+		if !bytes.Equal(commitment, hash[:]) {
+			return nil, &IntegrityError{Input: inputIndex, Reason: "data does not match the commitment of the funding script"}
+		}
+		committed = true
+	}
+
+	if !committed {
+		return nil, errors.New("no funding script commits to the data")
+	}
+
+	return data, nil
+}
+
+// Author extracts the public key of the inputs funding the injection
+func (e *OPReturnEncoder) Author(tx *wire.MsgTx) (*btcec.PublicKey, error) {
+	var pubKeys [][]byte
+
+	for _, input := range tx.TxIn {
+		if len(input.SignatureScript) > 0 || len(input.Witness) != 2 {
+			continue
+		}
+
+		// Witness is: [SIG] [SCRIPT]
+		if _, pubKey, ok := parseOPReturnScript(input.Witness[1]); ok {
+			pubKeys = append(pubKeys, pubKey)
+		}
+	}
+
+	return singleAuthor(pubKeys, btcec.ParsePubKey)
+}
+
+// buildOPReturnScript creates the witness script funding an OP_RETURN injection
+// <sha256 of data> OP_DROP <pubkey> OP_CHECKSIG
+// The commitment is dropped, it only gives each part of a file its own address
+func buildOPReturnScript(pubKey *btcec.PublicKey, chunks [][]byte) ([]byte, error) {
+	hash := sha256.New()
+	for _, chunk := range chunks {
+		hash.Write(chunk)
+	}
+
+	builder := txscript.NewScriptBuilder()
+	builder.AddData(hash.Sum(nil))
+	builder.AddOp(txscript.OP_DROP)
+	builder.AddData(pubKey.SerializeCompressed())
+	builder.AddOp(txscript.OP_CHECKSIG)
+
+	return builder.Script()
+}
+
+// parseOPReturnScript extracts the commitment and the public key of a script built by buildOPReturnScript
+func parseOPReturnScript(script []byte) ([]byte, []byte, bool) {
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+
+	if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_DATA_32 {
+		return nil, nil, false
+	}
+	commitment := tokenizer.Data()
+
+	if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_DROP {
+		return nil, nil, false
+	}
+
+	if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_DATA_33 {
+		return nil, nil, false
+	}
+	pubKey := tokenizer.Data()
+
+	if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_CHECKSIG || tokenizer.Next() || tokenizer.Err() != nil {
+		return nil, nil, false
+	}
+
+	return commitment, pubKey, true
+}
+
+// buildOPReturnAddress creates the P2WSH address funding an OP_RETURN injection
+func buildOPReturnAddress(pubKey *btcec.PublicKey, chunks [][]byte, network *chaincfg.Params) (*btcutil.AddressWitnessScriptHash, error) {
+	script, err := buildOPReturnScript(pubKey, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	return btcutil.NewAddressWitnessScriptHash(buildWitnessProg(script), network)
+}
+
+// buildOPReturnOutputs creates one OP_RETURN output per chunk
+func buildOPReturnOutputs(chunks [][]byte) ([]*wire.TxOut, error) {
+	var outputs []*wire.TxOut
+
+	for _, chunk := range chunks {
+		script, err := txscript.NullDataScript(chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		// Nothing is burned, OP_RETURN outputs hold no value
+		outputs = append(outputs, wire.NewTxOut(0, script))
+	}
+
+	return outputs, nil
+}
+
+func buildOPReturnWitness(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, chunks [][]byte, signer Signer, inputIndex int, prevOut *wire.TxOut, dummy bool) (wire.TxWitness, error) {
+	script, err := buildOPReturnScript(signer.PubKey(), chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	if dummy {
+		// Empty signature of max possible size
+		return wire.TxWitness{make([]byte, consensus.ECDSAMaxSignatureSize), script}, nil
+	}
+
+	sig, err := signer.SignWitness(tx, sigHashes, inputIndex, prevOut, script)
+	if err != nil {
+		return nil, err
+	}
+
+	// Witness is: [SIG] [SCRIPT]
+	return wire.TxWitness{sig, script}, nil
+}
@@ -0,0 +1,99 @@
+package injector
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestOPReturnRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("small file "), 15)
+	signer := testSigner(t)
+	encoder := &OPReturnEncoder{}
+
+	tx, pkScripts := testSpend(t, encoder, signer, encoder.Chunks(data))
+
+	if err := executeInputs(t, tx, pkScripts); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := encoder.Decode(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decoded, data) {
+		t.Fatal("decoded data does not match")
+	}
+
+	author, err := encoder.Author(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !author.IsEqual(signer.PubKey()) {
+		t.Fatal("author does not match the signer")
+	}
+}
+
+func TestOPReturnDecodeCommitment(t *testing.T) {
+	data := []byte("data is checked against the commitment of the funding script")
+	signer := testSigner(t)
+	encoder := &OPReturnEncoder{}
+
+	tests := []struct {
+		name      string
+		tamper    func(tx *wire.MsgTx)
+		integrity bool
+	}{
+		{"tampered data", func(tx *wire.MsgTx) {
+			script, _ := txscript.NullDataScript([]byte("data that was not committed"))
+			tx.TxOut[0].PkScript = script
+		}, true},
+		{"extra output", func(tx *wire.MsgTx) {
+			script, _ := txscript.NullDataScript([]byte("appended data"))
+			tx.AddTxOut(wire.NewTxOut(0, script))
+		}, true},
+		{"key spend without commitment", func(tx *wire.MsgTx) {
+			tx.TxIn[0].Witness[1] = signer.PubKey().SerializeCompressed()
+		}, false},
+		{"no witness", func(tx *wire.MsgTx) {
+			tx.TxIn[0].Witness = nil
+		}, false},
+		{"no OP_RETURN output", func(tx *wire.MsgTx) {
+			tx.TxOut = tx.TxOut[1:]
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, _ := testSpend(t, encoder, signer, encoder.Chunks(data))
+			test.tamper(tx)
+
+			_, err := encoder.Decode(tx)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			var integrityErr *IntegrityError
+			if errors.As(err, &integrityErr) != test.integrity {
+				t.Fatalf("err = %v, integrity error expected: %v", err, test.integrity)
+			}
+		})
+	}
+}
+
+func TestOPReturnAuthorWithoutCommitment(t *testing.T) {
+	signer := testSigner(t)
+	encoder := &OPReturnEncoder{}
+
+	tx, _ := testSpend(t, encoder, signer, encoder.Chunks([]byte("signed by a key")))
+	tx.TxIn[0].Witness[1] = signer.PubKey().SerializeCompressed()
+
+	if _, err := encoder.Author(tx); err == nil {
+		t.Fatal("expected no author for inputs without a commitment script")
+	}
+}
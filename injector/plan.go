@@ -0,0 +1,150 @@
+package injector
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// Plan holds all injections required to store a file that does not fit in a single standard transaction
+// Each part of the file is injected in its own transaction
+// A manifest transaction then lists the txids of all parts in order
+type Plan struct {
+	Encoder Encoder
+	Network *chaincfg.Params
+	FeeRate int
+	Parts   []*Injection
+
+	// Parts are Reed-Solomon shards if ParityShards is set
+	DataShards   int
+	ParityShards int
+
+	length    uint64
+	checksums [][sha256.Size]byte
+	signer    Signer
+	claimed   *outPointSet
+}
+
+// NewPlan splits data into as many injections as needed
+func NewPlan(data []byte, encoder Encoder, feeRate int, signer Signer, network *chaincfg.Params) (*Plan, error) {
+	plan := Plan{
+		Encoder: encoder,
+		Network: network,
+		FeeRate: feeRate,
+		Parts:   make([]*Injection, 0),
+		signer:  signer,
+		claimed: newOutPointSet(),
+	}
+
+	for _, part := range dataToChunks(data, encoder.MaxPartSize()) {
+		injection, err := NewInjection(part, encoder, feeRate, signer, network)
+		if err != nil {
+			return nil, err
+		}
+
+		// Parts holding the same data share their address
+		injection.claimed = plan.claimed
+		plan.Parts = append(plan.Parts, injection)
+	}
+
+	return &plan, nil
+}
+
+// NewErasurePlan splits data into data shards and parity shards, each injected in its own transaction
+// The file can be rebuilt from any dataShards of the dataShards+parityShards transactions
+func NewErasurePlan(data []byte, encoder Encoder, dataShards, parityShards int, feeRate int, signer Signer, network *chaincfg.Params) (*Plan, error) {
+	shards, err := splitShards(data, dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(shards[0]) > encoder.MaxPartSize() {
+		return nil, errors.New("shards do not fit in a single transaction, increase the number of data shards")
+	}
+
+	plan := Plan{
+		Encoder:      encoder,
+		Network:      network,
+		FeeRate:      feeRate,
+		Parts:        make([]*Injection, 0),
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+		length:       uint64(len(data)),
+		signer:       signer,
+		claimed:      newOutPointSet(),
+	}
+
+	for _, shard := range shards {
+		injection, err := NewInjection(shard, encoder, feeRate, signer, network)
+		if err != nil {
+			return nil, err
+		}
+
+		// Parts holding the same data share their address
+		injection.claimed = plan.claimed
+		plan.Parts = append(plan.Parts, injection)
+		plan.checksums = append(plan.checksums, sha256.Sum256(shard))
+	}
+
+	return &plan, nil
+}
+
+// NeedsManifest returns true if the file is split across several transactions
+func (p *Plan) NeedsManifest() bool {
+	return len(p.Parts) > 1 || p.ParityShards > 0
+}
+
+// EstimateCost estimates the cost of all parts and of the manifest if one is needed
+func (p *Plan) EstimateCost() (int64, error) {
+	var total int64
+
+	for _, part := range p.Parts {
+		cost, _, err := part.EstimateCost()
+		if err != nil {
+			return 0, err
+		}
+		total += cost
+	}
+
+	if p.NeedsManifest() {
+		// The manifest size only depends on the number of parts
+		manifest, err := p.BuildManifest(make([]chainhash.Hash, len(p.Parts)))
+		if err != nil {
+			return 0, err
+		}
+
+		cost, _, err := manifest.EstimateCost()
+		if err != nil {
+			return 0, err
+		}
+		total += cost
+	}
+
+	return total, nil
+}
+
+// ManifestData encodes the manifest listing the txids of all parts
+func (p *Plan) ManifestData(txids []chainhash.Hash) []byte {
+	manifest := Manifest{
+		TxIDs:        txids,
+		DataShards:   p.DataShards,
+		ParityShards: p.ParityShards,
+		Length:       p.length,
+		Checksums:    p.checksums,
+	}
+
+	return EncodeManifest(&manifest)
+}
+
+// BuildManifest creates the injection of the manifest once the txids of all parts are known
+func (p *Plan) BuildManifest(txids []chainhash.Hash) (*Injection, error) {
+	injection, err := NewInjection(p.ManifestData(txids), p.Encoder, p.FeeRate, p.signer, p.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	injection.claimed = p.claimed
+	return injection, nil
+}
@@ -0,0 +1,89 @@
+package injector
+
+import (
+	"errors"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/aureleoules/bitcandle/electrum"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Unspent lists the unspent outputs paying an address, each one as a copy of the address funded with its amount
+func (a *InjectionAddress) Unspent() ([]*InjectionAddress, error) {
+	pkScript, err := txscript.PayToAddrScript(a.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	unspent, err := electrum.Client.ListUnspent(electrum.ScriptHash(pkScript))
+	if err != nil {
+		return nil, err
+	}
+
+	var funded []*InjectionAddress
+	for _, u := range unspent {
+		txHash, err := chainhash.NewHashFromStr(u.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		funded = append(funded, &InjectionAddress{
+			Address: a.Address,
+			UTXO:    wire.NewOutPoint(txHash, u.Position),
+			Amount:  int64(u.Value),
+			Chunks:  a.Chunks,
+		})
+	}
+
+	return funded, nil
+}
+
+// NewRefund creates an injection spending funded addresses back to the user
+// Addresses must hold their UTXO and its exact amount
+func NewRefund(encoder Encoder, addresses []*InjectionAddress, feeRate int, signer Signer, network *chaincfg.Params) *Injection {
+	// Taproot outputs are spent with the key path so that the data is not revealed
+	if _, ok := encoder.(*TaprootEncoder); ok {
+		encoder = &taprootKeyPathEncoder{}
+	}
+
+	return &Injection{
+		Encoder:   encoder,
+		Network:   network,
+		FeeRate:   feeRate,
+		Addresses: addresses,
+		signer:    signer,
+	}
+}
+
+// BuildRefund constructs the transaction sending all funds, minus the fee, to a single output
+// Data outputs are left out, but spending witness script inputs still reveals their chunks
+func (i *Injection) BuildRefund(pkScript []byte) (*wire.MsgTx, error) {
+	var total int64
+	for _, addr := range i.Addresses {
+		total += addr.Amount
+	}
+
+	dummyTx := wire.NewMsgTx(wire.TxVersion)
+	dummyTx.AddTxOut(wire.NewTxOut(0, pkScript))
+	err := i.addInputs(dummyTx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	value := total - int64(virtualSize(dummyTx)*i.FeeRate)
+	if value < consensus.P2PKHDustLimit {
+		return nil, errors.New("refunded amount does not cover the fee")
+	}
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxOut(wire.NewTxOut(value, pkScript))
+	err = i.addInputs(tx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,103 @@
+package injector
+
+import (
+	"testing"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// testFundedAddresses derives addresses of the encoder funded with 1000 sats, one per group of chunks
+func testFundedAddresses(t *testing.T, encoder Encoder, signer Signer, inputs [][][]byte) ([]*InjectionAddress, [][]byte) {
+	t.Helper()
+
+	var addresses []*InjectionAddress
+	var pkScripts [][]byte
+	for k, chunks := range inputs {
+		address, err := encoder.Address(signer.PubKey(), chunks, &chaincfg.RegressionNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pkScript, err := txscript.PayToAddrScript(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkScripts = append(pkScripts, pkScript)
+
+		addresses = append(addresses, &InjectionAddress{
+			Address: address,
+			UTXO:    &wire.OutPoint{Hash: testTxIDs(len(inputs))[k]},
+			Amount:  1000,
+			Chunks:  chunks,
+		})
+	}
+
+	return addresses, pkScripts
+}
+
+func TestBuildRefund(t *testing.T) {
+	signer := testSigner(t)
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	tests := []struct {
+		name     string
+		encoder  Encoder
+		inputs   [][][]byte
+		revealed bool
+	}{
+		{"p2wsh", &WitnessScriptEncoder{Profile: consensus.Standard}, nil, true},
+		{"p2sh-p2wsh", &WitnessScriptEncoder{Nested: true, Profile: consensus.Standard}, nil, true},
+		{"p2tr", &TaprootEncoder{}, nil, false},
+		{"op-return", &OPReturnEncoder{}, nil, false},
+		{"deposit", &depositEncoder{}, [][][]byte{nil, nil}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inputs := test.inputs
+			if inputs == nil {
+				inputs = test.encoder.Chunks(data)
+			}
+			addresses, pkScripts := testFundedAddresses(t, test.encoder, signer, inputs)
+
+			refund := NewRefund(test.encoder, addresses, 1, signer, &chaincfg.RegressionNetParams)
+			tx, err := refund.BuildRefund([]byte{txscript.OP_TRUE})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := executeInputs(t, tx, pkScripts); err != nil {
+				t.Fatal(err)
+			}
+
+			// Everything but the fee is sent to the single output
+			// Signatures may be shorter than the ones used to estimate the fee
+			fee := int64(1000*len(addresses)) - tx.TxOut[0].Value
+			if len(tx.TxOut) != 1 || fee < int64(virtualSize(tx)) || fee > int64(virtualSize(tx)+len(addresses)) {
+				t.Fatalf("fee of %d sats for a transaction of %d vbytes", fee, virtualSize(tx))
+			}
+
+			// Taproot outputs are spent with the key path and do not reveal their chunks
+			if _, err := test.encoder.Decode(tx); (err == nil) != test.revealed {
+				t.Fatalf("err = %v, chunks revealed: %v", err, test.revealed)
+			}
+		})
+	}
+}
+
+func TestBuildRefundDust(t *testing.T) {
+	signer := testSigner(t)
+	encoder := &WitnessScriptEncoder{Profile: consensus.Standard}
+	addresses, _ := testFundedAddresses(t, encoder, signer, encoder.Chunks(make([]byte, 1000)))
+
+	refund := NewRefund(encoder, addresses, 100, signer, &chaincfg.RegressionNetParams)
+	if _, err := refund.BuildRefund([]byte{txscript.OP_TRUE}); err == nil {
+		t.Fatal("expected the fee not to be covered")
+	}
+}
@@ -3,12 +3,24 @@ package injector
 import (
 	"bytes"
 	"errors"
+	"fmt"
 
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 )
 
-// P2SHRetrieveData takes a list of transaction inputs and decodes the signature scripts that contain the file
-func P2SHRetrieveData(rawTxBytes []byte) ([]byte, error) {
+// Retrieval holds the data stored in a transaction and the public key of its author
+type Retrieval struct {
+	Tx      *wire.MsgTx
+	Encoder Encoder
+	Data    []byte
+	Author  *btcec.PublicKey // nil if the author cannot be identified
+}
+
+// RetrieveData asks every registered encoder to decode the file contained in a transaction
+func RetrieveData(rawTxBytes []byte) (*Retrieval, error) {
 	var tx wire.MsgTx
 
 	err := tx.Deserialize(bytes.NewReader(rawTxBytes))
@@ -16,14 +28,101 @@ func P2SHRetrieveData(rawTxBytes []byte) ([]byte, error) {
 		return nil, errors.New("could not decode transaction")
 	}
 
-	var data []byte
+	for _, encoder := range encoders {
+		data, err := encoder.Decode(&tx)
+		if err == nil {
+			// The author is unknown if inputs are signed by different keys
+			author, _ := encoder.Author(&tx)
+			return &Retrieval{Tx: &tx, Encoder: encoder, Data: data, Author: author}, nil
+		}
+
+		// The transaction uses this encoder but its data is corrupted
+		if _, ok := err.(*IntegrityError); ok {
+			return nil, err
+		}
+	}
+
+	return nil, errors.New("no injected data found")
+}
+
+// PrevOuts lists the outputs spent by the transaction, they are needed to verify signatures
+func (r *Retrieval) PrevOuts() []wire.OutPoint {
+	var outPoints []wire.OutPoint
+	for _, input := range r.Tx.TxIn {
+		outPoints = append(outPoints, input.PreviousOutPoint)
+	}
+
+	return outPoints
+}
+
+// VerifySignatures executes the scripts of all inputs, checking the signatures of the author
+func (r *Retrieval) VerifySignatures(prevOuts txscript.PrevOutputFetcher) error {
+	sigHashes := txscript.NewTxSigHashes(r.Tx, prevOuts)
+
+	for inputIndex, input := range r.Tx.TxIn {
+		prevOut := prevOuts.FetchPrevOutput(input.PreviousOutPoint)
+		if prevOut == nil {
+			return fmt.Errorf("input %d: missing previous output", inputIndex)
+		}
+
+		engine, err := txscript.NewEngine(prevOut.PkScript, r.Tx, inputIndex, txscript.StandardVerifyFlags, nil, sigHashes, prevOut.Value, prevOuts)
+		if err != nil {
+			return fmt.Errorf("input %d: %v", inputIndex, err)
+		}
+
+		err = engine.Execute()
+		if err != nil {
+			return fmt.Errorf("input %d: %v", inputIndex, err)
+		}
+	}
+
+	return nil
+}
+
+// IsAuthor checks whether the data was injected by a public key
+// Keys are compared by their x coordinate as taproot only commits to it
+func (r *Retrieval) IsAuthor(pubKey *btcec.PublicKey) bool {
+	return r.Author != nil && pubKey != nil && bytes.Equal(schnorr.SerializePubKey(r.Author), schnorr.SerializePubKey(pubKey))
+}
+
+// singleAuthor parses the public keys found in the inputs and checks that they are all the same
+func singleAuthor(pubKeys [][]byte, parse func([]byte) (*btcec.PublicKey, error)) (*btcec.PublicKey, error) {
+	if len(pubKeys) == 0 {
+		return nil, errors.New("no public key found")
+	}
 
-	for _, input := range tx.TxIn {
-		// Skip signature and witness script
-		for i := 1; i < len(input.Witness)-1; i++ {
-			data = append(data, input.Witness[i]...)
+	for _, pubKey := range pubKeys[1:] {
+		if !bytes.Equal(pubKey, pubKeys[0]) {
+			return nil, errors.New("inputs are signed by different public keys")
 		}
 	}
 
-	return data, nil
+	return parse(pubKeys[0])
+}
+
+// IntegrityError is returned when an input uses an encoder but does not match the data it commits to
+type IntegrityError struct {
+	Input  int
+	Reason string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("input %d: %s", e.Input, e.Reason)
+}
+
+// pushedData returns the data pushed by an opcode
+func pushedData(op byte, data []byte) ([]byte, bool) {
+	switch {
+	case op == txscript.OP_0:
+		// Canonical pushes of a single byte use small integer opcodes
+		return []byte{0}, true
+	case op >= txscript.OP_1 && op <= txscript.OP_16:
+		return []byte{op - txscript.OP_1 + 1}, true
+	case op == txscript.OP_1NEGATE:
+		return []byte{0x81}, true
+	case op <= txscript.OP_PUSHDATA4:
+		return data, true
+	}
+
+	return nil, false
 }
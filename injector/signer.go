@@ -0,0 +1,317 @@
+package injector
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Signer signs the inputs of injection and fan-out transactions
+// It allows the injection key to be kept outside of the process
+type Signer interface {
+	// PubKey returns the public key committed in the injection addresses
+	PubKey() *btcec.PublicKey
+
+	// SignWitness signs a segwit v0 input with SIGHASH_ALL
+	// The script is the witness script, or the output script of P2WPKH inputs
+	SignWitness(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, script []byte) ([]byte, error)
+
+	// SignTapscript signs the script path spend of a tapscript leaf with SIGHASH_DEFAULT
+	// The control block proves that the leaf is committed in the output key
+	SignTapscript(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, leaf txscript.TapLeaf, controlBlock []byte) ([]byte, error)
+
+	// SignTaprootKeyPath signs the key path spend of a taproot output with SIGHASH_DEFAULT
+	// The key is tweaked with the Merkle root of the script tree, the tapscripts are not revealed
+	SignTaprootKeyPath(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, merkleRoot []byte) ([]byte, error)
+}
+
+// KeySigner signs with a private key held in memory
+type KeySigner struct {
+	key *btcec.PrivateKey
+}
+
+// NewKeySigner creates a signer from a private key
+func NewKeySigner(key *btcec.PrivateKey) *KeySigner {
+	return &KeySigner{key: key}
+}
+
+// PubKey returns the public key of the private key
+func (s *KeySigner) PubKey() *btcec.PublicKey {
+	return s.key.PubKey()
+}
+
+// SignWitness signs a segwit v0 input
+func (s *KeySigner) SignWitness(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, script []byte) ([]byte, error) {
+	return txscript.RawTxInWitnessSignature(tx, sigHashes, inputIndex, prevOut.Value, script, txscript.SigHashAll, s.key)
+}
+
+// SignTapscript signs a script path spend
+func (s *KeySigner) SignTapscript(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, leaf txscript.TapLeaf, controlBlock []byte) ([]byte, error) {
+	return txscript.RawTxInTapscriptSignature(tx, sigHashes, inputIndex, prevOut.Value, prevOut.PkScript, leaf, txscript.SigHashDefault, s.key)
+}
+
+// SignTaprootKeyPath signs a key path spend
+func (s *KeySigner) SignTaprootKeyPath(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, merkleRoot []byte) ([]byte, error) {
+	return txscript.RawTxInTaprootSignature(tx, sigHashes, inputIndex, prevOut.Value, prevOut.PkScript, merkleRoot, txscript.SigHashDefault, s.key)
+}
+
+// PSBTSigner lets an external signer sign transactions through PSBTs
+// Inputs of transactions without a signed PSBT are described in new PSBTs to export, no signature is returned for them
+// Their witnesses are incomplete, such transactions are only built for their txids
+// Signatures of signed PSBTs are checked before they are put in place
+type PSBTSigner struct {
+	pubKey *btcec.PublicKey
+	signed map[chainhash.Hash]*psbt.Packet
+
+	// Origin of the key, so that the signer can find it
+	fingerprint uint32
+	path        []uint32
+
+	unsigned []*psbt.Packet
+}
+
+// NewPSBTSigner creates a signer using the signatures of signed PSBTs
+// Without origin, the key is described as a master key
+func NewPSBTSigner(pubKey *btcec.PublicKey, signed []*psbt.Packet) *PSBTSigner {
+	signer := PSBTSigner{
+		pubKey:      pubKey,
+		signed:      make(map[chainhash.Hash]*psbt.Packet),
+		fingerprint: fingerprint(pubKey),
+	}
+
+	for _, packet := range signed {
+		signer.signed[packet.UnsignedTx.TxHash()] = packet
+	}
+
+	return &signer
+}
+
+// WithOrigin returns a copy of the signer describing the key with the fingerprint of its master key and its derivation path
+func (s *PSBTSigner) WithOrigin(fingerprint uint32, path []uint32) *PSBTSigner {
+	signer := *s
+	signer.fingerprint = fingerprint
+	signer.path = path
+	return &signer
+}
+
+// Unsigned returns the PSBTs of the transactions that are missing signatures, in the order they were built
+func (s *PSBTSigner) Unsigned() []*psbt.Packet {
+	return s.unsigned
+}
+
+// PubKey returns the public key of the external signer
+func (s *PSBTSigner) PubKey() *btcec.PublicKey {
+	return s.pubKey
+}
+
+// SignWitness returns the signature of a segwit v0 input, or describes the input if the transaction is not signed yet
+func (s *PSBTSigner) SignWitness(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, script []byte) ([]byte, error) {
+	packet, input, err := s.input(tx, inputIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	if packet == nil {
+		input.WitnessUtxo = prevOut
+		input.SighashType = txscript.SigHashAll
+		input.Bip32Derivation = []*psbt.Bip32Derivation{{
+			PubKey:               s.pubKey.SerializeCompressed(),
+			MasterKeyFingerprint: s.fingerprint,
+			Bip32Path:            s.path,
+		}}
+
+		if !txscript.IsPayToWitnessPubKeyHash(script) {
+			input.WitnessScript = script
+		}
+
+		// Nested inputs push the witness program as redeem script
+		if txscript.IsPayToScriptHash(prevOut.PkScript) {
+			input.RedeemScript, err = buildRedeemScript(buildWitnessProg(script))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	}
+
+	for _, partialSig := range input.PartialSigs {
+		if !bytes.Equal(partialSig.PubKey, s.pubKey.SerializeCompressed()) || len(partialSig.Signature) == 0 {
+			continue
+		}
+
+		sig := partialSig.Signature
+		if txscript.SigHashType(sig[len(sig)-1]) != txscript.SigHashAll {
+			return nil, errors.New("unsupported signature hash type")
+		}
+
+		hash, err := txscript.CalcWitnessSigHash(script, sigHashes, txscript.SigHashAll, tx, inputIndex, prevOut.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		signature, err := ecdsa.ParseDERSignature(sig[:len(sig)-1])
+		if err != nil || !signature.Verify(hash, s.pubKey) {
+			return nil, errors.New("invalid signature")
+		}
+
+		return sig, nil
+	}
+
+	return nil, errors.New("missing signature")
+}
+
+// SignTapscript returns the signature of a script path spend, or describes the input if the transaction is not signed yet
+func (s *PSBTSigner) SignTapscript(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, leaf txscript.TapLeaf, controlBlock []byte) ([]byte, error) {
+	packet, input, err := s.input(tx, inputIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	leafHash := leaf.TapHash()
+	xOnly := schnorr.SerializePubKey(s.pubKey)
+
+	if packet == nil {
+		input.WitnessUtxo = prevOut
+		input.TaprootLeafScript = []*psbt.TaprootTapLeafScript{{
+			ControlBlock: controlBlock,
+			Script:       leaf.Script,
+			LeafVersion:  leaf.LeafVersion,
+		}}
+		input.TaprootInternalKey = xOnly
+		input.TaprootBip32Derivation = []*psbt.TaprootBip32Derivation{{
+			XOnlyPubKey:          xOnly,
+			LeafHashes:           [][]byte{leafHash[:]},
+			MasterKeyFingerprint: s.fingerprint,
+			Bip32Path:            s.path,
+		}}
+
+		return nil, nil
+	}
+
+	for _, scriptSig := range input.TaprootScriptSpendSig {
+		if !bytes.Equal(scriptSig.XOnlyPubKey, xOnly) || !bytes.Equal(scriptSig.LeafHash, leafHash[:]) {
+			continue
+		}
+
+		// Signatures only carry a hash type byte if it is not SIGHASH_DEFAULT
+		sig := scriptSig.Signature
+		hashType := scriptSig.SigHash
+		if len(sig) != schnorr.SignatureSize || (hashType != txscript.SigHashDefault && hashType != txscript.SigHashAll) {
+			return nil, errors.New("unsupported signature hash type")
+		}
+
+		prevOuts := txscript.NewCannedPrevOutputFetcher(prevOut.PkScript, prevOut.Value)
+		hash, err := txscript.CalcTapscriptSignaturehash(sigHashes, hashType, tx, inputIndex, prevOuts, leaf)
+		if err != nil {
+			return nil, err
+		}
+
+		signature, err := schnorr.ParseSignature(sig)
+		if err != nil || !signature.Verify(hash, s.pubKey) {
+			return nil, errors.New("invalid signature")
+		}
+
+		if hashType != txscript.SigHashDefault {
+			sig = append(sig, byte(hashType))
+		}
+
+		return sig, nil
+	}
+
+	return nil, errors.New("missing signature")
+}
+
+// SignTaprootKeyPath returns the signature of a key path spend, or describes the input if the transaction is not signed yet
+func (s *PSBTSigner) SignTaprootKeyPath(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, merkleRoot []byte) ([]byte, error) {
+	packet, input, err := s.input(tx, inputIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	xOnly := schnorr.SerializePubKey(s.pubKey)
+
+	if packet == nil {
+		input.WitnessUtxo = prevOut
+		input.TaprootInternalKey = xOnly
+		input.TaprootMerkleRoot = merkleRoot
+		input.TaprootBip32Derivation = []*psbt.TaprootBip32Derivation{{
+			XOnlyPubKey:          xOnly,
+			MasterKeyFingerprint: s.fingerprint,
+			Bip32Path:            s.path,
+		}}
+
+		return nil, nil
+	}
+
+	// Signatures only carry a hash type byte if it is not SIGHASH_DEFAULT
+	sig := input.TaprootKeySpendSig
+	hashType := txscript.SigHashDefault
+	switch {
+	case len(sig) == schnorr.SignatureSize:
+	case len(sig) == schnorr.SignatureSize+1 && txscript.SigHashType(sig[schnorr.SignatureSize]) == txscript.SigHashAll:
+		hashType = txscript.SigHashAll
+	case len(sig) == 0:
+		return nil, errors.New("missing signature")
+	default:
+		return nil, errors.New("unsupported signature hash type")
+	}
+
+	prevOuts := txscript.NewCannedPrevOutputFetcher(prevOut.PkScript, prevOut.Value)
+	hash, err := txscript.CalcTaprootSignatureHash(sigHashes, hashType, tx, inputIndex, prevOuts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature is made by the output key, the internal key tweaked with the Merkle root
+	signature, err := schnorr.ParseSignature(sig[:schnorr.SignatureSize])
+	if err != nil || !signature.Verify(hash, txscript.ComputeTaprootOutputKey(s.pubKey, merkleRoot)) {
+		return nil, errors.New("invalid signature")
+	}
+
+	return sig, nil
+}
+
+// input returns the signed PSBT of a transaction and the input being signed
+// If the transaction has no signed PSBT, nil is returned along with the input of a new unsigned PSBT
+func (s *PSBTSigner) input(tx *wire.MsgTx, inputIndex int) (*psbt.Packet, *psbt.PInput, error) {
+	// Witnesses and signature scripts are set once all inputs are signed
+	unsignedTx := tx.Copy()
+	for _, txIn := range unsignedTx.TxIn {
+		txIn.Witness = nil
+		txIn.SignatureScript = nil
+	}
+	txid := unsignedTx.TxHash()
+
+	if packet, ok := s.signed[txid]; ok {
+		return packet, &packet.Inputs[inputIndex], nil
+	}
+
+	for _, packet := range s.unsigned {
+		if packet.UnsignedTx.TxHash() == txid {
+			return nil, &packet.Inputs[inputIndex], nil
+		}
+	}
+
+	packet, err := psbt.NewFromUnsignedTx(unsignedTx)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.unsigned = append(s.unsigned, packet)
+
+	return nil, &packet.Inputs[inputIndex], nil
+}
+
+// fingerprint identifies a key without origin in PSBT fields, as the fingerprint of a master key
+func fingerprint(pubKey *btcec.PublicKey) uint32 {
+	hash := btcutil.Hash160(pubKey.SerializeCompressed())
+	return uint32(hash[0]) | uint32(hash[1])<<8 | uint32(hash[2])<<16 | uint32(hash[3])<<24
+}
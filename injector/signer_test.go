@@ -0,0 +1,157 @@
+package injector
+
+import (
+	"testing"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// signPSBT signs every input of a PSBT described for the key, as an external signer would
+func signPSBT(t *testing.T, packet *psbt.Packet, key *btcec.PrivateKey) {
+	t.Helper()
+
+	tx := packet.UnsignedTx
+	prevOuts := txscript.NewMultiPrevOutFetcher(nil)
+	for k, input := range packet.Inputs {
+		prevOuts.AddPrevOut(tx.TxIn[k].PreviousOutPoint, input.WitnessUtxo)
+	}
+	sigHashes := txscript.NewTxSigHashes(tx, prevOuts)
+
+	for k := range packet.Inputs {
+		input := &packet.Inputs[k]
+		prevOut := input.WitnessUtxo
+
+		switch {
+		case len(input.TaprootLeafScript) > 0:
+			leafScript := input.TaprootLeafScript[0]
+			leaf := txscript.NewTapLeaf(leafScript.LeafVersion, leafScript.Script)
+			sig, err := txscript.RawTxInTapscriptSignature(tx, sigHashes, k, prevOut.Value, prevOut.PkScript, leaf, txscript.SigHashDefault, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			leafHash := leaf.TapHash()
+			input.TaprootScriptSpendSig = append(input.TaprootScriptSpendSig, &psbt.TaprootScriptSpendSig{
+				XOnlyPubKey: schnorr.SerializePubKey(key.PubKey()),
+				LeafHash:    leafHash[:],
+				Signature:   sig,
+				SigHash:     txscript.SigHashDefault,
+			})
+		case len(input.TaprootInternalKey) > 0:
+			sig, err := txscript.RawTxInTaprootSignature(tx, sigHashes, k, prevOut.Value, prevOut.PkScript, input.TaprootMerkleRoot, txscript.SigHashDefault, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			input.TaprootKeySpendSig = sig
+		default:
+			// P2WPKH inputs are signed with their output script
+			script := input.WitnessScript
+			if script == nil {
+				script = prevOut.PkScript
+			}
+
+			sig, err := txscript.RawTxInWitnessSignature(tx, sigHashes, k, prevOut.Value, script, txscript.SigHashAll, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			input.PartialSigs = append(input.PartialSigs, &psbt.PartialSig{
+				PubKey:    key.PubKey().SerializeCompressed(),
+				Signature: sig,
+			})
+		}
+	}
+}
+
+func TestPSBTSignerRoundTrip(t *testing.T) {
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2000)
+
+	tests := []struct {
+		name    string
+		encoder Encoder
+	}{
+		{"p2wsh", &WitnessScriptEncoder{Profile: consensus.Standard}},
+		{"p2sh-p2wsh", &WitnessScriptEncoder{Nested: true, Profile: consensus.Standard}},
+		{"p2tr", &TaprootEncoder{}},
+		{"p2tr key path", &taprootKeyPathEncoder{}},
+		{"op-return", &OPReturnEncoder{}},
+		{"deposit", &depositEncoder{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inputs := test.encoder.Chunks(data)
+			if inputs == nil {
+				// Deposits hold no data
+				inputs = [][][]byte{nil, nil}
+			}
+
+			// Transactions without signed PSBTs are described in unsigned PSBTs
+			signer := NewPSBTSigner(key.PubKey(), nil)
+			unsignedTx, _ := testSpend(t, test.encoder, signer, inputs)
+
+			unsigned := signer.Unsigned()
+			if len(unsigned) != 1 || len(unsigned[0].Inputs) != len(inputs) {
+				t.Fatalf("%d unsigned PSBTs", len(unsigned))
+			}
+
+			packet := unsigned[0]
+			for _, input := range packet.Inputs {
+				if input.WitnessUtxo == nil || input.WitnessUtxo.Value != 1000 {
+					t.Fatal("input does not describe the spent output")
+				}
+			}
+
+			// Signatures of the external signer are checked and put in place
+			signPSBT(t, packet, key)
+			tx, pkScripts := testSpend(t, test.encoder, NewPSBTSigner(key.PubKey(), []*psbt.Packet{packet}), inputs)
+
+			if tx.TxHash() != unsignedTx.TxHash() {
+				t.Fatal("signed transaction does not match the unsigned one")
+			}
+
+			if err := executeInputs(t, tx, pkScripts); err != nil {
+				t.Fatal(err)
+			}
+
+			// Signatures made by another key are rejected
+			other, err := btcec.NewPrivateKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signer = NewPSBTSigner(key.PubKey(), nil)
+			testSpend(t, test.encoder, signer, inputs)
+			forged := signer.Unsigned()[0]
+			signPSBT(t, forged, other)
+			for _, input := range forged.Inputs {
+				for _, partialSig := range input.PartialSigs {
+					partialSig.PubKey = key.PubKey().SerializeCompressed()
+				}
+				for _, scriptSig := range input.TaprootScriptSpendSig {
+					scriptSig.XOnlyPubKey = schnorr.SerializePubKey(key.PubKey())
+				}
+			}
+
+			prevOuts := txscript.NewMultiPrevOutFetcher(nil)
+			for k, txIn := range tx.TxIn {
+				prevOuts.AddPrevOut(txIn.PreviousOutPoint, wire.NewTxOut(1000, pkScripts[k]))
+			}
+
+			forgedSigner := NewPSBTSigner(key.PubKey(), []*psbt.Packet{forged})
+			_, _, err = test.encoder.Spend(tx, txscript.NewTxSigHashes(tx, prevOuts), 0, prevOuts.FetchPrevOutput(tx.TxIn[0].PreviousOutPoint), inputs[0], forgedSigner, false)
+			if err == nil {
+				t.Fatal("expected an invalid signature")
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package injector
+
+import (
+	"errors"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TaprootEncoder stores chunks of 520 bytes in an unexecuted envelope of a tapscript leaf
+// Tapscripts are not limited in stack items, a single input holds all the data
+type TaprootEncoder struct{}
+
+// Name returns the name of the encoder
+func (e *TaprootEncoder) Name() string {
+	return "p2tr"
+}
+
+// MaxPartSize returns the maximum size of data that can be stored in a single standard transaction
+func (e *TaprootEncoder) MaxPartSize() int {
+	return consensus.MaxTaprootInjectionSize
+}
+
+// Chunks splits data into chunks of 520 bytes held by a single input
+// This is the maximum of data that can be pushed at a time
+func (e *TaprootEncoder) Chunks(data []byte) [][][]byte {
+	return [][][]byte{dataToChunks(data, consensus.TaprootPushDataLimit)}
+}
+
+// Address derives the bech32m address committing to the tapscript holding the chunks
+func (e *TaprootEncoder) Address(pubKey *btcec.PublicKey, chunks [][]byte, network *chaincfg.Params) (btcutil.Address, error) {
+	return buildTaprootAddress(pubKey, chunks, network)
+}
+
+// Outputs returns no outputs as data is stored in the inputs
+func (e *TaprootEncoder) Outputs(chunks [][]byte) ([]*wire.TxOut, error) {
+	return nil, nil
+}
+
+// Spend builds the script path witness revealing the tapscript, taproot inputs have an empty signature script
+func (e *TaprootEncoder) Spend(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, chunks [][]byte, signer Signer, dummy bool) (wire.TxWitness, []byte, error) {
+	witness, err := buildTaprootWitness(tx, sigHashes, chunks, signer, inputIndex, prevOut, dummy)
+	return witness, nil, err
+}
+
+// Decode concatenates the chunks of the tapscript envelopes revealed by the inputs
+func (e *TaprootEncoder) Decode(tx *wire.MsgTx) ([]byte, error) {
+	var data []byte
+	var found bool
+
+	for _, input := range tx.TxIn {
+		tapscript, ok := revealedTapscript(input.Witness)
+		if !ok {
+			continue
+		}
+
+		chunks, ok := parseEnvelope(tapscript)
+		if !ok {
+			continue
+		}
+
+		found = true
+		for _, chunk := range chunks {
+			data = append(data, chunk...)
+		}
+	}
+
+	if !found {
+		return nil, errors.New("no taproot envelope found")
+	}
+
+	return data, nil
+}
+
+// Author extracts the public key checked by the tapscripts of the inputs
+func (e *TaprootEncoder) Author(tx *wire.MsgTx) (*btcec.PublicKey, error) {
+	var pubKeys [][]byte
+
+	for _, input := range tx.TxIn {
+		tapscript, ok := revealedTapscript(input.Witness)
+		if !ok {
+			continue
+		}
+
+		if _, ok := parseEnvelope(tapscript); !ok {
+			continue
+		}
+
+		// Tapscript starts with <pubkey> OP_CHECKSIG
+		tokenizer := txscript.MakeScriptTokenizer(0, tapscript)
+		if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_DATA_32 {
+			continue
+		}
+		pubKey := tokenizer.Data()
+
+		if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_CHECKSIG {
+			continue
+		}
+
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	return singleAuthor(pubKeys, schnorr.ParsePubKey)
+}
+
+// revealedTapscript returns the tapscript revealed by a script path spend
+func revealedTapscript(witness wire.TxWitness) ([]byte, bool) {
+	// Skip the annex if present
+	if len(witness) >= 2 && len(witness[len(witness)-1]) > 0 && witness[len(witness)-1][0] == txscript.TaprootAnnexTag {
+		witness = witness[:len(witness)-1]
+	}
+
+	// Script path spends end with the tapscript and its control block
+	if len(witness) < 2 {
+		return nil, false
+	}
+
+	if _, err := txscript.ParseControlBlock(witness[len(witness)-1]); err != nil {
+		return nil, false
+	}
+
+	return witness[len(witness)-2], true
+}
+
+// buildTapscript creates a tapscript leaf holding the data in an envelope that is never executed
+// <pubkey> OP_CHECKSIG OP_FALSE OP_IF <chunk 1> <chunk 2> ... OP_ENDIF
+func buildTapscript(pubKey *btcec.PublicKey, chunks [][]byte) ([]byte, error) {
+	// Verify tx signature such that the transaction output cannot be redirected to another address
+	header := txscript.NewScriptBuilder()
+	header.AddData(schnorr.SerializePubKey(pubKey))
+	header.AddOp(txscript.OP_CHECKSIG)
+
+	// Open the envelope
+	header.AddOp(txscript.OP_FALSE)
+	header.AddOp(txscript.OP_IF)
+
+	script, err := header.Script()
+	if err != nil {
+		return nil, err
+	}
+
+	// Tapscripts are not limited to 10,000 bytes but the script builder is
+	// Push each chunk separately to get canonical pushes
+	for _, chunk := range chunks {
+		push, err := txscript.NewScriptBuilder().AddData(chunk).Script()
+		if err != nil {
+			return nil, err
+		}
+		script = append(script, push...)
+	}
+
+	// Close the envelope
+	return append(script, txscript.OP_ENDIF), nil
+}
+
+// buildTaprootTree commits to a single tapscript leaf using the injection key as internal key
+// The funds can also be redeemed with the key path if needed
+func buildTaprootTree(pubKey *btcec.PublicKey, chunks [][]byte) (*txscript.IndexedTapScriptTree, error) {
+	tapscript, err := buildTapscript(pubKey, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	return txscript.AssembleTaprootScriptTree(txscript.NewBaseTapLeaf(tapscript)), nil
+}
+
+// buildTaprootAddress creates the bech32m address committing to the tapscript holding the chunks
+func buildTaprootAddress(pubKey *btcec.PublicKey, chunks [][]byte, network *chaincfg.Params) (*btcutil.AddressTaproot, error) {
+	tree, err := buildTaprootTree(pubKey, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	rootHash := tree.RootNode.TapHash()
+	outputKey := txscript.ComputeTaprootOutputKey(pubKey, rootHash[:])
+
+	return btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), network)
+}
+
+func buildTaprootWitness(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, chunks [][]byte, signer Signer, inputIndex int, prevOut *wire.TxOut, dummy bool) (wire.TxWitness, error) {
+	tree, err := buildTaprootTree(signer.PubKey(), chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := tree.LeafMerkleProofs[0]
+
+	controlBlock := leaf.ToControlBlock(signer.PubKey())
+	controlBlockBytes, err := controlBlock.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+	if dummy {
+		// Empty signature of the exact size
+		sig = make([]byte, consensus.SchnorrSignatureSize)
+	} else {
+		// Sign transaction pre-image
+		sig, err = signer.SignTapscript(tx, sigHashes, inputIndex, prevOut, leaf.TapLeaf, controlBlockBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Witness is: [SIG] [TAPSCRIPT] [CONTROL BLOCK]
+	return wire.TxWitness{sig, leaf.Script, controlBlockBytes}, nil
+}
+
+// taprootKeyPathEncoder spends taproot outputs with the key path when refunding them, their tapscript is not revealed
+type taprootKeyPathEncoder struct {
+	TaprootEncoder
+}
+
+// Spend signs with the injection key tweaked by the Merkle root of the tapscript holding the chunks
+func (e *taprootKeyPathEncoder) Spend(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, chunks [][]byte, signer Signer, dummy bool) (wire.TxWitness, []byte, error) {
+	if dummy {
+		// Empty signature of the exact size
+		return wire.TxWitness{make([]byte, consensus.SchnorrSignatureSize)}, nil, nil
+	}
+
+	tree, err := buildTaprootTree(signer.PubKey(), chunks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rootHash := tree.RootNode.TapHash()
+	sig, err := signer.SignTaprootKeyPath(tx, sigHashes, inputIndex, prevOut, rootHash[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Witness is: [SIG]
+	return wire.TxWitness{sig}, nil, nil
+}
+
+// parseEnvelope extracts the chunks pushed in the OP_FALSE OP_IF ... OP_ENDIF envelope of a tapscript
+func parseEnvelope(script []byte) ([][]byte, bool) {
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+
+	// Look for the start of the envelope
+	var prevOp byte = txscript.OP_NOP
+	for tokenizer.Next() {
+		if prevOp == txscript.OP_FALSE && tokenizer.Opcode() == txscript.OP_IF {
+			break
+		}
+		prevOp = tokenizer.Opcode()
+	}
+
+	if tokenizer.Done() {
+		return nil, false
+	}
+
+	var chunks [][]byte
+	for tokenizer.Next() {
+		op := tokenizer.Opcode()
+
+		if op == txscript.OP_ENDIF {
+			return chunks, true
+		}
+
+		chunk, ok := pushedData(op, tokenizer.Data())
+		if !ok {
+			return nil, false
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return nil, false
+}
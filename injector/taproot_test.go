@@ -0,0 +1,150 @@
+package injector
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestTaprootRoundTrip(t *testing.T) {
+	signer := testSigner(t)
+	encoder := &TaprootEncoder{}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		chunks int
+	}{
+		{"single chunk", []byte("a small file"), 1},
+		{"full chunk", bytes.Repeat([]byte{1}, 520), 1},
+		{"several chunks", bytes.Repeat([]byte("taproot envelope "), 200), 7},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inputs := encoder.Chunks(test.data)
+			if len(inputs) != 1 || len(inputs[0]) != test.chunks {
+				t.Fatalf("%d inputs, want a single input of %d chunks", len(inputs), test.chunks)
+			}
+
+			tx, pkScripts := testSpend(t, encoder, signer, inputs)
+
+			if txscript.GetScriptClass(pkScripts[0]) != txscript.WitnessV1TaprootTy {
+				t.Fatal("address is not a taproot address")
+			}
+
+			if err := executeInputs(t, tx, pkScripts); err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := encoder.Decode(tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(decoded, test.data) {
+				t.Fatal("decoded data does not match")
+			}
+
+			author, err := encoder.Author(tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(schnorr.SerializePubKey(author), schnorr.SerializePubKey(signer.PubKey())) {
+				t.Fatal("author does not match the signer")
+			}
+
+			// Witness script inputs are not taproot envelopes
+			other, _ := testSpend(t, &WitnessScriptEncoder{}, signer, [][][]byte{{[]byte("chunk")}})
+			if _, err := encoder.Decode(other); err == nil {
+				t.Fatal("expected no taproot envelope")
+			}
+		})
+	}
+}
+
+func TestTaprootAnnex(t *testing.T) {
+	signer := testSigner(t)
+	encoder := &TaprootEncoder{}
+	data := []byte("the annex is skipped")
+
+	tx, _ := testSpend(t, encoder, signer, encoder.Chunks(data))
+	tx.TxIn[0].Witness = append(tx.TxIn[0].Witness, []byte{txscript.TaprootAnnexTag, 1})
+
+	decoded, err := encoder.Decode(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decoded, data) {
+		t.Fatal("decoded data does not match")
+	}
+}
+
+func TestParseEnvelope(t *testing.T) {
+	script := func(ops ...interface{}) []byte {
+		builder := txscript.NewScriptBuilder()
+		for _, op := range ops {
+			switch op := op.(type) {
+			case int:
+				builder.AddOp(byte(op))
+			case string:
+				builder.AddData([]byte(op))
+			}
+		}
+
+		s, err := builder.Script()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name   string
+		script []byte
+		chunks [][]byte
+		ok     bool
+	}{
+		{"envelope", script(txscript.OP_FALSE, txscript.OP_IF, "first", "second", txscript.OP_ENDIF), [][]byte{[]byte("first"), []byte("second")}, true},
+		{"after checksig", script("key", txscript.OP_CHECKSIG, txscript.OP_FALSE, txscript.OP_IF, "data", txscript.OP_ENDIF), [][]byte{[]byte("data")}, true},
+		{"empty envelope", script(txscript.OP_FALSE, txscript.OP_IF, txscript.OP_ENDIF), nil, true},
+		{"no envelope", script("key", txscript.OP_CHECKSIG), nil, false},
+		{"executed branch", script(txscript.OP_TRUE, txscript.OP_IF, "data", txscript.OP_ENDIF), nil, false},
+		{"unterminated", script(txscript.OP_FALSE, txscript.OP_IF, "data"), nil, false},
+		{"opcode inside", script(txscript.OP_FALSE, txscript.OP_IF, "data", txscript.OP_DROP, txscript.OP_ENDIF), nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunks, ok := parseEnvelope(test.script)
+			if ok != test.ok || !reflect.DeepEqual(chunks, test.chunks) {
+				t.Fatalf("chunks = %q, %v", chunks, ok)
+			}
+		})
+	}
+}
+
+func TestTaprootDummyWitness(t *testing.T) {
+	// Dummy witnesses are used to estimate fees, they must be as large as signed ones
+	signer := testSigner(t)
+	encoder := &TaprootEncoder{}
+	chunks := encoder.Chunks(bytes.Repeat([]byte{1}, 1500))[0]
+
+	tx, _ := testSpend(t, encoder, signer, [][][]byte{chunks})
+	prevOut := wire.NewTxOut(1000, nil)
+
+	dummy, _, err := encoder.Spend(tx, nil, 0, prevOut, chunks, signer, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dummy.SerializeSize() != tx.TxIn[0].Witness.SerializeSize() {
+		t.Fatalf("dummy witness of %d bytes, signed witness of %d bytes", dummy.SerializeSize(), tx.TxIn[0].Witness.SerializeSize())
+	}
+}
@@ -0,0 +1,360 @@
+package injector
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// WitnessScriptEncoder stores chunks in the witness stack, each verified against its hash by the witness script
+// Nested encoders wrap the witness program in a P2SH redeem script (3... addresses)
+// Otherwise native segwit addresses are used (bc1q...) and inputs do not need a signature script
+// The profile sets the size of chunks and the number of chunks per input
+// Encoders with a backup add a timelocked branch to the witness scripts, spendable by the backup key
+type WitnessScriptEncoder struct {
+	Nested  bool
+	Profile consensus.Profile
+	Backup  *Backup
+}
+
+// Name returns the name of the encoder
+func (e *WitnessScriptEncoder) Name() string {
+	if e.Nested {
+		return "p2sh-p2wsh"
+	}
+	return "p2wsh"
+}
+
+// MaxPartSize returns the maximum size of data that can be stored in a single standard transaction
+func (e *WitnessScriptEncoder) MaxPartSize() int {
+	return e.Profile.MaxInjectionSize
+}
+
+// Chunks splits data into as many inputs as needed
+// With the standard profile, each input holds at most 99 chunks of 80 bytes, the maximum that can be pushed on the witness stack by standard transactions
+func (e *WitnessScriptEncoder) Chunks(data []byte) [][][]byte {
+	var inputs [][][]byte
+
+	// The signature takes one stack item
+	items := e.Profile.StackItems - 1
+
+	// The branch selector takes another one, and the opcodes of the backup branch leave room for fewer chunks
+	if e.Backup != nil {
+		items--
+		if maxItems := (consensus.MaxOpsPerScript - consensus.BackupBranchOps - 1) / 2; items > maxItems {
+			items = maxItems
+		}
+	}
+
+	for _, p := range dataToChunks(data, items*e.Profile.PushDataLimit) {
+		inputs = append(inputs, dataToChunks(p, e.Profile.PushDataLimit))
+	}
+
+	return inputs
+}
+
+// WithProfile returns a copy of the encoder using different limits
+func (e *WitnessScriptEncoder) WithProfile(profile consensus.Profile) Encoder {
+	return &WitnessScriptEncoder{
+		Nested:  e.Nested,
+		Profile: profile,
+		Backup:  e.Backup,
+	}
+}
+
+// WithBackup returns a copy of the encoder adding a backup branch to the witness scripts
+func (e *WitnessScriptEncoder) WithBackup(backup *Backup) Encoder {
+	return &WitnessScriptEncoder{
+		Nested:  e.Nested,
+		Profile: e.Profile,
+		Backup:  backup,
+	}
+}
+
+// Address derives the script hash address from the witness script
+func (e *WitnessScriptEncoder) Address(pubKey *btcec.PublicKey, chunks [][]byte, network *chaincfg.Params) (btcutil.Address, error) {
+	// Build witness script
+	witnessScript, err := buildWitnessScript(pubKey, chunks, e.Backup)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.Nested {
+		// Native segwit address is derived from the witness program
+		return btcutil.NewAddressWitnessScriptHash(buildWitnessProg(witnessScript), network)
+	}
+
+	// Build redeem script (OP_0 0x20 [witness prog])
+	redeemScript, err := buildRedeemScript(buildWitnessProg(witnessScript))
+	if err != nil {
+		return nil, err
+	}
+
+	// Hash redeemscript to build the scriptHash address
+	return btcutil.NewAddressScriptHash(redeemScript, network)
+}
+
+// Outputs returns no outputs as data is stored in the inputs
+func (e *WitnessScriptEncoder) Outputs(chunks [][]byte) ([]*wire.TxOut, error) {
+	return nil, nil
+}
+
+// Spend builds the witness revealing the chunks and the signature script pushing the redeem script if nested
+func (e *WitnessScriptEncoder) Spend(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, inputIndex int, prevOut *wire.TxOut, chunks [][]byte, signer Signer, dummy bool) (wire.TxWitness, []byte, error) {
+	witness, err := buildWitness(tx, sigHashes, chunks, signer, e.Backup, inputIndex, prevOut, dummy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !e.Nested {
+		return witness, nil, nil
+	}
+
+	sigScript, err := buildSigScript(witness[len(witness)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return witness, sigScript, nil
+}
+
+// Decode concatenates the chunks revealed by the witnesses of the inputs
+// Inputs that do not spend a witness script built by buildWitnessScript are skipped
+// Chunks are checked against the hashes committed in the witness script
+func (e *WitnessScriptEncoder) Decode(tx *wire.MsgTx) ([]byte, error) {
+	var data []byte
+	var found bool
+
+	for inputIndex, input := range tx.TxIn {
+		// Witness is: [SIG] [CHUNK 1] ... [CHUNK N] [OP_TRUE if backup] [WITNESS SCRIPT]
+		if len(input.Witness) < 3 {
+			continue
+		}
+
+		witnessScript := input.Witness[len(input.Witness)-1]
+		hashes, _, backup, ok := parseWitnessScript(witnessScript)
+		if !ok {
+			continue
+		}
+
+		// Skip signature and witness script
+		chunks := input.Witness[1 : len(input.Witness)-1]
+		if backup {
+			// Inputs reclaimed by the backup key reveal no chunks
+			if !isMainBranch(input.Witness) {
+				continue
+			}
+			chunks = chunks[:len(chunks)-1]
+		}
+
+		// Nested inputs push the redeem script, native inputs have an empty signature script
+		if e.Nested != (len(input.SignatureScript) > 0) {
+			continue
+		}
+
+		if e.Nested {
+			sigScript, err := buildSigScript(witnessScript)
+			if err != nil || !bytes.Equal(sigScript, input.SignatureScript) {
+				return nil, &IntegrityError{Input: inputIndex, Reason: "signature script does not match witness script"}
+			}
+		}
+
+		if len(chunks) != len(hashes) {
+			return nil, &IntegrityError{Input: inputIndex, Reason: fmt.Sprintf("expected %d chunks, found %d", len(hashes), len(chunks))}
+		}
+
+		for i, chunk := range chunks {
+			if !bytes.Equal(btcutil.Hash160(chunk), hashes[i]) {
+				return nil, &IntegrityError{Input: inputIndex, Reason: fmt.Sprintf("chunk %d does not match its hash", i)}
+			}
+		}
+
+		found = true
+		for _, chunk := range chunks {
+			data = append(data, chunk...)
+		}
+	}
+
+	if !found {
+		return nil, errors.New("no witness script found")
+	}
+
+	return data, nil
+}
+
+// Author extracts the public key committed in the witness scripts of the inputs
+func (e *WitnessScriptEncoder) Author(tx *wire.MsgTx) (*btcec.PublicKey, error) {
+	var pubKeys [][]byte
+
+	for _, input := range tx.TxIn {
+		if len(input.Witness) < 3 || e.Nested != (len(input.SignatureScript) > 0) {
+			continue
+		}
+
+		_, pubKey, backup, ok := parseWitnessScript(input.Witness[len(input.Witness)-1])
+		if !ok || (backup && !isMainBranch(input.Witness)) {
+			continue
+		}
+
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	return singleAuthor(pubKeys, btcec.ParsePubKey)
+}
+
+// parseWitnessScript checks that a script strictly follows one of the templates of buildWitnessScript
+// It returns the hashes of the chunks in stack order, the public key and whether the script has a backup branch
+// OP_HASH160 <hash N> OP_EQUALVERIFY ... OP_HASH160 <hash 1> OP_EQUALVERIFY <pubkey> OP_CHECKSIG
+// OP_IF <template above> OP_ELSE <delay> OP_CHECKSEQUENCEVERIFY OP_DROP <backup pubkey> OP_CHECKSIG OP_ENDIF
+func parseWitnessScript(script []byte) ([][]byte, []byte, bool, bool) {
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+
+	backup := tokenizer.Next() && tokenizer.Opcode() == txscript.OP_IF
+	if !backup {
+		tokenizer = txscript.MakeScriptTokenizer(0, script)
+	}
+
+	var hashes [][]byte
+	for tokenizer.Next() {
+		if tokenizer.Opcode() != txscript.OP_HASH160 {
+			break
+		}
+
+		if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_DATA_20 {
+			return nil, nil, false, false
+		}
+		hash := tokenizer.Data()
+
+		if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_EQUALVERIFY {
+			return nil, nil, false, false
+		}
+
+		// Chunks are hashed in reverse order
+		hashes = append([][]byte{hash}, hashes...)
+	}
+
+	if tokenizer.Err() != nil || len(hashes) == 0 || tokenizer.Opcode() != txscript.OP_DATA_33 {
+		return nil, nil, false, false
+	}
+	pubKey := tokenizer.Data()
+
+	if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_CHECKSIG {
+		return nil, nil, false, false
+	}
+
+	if backup && !parseBackupBranch(&tokenizer) {
+		return nil, nil, false, false
+	}
+
+	if tokenizer.Next() || tokenizer.Err() != nil {
+		return nil, nil, false, false
+	}
+
+	return hashes, pubKey, backup, true
+}
+
+func buildWitness(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, chunks [][]byte, signer Signer, backup *Backup, inputIndex int, prevOut *wire.TxOut, dummy bool) (wire.TxWitness, error) {
+	// The script signature must contain the original redeem script (not hashed)
+	witnessScript, err := buildWitnessScript(signer.PubKey(), chunks, backup)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+	if dummy {
+		// Empty signature of max possible size
+		sig = make([]byte, consensus.ECDSAMaxSignatureSize)
+	} else {
+		// Sign transaction pre-image
+		sig, err = signer.SignWitness(tx, sigHashes, inputIndex, prevOut, witnessScript)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Create input script
+	var witness wire.TxWitness
+
+	// Push raw signature
+	witness = append(witness, sig)
+
+	// Push each chunk of data
+	for _, chunk := range chunks {
+		witness = append(witness, chunk)
+	}
+
+	// Select the branch revealing the chunks
+	if backup != nil {
+		witness = append(witness, []byte{1})
+	}
+
+	// Push witness script on the top of the stack
+	witness = append(witness, witnessScript)
+
+	// Return serialized P2SH-P2WSH input script
+	return witness, nil
+}
+
+// buildSigScript creates the signature script of nested inputs, pushing the redeem script only
+func buildSigScript(witnessScript []byte) ([]byte, error) {
+	redeemScript, err := buildRedeemScript(buildWitnessProg(witnessScript))
+	if err != nil {
+		return nil, err
+	}
+
+	return txscript.NewScriptBuilder().AddData(redeemScript).Script()
+}
+
+func buildWitnessScript(pubKey *btcec.PublicKey, chunks [][]byte, backup *Backup) ([]byte, error) {
+	witnessScript := txscript.NewScriptBuilder()
+
+	// The chunks are revealed in the first branch, the backup key may spend the second one
+	if backup != nil {
+		witnessScript.AddOp(txscript.OP_IF)
+	}
+
+	// Reverse traversal of chunks such that the stack is popped in the correct order
+	for i := len(chunks) - 1; i >= 0; i-- {
+		// Hash each chunk of data such that chunks cannot be ordered differently by tx relay nodes or miners
+		// This ensures integrity of the data
+		witnessScript.AddOp(txscript.OP_HASH160)
+		witnessScript.AddData(btcutil.Hash160(chunks[i]))
+		witnessScript.AddOp(txscript.OP_EQUALVERIFY)
+	}
+
+	// Verify tx signature such that the transaction output cannot be redirected to another address
+	// This may not be useful if vout value is equal or close to a dust amount as removing the signature verification would save at most 107 bytes (73 sig + 33 pub + 1 opcode)
+	witnessScript.AddData(pubKey.SerializeCompressed())
+	witnessScript.AddOp(txscript.OP_CHECKSIG)
+
+	if backup != nil {
+		addBackupBranch(witnessScript, backup)
+	}
+
+	// Return serialized P2SH-P2WSH witness script
+	return witnessScript.Script()
+}
+
+func buildWitnessProg(witnessScript []byte) []byte {
+	// witness program is simply sha256(witnessScript)
+	h := sha256.Sum256(witnessScript)
+	return h[:]
+}
+
+func buildRedeemScript(witnessProg []byte) ([]byte, error) {
+	redeemScript := txscript.NewScriptBuilder()
+
+	// redeem script is simply OP_0 0x20 [witness prog]
+	redeemScript.AddOp(txscript.OP_0)
+	redeemScript.AddData(witnessProg)
+
+	return redeemScript.Script()
+}
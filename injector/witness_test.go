@@ -0,0 +1,402 @@
+package injector
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/aureleoules/bitcandle/consensus"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func testSigner(t *testing.T) *KeySigner {
+	t.Helper()
+
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewKeySigner(key)
+}
+
+// executeInputs runs the script engine on every input of a transaction spending outputs of 1000 sats
+func executeInputs(t *testing.T, tx *wire.MsgTx, pkScripts [][]byte) error {
+	t.Helper()
+
+	prevOuts := txscript.NewMultiPrevOutFetcher(nil)
+	for k, txIn := range tx.TxIn {
+		prevOuts.AddPrevOut(txIn.PreviousOutPoint, wire.NewTxOut(1000, pkScripts[k]))
+	}
+	sigHashes := txscript.NewTxSigHashes(tx, prevOuts)
+
+	for k := range tx.TxIn {
+		vm, err := txscript.NewEngine(pkScripts[k], tx, k, txscript.StandardVerifyFlags, nil, sigHashes, 1000, prevOuts)
+		if err != nil {
+			return err
+		}
+
+		if err := vm.Execute(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// testSpend builds and signs a transaction spending one address of the encoder per group of chunks
+func testSpend(t *testing.T, encoder Encoder, signer Signer, inputs [][][]byte) (*wire.MsgTx, [][]byte) {
+	t.Helper()
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	for _, chunks := range inputs {
+		outputs, err := encoder.Outputs(chunks)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, output := range outputs {
+			tx.AddTxOut(output)
+		}
+	}
+	tx.AddTxOut(wire.NewTxOut(500, []byte{txscript.OP_TRUE}))
+
+	prevOuts := txscript.NewMultiPrevOutFetcher(nil)
+	var pkScripts [][]byte
+	for k, chunks := range inputs {
+		address, err := encoder.Address(signer.PubKey(), chunks, &chaincfg.RegressionNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pkScript, err := txscript.PayToAddrScript(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkScripts = append(pkScripts, pkScript)
+
+		outPoint := wire.OutPoint{Hash: testTxIDs(len(inputs))[k]}
+		tx.AddTxIn(wire.NewTxIn(&outPoint, nil, nil))
+		prevOuts.AddPrevOut(outPoint, wire.NewTxOut(1000, pkScript))
+	}
+
+	sigHashes := txscript.NewTxSigHashes(tx, prevOuts)
+	for k, chunks := range inputs {
+		witness, sigScript, err := encoder.Spend(tx, sigHashes, k, prevOuts.FetchPrevOutput(tx.TxIn[k].PreviousOutPoint), chunks, signer, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.TxIn[k].Witness = witness
+		tx.TxIn[k].SignatureScript = sigScript
+	}
+
+	return tx, pkScripts
+}
+
+func TestWitnessScriptRoundTrip(t *testing.T) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	signer := testSigner(t)
+	backup := &Backup{PubKey: testSigner(t).PubKey(), Delay: 144}
+
+	tests := []struct {
+		name    string
+		encoder *WitnessScriptEncoder
+	}{
+		{"p2wsh", &WitnessScriptEncoder{Profile: consensus.Standard}},
+		{"p2sh-p2wsh", &WitnessScriptEncoder{Nested: true, Profile: consensus.Standard}},
+		{"p2wsh with backup", &WitnessScriptEncoder{Profile: consensus.Standard, Backup: backup}},
+		{"p2sh-p2wsh with backup", &WitnessScriptEncoder{Nested: true, Profile: consensus.Standard, Backup: backup}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inputs := test.encoder.Chunks(data)
+			tx, pkScripts := testSpend(t, test.encoder, signer, inputs)
+
+			if err := executeInputs(t, tx, pkScripts); err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := test.encoder.Decode(tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(decoded, data) {
+				t.Fatal("decoded data does not match")
+			}
+
+			author, err := test.encoder.Author(tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !author.IsEqual(signer.PubKey()) {
+				t.Fatal("author does not match the signer")
+			}
+
+			// Inputs of the other kind of addresses are skipped
+			other := &WitnessScriptEncoder{Nested: !test.encoder.Nested, Profile: consensus.Standard}
+			if _, err := other.Decode(tx); err == nil {
+				t.Fatal("expected no witness script of the other kind")
+			}
+		})
+	}
+}
+
+func TestWitnessScriptChunks(t *testing.T) {
+	data := make([]byte, 20000)
+
+	encoder := &WitnessScriptEncoder{Profile: consensus.Standard}
+	for _, chunks := range encoder.Chunks(data) {
+		if len(chunks) > consensus.Standard.StackItems-1 {
+			t.Fatalf("%d chunks in an input", len(chunks))
+		}
+
+		for _, chunk := range chunks {
+			if len(chunk) > consensus.Standard.PushDataLimit {
+				t.Fatalf("chunk of %d bytes", len(chunk))
+			}
+		}
+	}
+
+	// The branch selector and the opcodes of the backup branch leave room for fewer chunks
+	encoder.Backup = &Backup{PubKey: testSigner(t).PubKey(), Delay: 144}
+	for _, chunks := range encoder.Chunks(data) {
+		script, err := buildWitnessScript(encoder.Backup.PubKey, chunks, encoder.Backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ops := 0
+		tokenizer := txscript.MakeScriptTokenizer(0, script)
+		for tokenizer.Next() {
+			if tokenizer.Opcode() > txscript.OP_16 {
+				ops++
+			}
+		}
+
+		if ops > consensus.MaxOpsPerScript {
+			t.Fatalf("%d opcodes in a witness script", ops)
+		}
+	}
+}
+
+func TestWitnessScriptDecodeTampered(t *testing.T) {
+	signer := testSigner(t)
+	encoder := &WitnessScriptEncoder{Profile: consensus.Standard}
+	inputs := encoder.Chunks(bytes.Repeat([]byte("chunks are checked against their hashes "), 5))
+
+	tests := []struct {
+		name   string
+		tamper func(tx *wire.MsgTx)
+	}{
+		{"tampered chunk", func(tx *wire.MsgTx) { tx.TxIn[0].Witness[1][0] ^= 1 }},
+		{"missing chunk", func(tx *wire.MsgTx) {
+			w := tx.TxIn[0].Witness
+			tx.TxIn[0].Witness = append(wire.TxWitness{w[0]}, w[2:]...)
+		}},
+		{"swapped chunks", func(tx *wire.MsgTx) {
+			w := tx.TxIn[0].Witness
+			w[1], w[2] = w[2], w[1]
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, _ := testSpend(t, encoder, signer, inputs)
+			test.tamper(tx)
+
+			_, err := encoder.Decode(tx)
+			var integrityErr *IntegrityError
+			if !errors.As(err, &integrityErr) {
+				t.Fatalf("err = %v, want an integrity error", err)
+			}
+		})
+	}
+}
+
+func TestWitnessScriptTamperedOutput(t *testing.T) {
+	encoder := &WitnessScriptEncoder{Profile: consensus.Standard}
+	inputs := encoder.Chunks([]byte("the signature commits to the outputs"))
+
+	tx, pkScripts := testSpend(t, encoder, testSigner(t), inputs)
+	tx.TxOut[0].Value--
+
+	if executeInputs(t, tx, pkScripts) == nil {
+		t.Fatal("expected an invalid signature")
+	}
+}
+
+func TestParseWitnessScript(t *testing.T) {
+	pubKey := testSigner(t).PubKey()
+	backup := &Backup{PubKey: testSigner(t).PubKey(), Delay: 1000}
+	chunks := [][]byte{[]byte("first"), []byte("second")}
+
+	script, err := buildWitnessScript(pubKey, chunks, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backupScript, err := buildWitnessScript(pubKey, chunks, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range [][]byte{script, backupScript} {
+		hashes, parsedKey, hasBackup, ok := parseWitnessScript(s)
+		if !ok {
+			t.Fatal("witness script is not recognized")
+		}
+
+		if hasBackup != bytes.Equal(s, backupScript) || !bytes.Equal(parsedKey, pubKey.SerializeCompressed()) || len(hashes) != len(chunks) {
+			t.Fatal("parsed witness script does not match")
+		}
+	}
+
+	checksigOnly, _ := txscript.NewScriptBuilder().AddData(pubKey.SerializeCompressed()).AddOp(txscript.OP_CHECKSIG).Script()
+	multisig, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_1).AddData(pubKey.SerializeCompressed()).AddOp(txscript.OP_1).AddOp(txscript.OP_CHECKMULTISIG).Script()
+
+	invalid := map[string][]byte{
+		"no chunks":         checksigOnly,
+		"multisig":          multisig,
+		"trailing opcode":   append(append([]byte{}, script...), txscript.OP_TRUE),
+		"truncated":         script[:len(script)-1],
+		"missing branch":    backupScript[:len(backupScript)-1],
+		"trailing branch":   append(append([]byte{}, backupScript...), txscript.OP_DROP),
+		"empty":             nil,
+		"hash without push": {txscript.OP_HASH160, txscript.OP_EQUALVERIFY},
+	}
+
+	for name, s := range invalid {
+		if _, _, _, ok := parseWitnessScript(s); ok {
+			t.Fatalf("%s: witness script should not be recognized", name)
+		}
+	}
+}
+
+func TestWitnessScriptAddress(t *testing.T) {
+	signer := testSigner(t)
+	data := make([]byte, 5000)
+
+	tests := []struct {
+		encoder   *WitnessScriptEncoder
+		class     txscript.ScriptClass
+		sigScript bool
+	}{
+		{&WitnessScriptEncoder{Profile: consensus.Standard}, txscript.WitnessV0ScriptHashTy, false},
+		{&WitnessScriptEncoder{Nested: true, Profile: consensus.Standard}, txscript.ScriptHashTy, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.encoder.Name(), func(t *testing.T) {
+			tx, pkScripts := testSpend(t, test.encoder, signer, test.encoder.Chunks(data))
+
+			for k, txIn := range tx.TxIn {
+				if class := txscript.GetScriptClass(pkScripts[k]); class != test.class {
+					t.Fatalf("address of class %s, want %s", class, test.class)
+				}
+
+				if (len(txIn.SignatureScript) > 0) != test.sigScript {
+					t.Fatalf("signature script of %d bytes", len(txIn.SignatureScript))
+				}
+			}
+		})
+	}
+
+	// Native addresses save the 35 bytes signature script of every input
+	native, err := NewInjection(data, tests[0].encoder, 1, signer, &chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nested, err := NewInjection(data, tests[1].encoder, 1, signer, &chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nativeCost, _, err := native.EstimateCost()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nestedCost, _, err := nested.EstimateCost()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved := nestedCost - nativeCost; saved != int64(35*native.NumInputs()) {
+		t.Fatalf("p2wsh saves %d sats over p2sh-p2wsh for %d inputs", saved, native.NumInputs())
+	}
+}
+
+func TestWitnessScriptNonStandard(t *testing.T) {
+	data := make([]byte, 150000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	signer := testSigner(t)
+	backup := &Backup{PubKey: testSigner(t).PubKey(), Delay: 144}
+
+	tests := []struct {
+		name   string
+		nested bool
+		backup *Backup
+		items  int
+	}{
+		{"p2wsh", false, nil, consensus.NonStandardStackItems - 1},
+		{"p2sh-p2wsh", true, nil, consensus.NonStandardStackItems - 1},
+		{"p2wsh with backup", false, backup, (consensus.MaxOpsPerScript - consensus.BackupBranchOps - 1) / 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			standard := &WitnessScriptEncoder{Nested: test.nested, Profile: consensus.Standard, Backup: test.backup}
+			encoder := standard.WithProfile(consensus.NonStandard)
+
+			if encoder.MaxPartSize() != consensus.NonStandardMaxInjectionSize {
+				t.Fatalf("parts of %d bytes", encoder.MaxPartSize())
+			}
+
+			inputs := encoder.Chunks(data)
+			if len(inputs[0]) != test.items {
+				t.Fatalf("%d chunks in a full input, want %d", len(inputs[0]), test.items)
+			}
+
+			for _, chunks := range inputs {
+				for _, chunk := range chunks[:len(chunks)-1] {
+					if len(chunk) != consensus.NonStandardPushDataLimit {
+						t.Fatalf("chunk of %d bytes", len(chunk))
+					}
+				}
+			}
+
+			// Consensus rules accept chunks of 520 bytes
+			tx, pkScripts := testSpend(t, encoder, signer, inputs)
+			if err := executeInputs(t, tx, pkScripts); err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := encoder.Decode(tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(decoded, data) {
+				t.Fatal("decoded data does not match")
+			}
+
+			// Retrieval does not depend on the profile
+			if _, err := standard.Decode(tx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package keystore
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Scrypt parameters used to derive keys from passphrases
+// They are stored with the ciphertext so that they can be raised later
+const (
+	scryptN  = 1 << 16
+	scryptR  = 8
+	scryptP  = 1
+	saltSize = 16
+)
+
+const (
+	kdfScrypt               = "scrypt"
+	cipherXChaCha20Poly1305 = "xchacha20-poly1305"
+)
+
+// ErrWrongPassphrase is returned when a secret cannot be authenticated
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted keystore")
+
+// Crypto holds a secret encrypted with XChaCha20-Poly1305 under a key derived from a passphrase with scrypt
+type Crypto struct {
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Cipher     string `json:"cipher"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Encrypt encrypts a secret under a passphrase
+func Encrypt(secret []byte, passphrase string) (*Crypto, error) {
+	c := Crypto{
+		KDF:    kdfScrypt,
+		N:      scryptN,
+		R:      scryptR,
+		P:      scryptP,
+		Salt:   make([]byte, saltSize),
+		Cipher: cipherXChaCha20Poly1305,
+		Nonce:  make([]byte, chacha20poly1305.NonceSizeX),
+	}
+
+	_, err := rand.Read(c.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = rand.Read(c.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := c.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Ciphertext = aead.Seal(nil, c.Nonce, secret, nil)
+	return &c, nil
+}
+
+// Decrypt decrypts a secret encrypted by Encrypt
+func (c *Crypto) Decrypt(passphrase string) ([]byte, error) {
+	if c.KDF != kdfScrypt || c.Cipher != cipherXChaCha20Poly1305 {
+		return nil, errors.New("unsupported keystore encryption")
+	}
+
+	// Refuse parameters that would exhaust resources, scrypt uses 128 * N * r bytes
+	if c.N < 2 || c.N&(c.N-1) != 0 || c.N > 1<<20 || c.R < 1 || c.R > 32 || c.P < 1 || c.P > 16 {
+		return nil, errors.New("invalid key derivation parameters")
+	}
+
+	if len(c.Nonce) != chacha20poly1305.NonceSizeX {
+		return nil, errors.New("invalid nonce")
+	}
+
+	aead, err := c.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := aead.Open(nil, c.Nonce, c.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return secret, nil
+}
+
+func (c *Crypto) aead(passphrase string) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), c.Salt, c.N, c.R, c.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return chacha20poly1305.NewX(key)
+}
@@ -0,0 +1,83 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	secret := []byte("injection key")
+
+	c, err := Encrypt(secret, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries are stored as JSON
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Crypto
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := decoded.Decrypt("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, secret) {
+		t.Fatal("decrypted secret does not match")
+	}
+
+	if _, err := decoded.Decrypt("wrong passphrase"); err != ErrWrongPassphrase {
+		t.Fatalf("err = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestDecryptInvalid(t *testing.T) {
+	c, err := Encrypt([]byte("injection key"), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(c *Crypto)
+		err    error
+	}{
+		{"unknown kdf", func(c *Crypto) { c.KDF = "pbkdf2" }, nil},
+		{"unknown cipher", func(c *Crypto) { c.Cipher = "aes-256-gcm" }, nil},
+		{"n not a power of 2", func(c *Crypto) { c.N = 3 }, nil},
+		{"n too large", func(c *Crypto) { c.N = 1 << 21 }, nil},
+		{"no r", func(c *Crypto) { c.R = 0 }, nil},
+		{"p too large", func(c *Crypto) { c.P = 17 }, nil},
+		{"short nonce", func(c *Crypto) { c.Nonce = c.Nonce[:12] }, nil},
+		{"tampered salt", func(c *Crypto) { c.Salt[0] ^= 1 }, ErrWrongPassphrase},
+		{"tampered ciphertext", func(c *Crypto) { c.Ciphertext[0] ^= 1 }, ErrWrongPassphrase},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tampered := *c
+			tampered.Salt = append([]byte{}, c.Salt...)
+			tampered.Nonce = append([]byte{}, c.Nonce...)
+			tampered.Ciphertext = append([]byte{}, c.Ciphertext...)
+			test.tamper(&tampered)
+
+			_, err := tampered.Decrypt("passphrase")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if test.err != nil && err != test.err {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
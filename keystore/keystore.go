@@ -0,0 +1,413 @@
+package keystore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// seedFile is the name of the encrypted mnemonic in the keystore directory
+const seedFile = "seed.json"
+
+// payloadDir is the directory of the encrypted payloads in the keystore directory
+const payloadDir = "payloads"
+
+// Origin tells where the key of an entry comes from
+type Origin string
+
+const (
+	// OriginSeed keys are derived from the seed
+	OriginSeed Origin = "seed"
+	// OriginLegacy keys were generated randomly by older versions
+	OriginLegacy Origin = "legacy"
+	// OriginImported keys were imported by the user
+	OriginImported Origin = "imported"
+	// OriginMigrated keys are legacy keys whose funds were moved to the key derived from the seed
+	OriginMigrated Origin = "migrated"
+)
+
+// ErrNotFound is returned when no entry has the requested ID
+var ErrNotFound = errors.New("key not found")
+
+// Entry is an injection key encrypted under the passphrase of the keystore
+// Metadata are stored in clear so that keys can be listed without the passphrase
+type Entry struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Network   string    `json:"network"`
+	Origin    Origin    `json:"origin"`
+	PubKey    string    `json:"pubkey"`
+	Addresses []string  `json:"addresses"`
+	Payloads  []Payload `json:"payloads,omitempty"`
+	Created   time.Time `json:"created"`
+	Crypto    *Crypto   `json:"crypto"`
+}
+
+// Payload records data injected with the key of an entry and the options of its injection
+// The data is stored encrypted in the keystore, so that addresses can be rebuilt without the file
+type Payload struct {
+	SHA256       string `json:"sha256"`
+	Method       string `json:"method"`
+	NonStandard  bool   `json:"non_standard,omitempty"`
+	DataShards   int    `json:"data_shards,omitempty"`
+	ParityShards int    `json:"parity_shards,omitempty"`
+	BackupPubKey string `json:"backup_pubkey,omitempty"`
+	BackupDelay  uint16 `json:"backup_delay,omitempty"`
+
+	// Manifests are injected in a single transaction
+	Manifest bool `json:"manifest,omitempty"`
+}
+
+// NewEntry encrypts the key of a file, hash is the SHA-256 of its content
+func NewEntry(name string, hash [32]byte, network *chaincfg.Params, origin Origin, key *btcec.PrivateKey, passphrase string) (*Entry, error) {
+	crypto, err := Encrypt(key.Serialize(), passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	// Testnet and regtest keys are derived with the same coin type, the network keeps their IDs distinct
+	pubKey := key.PubKey().SerializeCompressed()
+	id := btcutil.Hash160(append([]byte(network.Name), pubKey...))[:8]
+	return &Entry{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Hash:      hex.EncodeToString(hash[:]),
+		Network:   network.Name,
+		Origin:    origin,
+		PubKey:    hex.EncodeToString(pubKey),
+		Addresses: []string{},
+		Created:   time.Now().UTC(),
+		Crypto:    crypto,
+	}, nil
+}
+
+// Key decrypts the key of an entry
+func (e *Entry) Key(passphrase string) (*btcec.PrivateKey, error) {
+	secret, err := e.Crypto.Decrypt(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	// The public key binds the ciphertext to the metadata of the entry
+	key, _ := btcec.PrivKeyFromBytes(secret)
+	if hex.EncodeToString(key.PubKey().SerializeCompressed()) != e.PubKey {
+		return nil, errors.New("key does not match its public key")
+	}
+
+	return key, nil
+}
+
+// Params returns the parameters of the network of the entry
+func (e *Entry) Params() (*chaincfg.Params, error) {
+	for _, params := range []*chaincfg.Params{&chaincfg.MainNetParams, &chaincfg.TestNet3Params, &chaincfg.RegressionNetParams} {
+		if params.Name == e.Network {
+			return params, nil
+		}
+	}
+
+	return nil, errors.New("unknown network: " + e.Network)
+}
+
+// AddAddress records an address controlled by the key, it returns false if it was already known
+func (e *Entry) AddAddress(address string) bool {
+	for _, a := range e.Addresses {
+		if a == address {
+			return false
+		}
+	}
+
+	e.Addresses = append(e.Addresses, address)
+	return true
+}
+
+// AddPayload records an injected payload, it returns false if it was already known
+func (e *Entry) AddPayload(payload Payload) bool {
+	for _, p := range e.Payloads {
+		if p == payload {
+			return false
+		}
+	}
+
+	e.Payloads = append(e.Payloads, payload)
+	return true
+}
+
+// Keystore stores encrypted injection keys and the seed in a directory readable by its owner only
+type Keystore struct {
+	dir string
+}
+
+// New opens the keystore of a directory, it is created on the first write
+func New(dir string) *Keystore {
+	return &Keystore{dir: dir}
+}
+
+func (k *Keystore) entryPath(id string) string {
+	return filepath.Join(k.dir, id+".json")
+}
+
+func (k *Keystore) payloadPath(hash string) string {
+	return filepath.Join(k.dir, payloadDir, hash+".json")
+}
+
+// write replaces a file atomically so that an interrupted write cannot lose a key
+func (k *Keystore) write(path string, v interface{}) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(k.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Add stores a new entry, existing entries are never overwritten
+func (k *Keystore) Add(e *Entry) error {
+	_, err := os.Stat(k.entryPath(e.ID))
+	if err == nil {
+		return errors.New("key " + e.ID + " already exists")
+	}
+
+	return k.write(k.entryPath(e.ID), e)
+}
+
+// Save updates the metadata of an entry
+func (k *Keystore) Save(e *Entry) error {
+	return k.write(k.entryPath(e.ID), e)
+}
+
+// Get loads an entry by ID
+func (k *Keystore) Get(id string) (*Entry, error) {
+	// IDs are hex, anything else could escape the keystore directory
+	_, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	b, err := ioutil.ReadFile(k.entryPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var e Entry
+	err = json.Unmarshal(b, &e)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// List loads all entries, oldest first
+func (k *Keystore) List() ([]*Entry, error) {
+	files, err := ioutil.ReadDir(k.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for _, file := range files {
+		if file.IsDir() || file.Name() == seedFile || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		e, err := k.Get(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Created.Before(entries[j].Created)
+	})
+
+	return entries, nil
+}
+
+// Find loads the entries of a file on a network
+func (k *Keystore) Find(hash [32]byte, network *chaincfg.Params) ([]*Entry, error) {
+	entries, err := k.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*Entry
+	for _, e := range entries {
+		if e.Hash == hex.EncodeToString(hash[:]) && e.Network == network.Name {
+			found = append(found, e)
+		}
+	}
+
+	return found, nil
+}
+
+// Remove deletes an entry
+func (k *Keystore) Remove(id string) error {
+	_, err := k.Get(id)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(k.entryPath(id))
+}
+
+// SavePayload encrypts injected data, payloads are identified by their SHA-256
+func (k *Keystore) SavePayload(data []byte, passphrase string) error {
+	hash := sha256.Sum256(data)
+	path := k.payloadPath(hex.EncodeToString(hash[:]))
+
+	// Payloads never change, they may be shared by the entries of several networks
+	_, err := os.Stat(path)
+	if err == nil {
+		return nil
+	}
+
+	crypto, err := Encrypt(data, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return k.write(path, crypto)
+}
+
+// Payload decrypts injected data by SHA-256
+func (k *Keystore) Payload(hash string, passphrase string) ([]byte, error) {
+	// Hashes are hex, anything else could escape the keystore directory
+	_, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	b, err := ioutil.ReadFile(k.payloadPath(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var crypto Crypto
+	err = json.Unmarshal(b, &crypto)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := crypto.Decrypt(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, errors.New("payload does not match its hash")
+	}
+
+	return data, nil
+}
+
+// HasSeed checks if the keystore holds a seed
+func (k *Keystore) HasSeed() bool {
+	_, err := os.Stat(filepath.Join(k.dir, seedFile))
+	return err == nil
+}
+
+// SaveSeed encrypts the mnemonic of the seed, an existing seed is never overwritten
+func (k *Keystore) SaveSeed(mnemonic string, passphrase string) error {
+	if k.HasSeed() {
+		return errors.New("seed already exists")
+	}
+
+	crypto, err := Encrypt([]byte(mnemonic), passphrase)
+	if err != nil {
+		return err
+	}
+
+	return k.write(filepath.Join(k.dir, seedFile), crypto)
+}
+
+// Seed decrypts the mnemonic of the seed
+func (k *Keystore) Seed(passphrase string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(k.dir, seedFile))
+	if err != nil {
+		return "", err
+	}
+
+	var crypto Crypto
+	err = json.Unmarshal(b, &crypto)
+	if err != nil {
+		return "", err
+	}
+
+	mnemonic, err := crypto.Decrypt(passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	return string(mnemonic), nil
+}
+
+// IsEmpty checks if the keystore holds neither a seed nor keys
+func (k *Keystore) IsEmpty() (bool, error) {
+	entries, err := k.List()
+	if err != nil {
+		return false, err
+	}
+
+	return !k.HasSeed() && len(entries) == 0, nil
+}
+
+// Verify checks a passphrase against the seed or the oldest entry of the keystore
+func (k *Keystore) Verify(passphrase string) error {
+	if k.HasSeed() {
+		_, err := k.Seed(passphrase)
+		return err
+	}
+
+	entries, err := k.List()
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	_, err = entries[0].Key(passphrase)
+	return err
+}
@@ -0,0 +1,247 @@
+package keystore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func testEntry(t *testing.T, name string, network *chaincfg.Params, key *btcec.PrivateKey) *Entry {
+	t.Helper()
+
+	e, err := NewEntry(name, sha256.Sum256([]byte(name)), network, OriginSeed, key, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEntryKey(t *testing.T) {
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := testEntry(t, "file.txt", &chaincfg.TestNet3Params, key)
+
+	decrypted, err := e.Key("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !decrypted.Key.Equals(&key.Key) {
+		t.Fatal("decrypted key does not match")
+	}
+
+	if _, err := e.Key("wrong passphrase"); err != ErrWrongPassphrase {
+		t.Fatalf("err = %v, want ErrWrongPassphrase", err)
+	}
+
+	params, err := e.Params()
+	if err != nil || params != &chaincfg.TestNet3Params {
+		t.Fatalf("params = %v, %v", params, err)
+	}
+
+	// The key must match the public key of the metadata
+	other, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.PubKey = hex.EncodeToString(other.PubKey().SerializeCompressed())
+
+	if _, err := e.Key("passphrase"); err == nil {
+		t.Fatal("expected an error for a key that does not match its public key")
+	}
+}
+
+func TestEntryIDPerNetwork(t *testing.T) {
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testnet := testEntry(t, "file.txt", &chaincfg.TestNet3Params, key)
+	regtest := testEntry(t, "file.txt", &chaincfg.RegressionNetParams, key)
+
+	if testnet.ID == regtest.ID {
+		t.Fatal("entries of the same key on different networks share their ID")
+	}
+
+	ks := New(t.TempDir())
+	for _, e := range []*Entry{testnet, regtest} {
+		if err := ks.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, e := range []*Entry{testnet, regtest} {
+		params, _ := e.Params()
+		found, err := ks.Find(sha256.Sum256([]byte("file.txt")), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(found) != 1 || found[0].ID != e.ID {
+			t.Fatalf("found %d entries on %s", len(found), e.Network)
+		}
+	}
+
+	found, err := ks.Find(sha256.Sum256([]byte("file.txt")), &chaincfg.MainNetParams)
+	if err != nil || len(found) != 0 {
+		t.Fatalf("found %d entries on mainnet, %v", len(found), err)
+	}
+}
+
+func TestKeystoreEntries(t *testing.T) {
+	ks := New(t.TempDir())
+
+	empty, err := ks.IsEmpty()
+	if err != nil || !empty {
+		t.Fatalf("empty = %v, %v", empty, err)
+	}
+
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := testEntry(t, "file.txt", &chaincfg.RegressionNetParams, key)
+
+	if err := ks.Add(e); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Add(e); err == nil {
+		t.Fatal("expected an error for an existing entry")
+	}
+
+	if !e.AddAddress("bcrt1qaddress") || e.AddAddress("bcrt1qaddress") {
+		t.Fatal("addresses are recorded once")
+	}
+
+	payload := Payload{SHA256: e.Hash, Method: "p2wsh"}
+	if !e.AddPayload(payload) || e.AddPayload(payload) {
+		t.Fatal("payloads are recorded once")
+	}
+
+	if err := ks.Save(e); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := ks.Get(e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.Addresses) != 1 || len(loaded.Payloads) != 1 || loaded.Payloads[0] != payload {
+		t.Fatalf("loaded %+v", *loaded)
+	}
+
+	entries, err := ks.List()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("listed %d entries, %v", len(entries), err)
+	}
+
+	if err := ks.Verify("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Verify("wrong passphrase"); err != ErrWrongPassphrase {
+		t.Fatalf("err = %v, want ErrWrongPassphrase", err)
+	}
+
+	if err := ks.Remove(e.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ks.Get(e.ID); err != ErrNotFound {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+
+	for _, id := range []string{"../seed", "0011223344556677"} {
+		if err := ks.Remove(id); err != ErrNotFound {
+			t.Fatalf("remove %q: err = %v, want ErrNotFound", id, err)
+		}
+	}
+}
+
+func TestKeystorePayload(t *testing.T) {
+	ks := New(t.TempDir())
+	data := []byte("injected file")
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	if err := ks.SavePayload(data, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Payloads are saved once
+	if err := ks.SavePayload(data, "other passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := ks.Payload(hash, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(loaded) != string(data) {
+		t.Fatal("loaded payload does not match")
+	}
+
+	if _, err := ks.Payload(hash, "wrong passphrase"); err != ErrWrongPassphrase {
+		t.Fatalf("err = %v, want ErrWrongPassphrase", err)
+	}
+
+	missing := sha256.Sum256([]byte("missing"))
+	for _, h := range []string{"../seed", hex.EncodeToString(missing[:])} {
+		if _, err := ks.Payload(h, "passphrase"); err != ErrNotFound {
+			t.Fatalf("payload %q: err = %v, want ErrNotFound", h, err)
+		}
+	}
+
+	// Payloads are not listed as entries
+	entries, err := ks.List()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("listed %d entries, %v", len(entries), err)
+	}
+}
+
+func TestKeystoreSeed(t *testing.T) {
+	ks := New(t.TempDir())
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+	if ks.HasSeed() {
+		t.Fatal("new keystore has a seed")
+	}
+
+	if err := ks.SaveSeed(mnemonic, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.SaveSeed(mnemonic, "passphrase"); err == nil {
+		t.Fatal("expected an error for an existing seed")
+	}
+
+	loaded, err := ks.Seed("passphrase")
+	if err != nil || loaded != mnemonic {
+		t.Fatalf("seed = %q, %v", loaded, err)
+	}
+
+	empty, err := ks.IsEmpty()
+	if err != nil || empty {
+		t.Fatalf("empty = %v, %v", empty, err)
+	}
+
+	// The seed is not listed as an entry
+	entries, err := ks.List()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("listed %d entries, %v", len(entries), err)
+	}
+
+	if err := ks.Verify("wrong passphrase"); err != ErrWrongPassphrase {
+		t.Fatalf("err = %v, want ErrWrongPassphrase", err)
+	}
+}
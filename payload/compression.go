@@ -0,0 +1,124 @@
+package payload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression represents the algorithm used to compress a file before injection
+type Compression uint8
+
+const (
+	// NoCompression stores the file as is
+	NoCompression Compression = iota
+	// Gzip compresses the file with gzip
+	Gzip
+	// Zstd compresses the file with zstandard
+	Zstd
+	// Brotli compresses the file with brotli
+	Brotli
+)
+
+// String returns the name of the compression algorithm
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case Brotli:
+		return "brotli"
+	}
+	return "unknown"
+}
+
+// ParseCompression returns the compression algorithm with the given name
+func ParseCompression(name string) (Compression, error) {
+	for _, c := range []Compression{NoCompression, Gzip, Zstd, Brotli} {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+
+	return NoCompression, errors.New("unknown compression algorithm: " + name)
+}
+
+// Compress compresses data with the best ratio available, block space is expensive
+func Compress(data []byte, c Compression) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+
+	switch c {
+	case NoCompression:
+		return data, nil
+	case Gzip:
+		w, err = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	case Zstd:
+		w, err = zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	case Brotli:
+		w = brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	default:
+		return nil, errors.New("unknown compression algorithm")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MaxDecompressedSize bounds decompression whatever the length announced by the retrieved header
+// Injecting 1 GiB would take hundreds of full blocks, so no injected file reaches it
+const MaxDecompressedSize = 1 << 30
+
+// Decompress restores data compressed by Compress
+// At most maxSize+1 bytes are decompressed so that a length mismatch can be detected without exhausting memory
+func Decompress(data []byte, c Compression, maxSize uint64) ([]byte, error) {
+	if maxSize > MaxDecompressedSize {
+		maxSize = MaxDecompressedSize
+	}
+
+	switch c {
+	case NoCompression:
+		return data, nil
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	case Zstd:
+		r, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	case Brotli:
+		return ioutil.ReadAll(io.LimitReader(brotli.NewReader(bytes.NewReader(data)), int64(maxSize)+1))
+	}
+
+	return nil, errors.New("unknown compression algorithm")
+}
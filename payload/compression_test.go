@@ -0,0 +1,145 @@
+package payload
+
+import (
+	"bytes"
+	"testing"
+)
+
+var compressions = []Compression{NoCompression, Gzip, Zstd, Brotli}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("block space is expensive "), 200)
+
+	for _, c := range compressions {
+		t.Run(c.String(), func(t *testing.T) {
+			compressed, err := Compress(data, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c != NoCompression && len(compressed) >= len(data) {
+				t.Fatalf("compressed %d bytes to %d bytes", len(data), len(compressed))
+			}
+
+			decompressed, err := Decompress(compressed, c, uint64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(decompressed, data) {
+				t.Fatal("decompressed data does not match")
+			}
+		})
+	}
+}
+
+func TestEncodeDecodeCompressed(t *testing.T) {
+	data := bytes.Repeat([]byte("compressed payload "), 100)
+
+	for _, c := range compressions {
+		t.Run(c.String(), func(t *testing.T) {
+			header := NewHeader(data, "file.txt", "text/plain", c)
+			raw, err := Encode(header, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, file, err := Decode(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if decoded.Compression != c {
+				t.Fatalf("compression = %s, want %s", decoded.Compression, c)
+			}
+
+			if err := decoded.Verify(file); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDecompressBounded(t *testing.T) {
+	// A header could claim a small length for a large file, decompression stops right after it
+	data := make([]byte, 100000)
+
+	for _, c := range compressions[1:] {
+		t.Run(c.String(), func(t *testing.T) {
+			compressed, err := Compress(data, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decompressed, err := Decompress(compressed, c, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(decompressed) != 1001 {
+				t.Fatalf("decompressed %d bytes, want 1001", len(decompressed))
+			}
+
+			// Lengths above the limit are capped whatever the header claims
+			decompressed, err = Decompress(compressed, c, 1<<62)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(decompressed) != len(data) {
+				t.Fatalf("decompressed %d bytes, want %d", len(decompressed), len(data))
+			}
+		})
+	}
+}
+
+func TestDecodeLengthMismatch(t *testing.T) {
+	data := bytes.Repeat([]byte{'a'}, 5000)
+	header := NewHeader(data, "file.txt", "text/plain", Gzip)
+	header.Length = 10
+
+	raw, err := Encode(header, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, file, err := Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Verify(file) == nil {
+		t.Fatal("expected a length mismatch")
+	}
+}
+
+func TestCompressionInvalid(t *testing.T) {
+	if _, err := ParseCompression("lzma"); err == nil {
+		t.Fatal("expected an error for an unknown algorithm")
+	}
+
+	for _, c := range compressions[1:] {
+		if _, err := Decompress([]byte("not compressed data"), c, 100); err == nil {
+			t.Fatalf("%s: expected an error for invalid data", c)
+		}
+	}
+}
+
+func TestCompressLargeFile(t *testing.T) {
+	// Files split across several transactions can be compressed as well
+	data := bytes.Repeat([]byte("large file "), 500000)
+
+	raw, err := Encode(NewHeader(data, "large.txt", "text/plain", Zstd), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, file, err := Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := header.Verify(file); err != nil {
+		t.Fatal(err)
+	}
+}
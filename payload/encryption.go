@@ -0,0 +1,113 @@
+package payload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// encryptionMagic identifies an encrypted payload among retrieved data
+var encryptionMagic = []byte("BCEN")
+
+// EncryptionVersion is the current version of the encrypted payload format
+const EncryptionVersion = 1
+
+// Argon2id parameters used to derive keys from passphrases
+// They are stored with the ciphertext so that they can be raised later
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	saltSize     = 16
+	tagSize      = 16
+)
+
+// encryptionHeaderSize is the size of the encrypted payload header
+// magic (4 bytes) | version (1 byte) | time (4 bytes) | memory (4 bytes) | threads (1 byte) | salt (16 bytes) | nonce (24 bytes)
+const encryptionHeaderSize = 4 + 1 + 4 + 4 + 1 + saltSize + chacha20poly1305.NonceSizeX
+
+// EncryptionOverhead is the number of bytes added by encryption
+const EncryptionOverhead = encryptionHeaderSize + tagSize
+
+// ErrWrongPassphrase is returned when an encrypted payload cannot be authenticated
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted data")
+
+// IsEncrypted checks if retrieved data is an encrypted payload
+func IsEncrypted(raw []byte) bool {
+	return len(raw) >= len(encryptionMagic) && bytes.Equal(raw[:len(encryptionMagic)], encryptionMagic)
+}
+
+// Encrypt encrypts a payload with XChaCha20-Poly1305 using a key derived from a passphrase with Argon2id
+// The whole payload is encrypted, including the file name and MIME type
+func Encrypt(data []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.Write(encryptionMagic)
+	header.WriteByte(EncryptionVersion)
+	_ = binary.Write(&header, binary.BigEndian, uint32(argonTime))
+	_ = binary.Write(&header, binary.BigEndian, uint32(argonMemory))
+	header.WriteByte(argonThreads)
+	header.Write(salt)
+	header.Write(nonce)
+
+	aead, err := chacha20poly1305.NewX(argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+
+	// The header is authenticated so that KDF parameters cannot be tampered with
+	return aead.Seal(header.Bytes(), nonce, data, header.Bytes()), nil
+}
+
+// Decrypt decrypts a payload encrypted by Encrypt
+func Decrypt(raw []byte, passphrase string) ([]byte, error) {
+	if !IsEncrypted(raw) {
+		return nil, errors.New("payload is not encrypted")
+	}
+
+	if len(raw) < EncryptionOverhead {
+		return nil, errors.New("truncated encrypted payload")
+	}
+
+	if raw[4] != EncryptionVersion {
+		return nil, errors.New("unsupported encrypted payload version")
+	}
+
+	time := binary.BigEndian.Uint32(raw[5:9])
+	memory := binary.BigEndian.Uint32(raw[9:13])
+	threads := raw[13]
+	salt := raw[14 : 14+saltSize]
+	nonce := raw[14+saltSize : encryptionHeaderSize]
+
+	// Refuse parameters that would exhaust resources, memory is in KiB
+	if time == 0 || time > 16 || memory > 1024*1024 || threads == 0 {
+		return nil, errors.New("invalid key derivation parameters")
+	}
+
+	aead, err := chacha20poly1305.NewX(argon2.IDKey([]byte(passphrase), salt, time, memory, threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := aead.Open(nil, nonce, raw[encryptionHeaderSize:], raw[:encryptionHeaderSize])
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return data, nil
+}
@@ -0,0 +1,97 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	data := []byte("secret payload")
+
+	raw, err := Encrypt(data, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsEncrypted(raw) {
+		t.Fatal("encrypted payload is not recognized")
+	}
+
+	if len(raw) != len(data)+EncryptionOverhead {
+		t.Fatalf("encrypted %d bytes to %d bytes, want %d", len(data), len(raw), len(data)+EncryptionOverhead)
+	}
+
+	decrypted, err := Decrypt(raw, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match")
+	}
+}
+
+func TestDecryptWrongPassphrase(t *testing.T) {
+	raw, err := Encrypt([]byte("secret payload"), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decrypt(raw, "battery staple"); err != ErrWrongPassphrase {
+		t.Fatalf("err = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestDecryptInvalid(t *testing.T) {
+	raw, err := Encrypt([]byte("secret payload"), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tamper := func(f func(b []byte)) []byte {
+		b := append([]byte{}, raw...)
+		f(b)
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"not encrypted", []byte("plain data"), nil},
+		{"truncated", raw[:EncryptionOverhead-1], nil},
+		{"unknown version", tamper(func(b []byte) { b[4] = EncryptionVersion + 1 }), nil},
+		{"no time", tamper(func(b []byte) { binary.BigEndian.PutUint32(b[5:9], 0) }), nil},
+		{"too much memory", tamper(func(b []byte) { binary.BigEndian.PutUint32(b[9:13], 1<<30) }), nil},
+		{"no threads", tamper(func(b []byte) { b[13] = 0 }), nil},
+		// Lower parameters still derive a key, the authenticated header must then fail
+		{"tampered parameters", tamper(func(b []byte) { binary.BigEndian.PutUint32(b[5:9], 1) }), ErrWrongPassphrase},
+		{"tampered salt", tamper(func(b []byte) { b[14] ^= 1 }), ErrWrongPassphrase},
+		{"tampered ciphertext", tamper(func(b []byte) { b[len(b)-1] ^= 1 }), ErrWrongPassphrase},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Decrypt(test.data, "passphrase")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if test.err != nil && err != test.err {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	if IsEncrypted(encryptionMagic[:3]) {
+		t.Fatal("a partial magic is not encrypted")
+	}
+
+	if IsEncrypted([]byte("plain data")) {
+		t.Fatal("plain data is not encrypted")
+	}
+}
@@ -0,0 +1,148 @@
+package payload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// magic identifies a payload among retrieved data
+var magic = []byte("BCDL")
+
+// Version is the current version of the payload format
+// Version 2 added the compression algorithm
+const Version = 2
+
+// maxFieldSize is the maximum size of the name and MIME type fields
+const maxFieldSize = 255
+
+// ErrNoHeader is returned when retrieved data does not start with a payload header
+// Files injected before payloads were introduced are stored without a header
+var ErrNoHeader = errors.New("no payload header")
+
+// Header describes the file contained in a payload
+// Length and checksum are computed on the original file, before compression
+type Header struct {
+	Version     uint8
+	Compression Compression
+	Name        string
+	MIMEType    string
+	Length      uint64
+	Checksum    [sha256.Size]byte
+}
+
+// NewHeader describes a file
+func NewHeader(data []byte, name, mimeType string, compression Compression) *Header {
+	return &Header{
+		Version:     Version,
+		Compression: compression,
+		Name:        truncate(name),
+		MIMEType:    truncate(mimeType),
+		Length:      uint64(len(data)),
+		Checksum:    sha256.Sum256(data),
+	}
+}
+
+// Encode compresses the file and prepends the header to it
+// Format: magic (4 bytes) | version (1 byte) | compression (1 byte) | name (varstring) | MIME type (varstring) | length (varint) | SHA-256 (32 bytes) | file
+func Encode(header *Header, data []byte) ([]byte, error) {
+	compressed, err := Compress(data, header.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	buf.Write(magic)
+	buf.WriteByte(header.Version)
+	buf.WriteByte(byte(header.Compression))
+	_ = wire.WriteVarString(&buf, 0, header.Name)
+	_ = wire.WriteVarString(&buf, 0, header.MIMEType)
+	_ = wire.WriteVarInt(&buf, 0, header.Length)
+	buf.Write(header.Checksum[:])
+	buf.Write(compressed)
+
+	return buf.Bytes(), nil
+}
+
+// Decode parses the header of a payload and returns the decompressed file following it
+func Decode(raw []byte) (*Header, []byte, error) {
+	if len(raw) < len(magic) || !bytes.Equal(raw[:len(magic)], magic) {
+		return nil, nil, ErrNoHeader
+	}
+
+	r := bytes.NewReader(raw[len(magic):])
+
+	var header Header
+	var err error
+
+	header.Version, err = r.ReadByte()
+	if err != nil {
+		return nil, nil, errors.New("truncated payload header")
+	}
+
+	if header.Version < 1 || header.Version > Version {
+		return nil, nil, errors.New("unsupported payload version")
+	}
+
+	// Version 1 payloads are not compressed
+	if header.Version >= 2 {
+		compression, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, errors.New("truncated payload header")
+		}
+		header.Compression = Compression(compression)
+	}
+
+	header.Name, err = wire.ReadVarString(r, 0)
+	if err != nil {
+		return nil, nil, errors.New("truncated payload header")
+	}
+
+	header.MIMEType, err = wire.ReadVarString(r, 0)
+	if err != nil {
+		return nil, nil, errors.New("truncated payload header")
+	}
+
+	header.Length, err = wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, nil, errors.New("truncated payload header")
+	}
+
+	_, err = io.ReadFull(r, header.Checksum[:])
+	if err != nil {
+		return nil, nil, errors.New("truncated payload header")
+	}
+
+	data, err := Decompress(raw[len(raw)-r.Len():], header.Compression, header.Length)
+	if err != nil {
+		return nil, nil, errors.New("could not decompress " + header.Compression.String() + " payload")
+	}
+
+	return &header, data, nil
+}
+
+// Verify checks that the file matches the length and checksum of the header
+func (h *Header) Verify(data []byte) error {
+	if uint64(len(data)) != h.Length {
+		return errors.New("length mismatch")
+	}
+
+	if sha256.Sum256(data) != h.Checksum {
+		return errors.New("checksum mismatch")
+	}
+
+	return nil
+}
+
+// truncate limits the size of a header field without splitting characters
+func truncate(s string) string {
+	if len(s) > maxFieldSize {
+		return strings.ToValidUTF8(s[:maxFieldSize], "")
+	}
+	return s
+}
@@ -0,0 +1,116 @@
+package payload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	data := []byte("hello bitcoin")
+	header := NewHeader(data, "hello.txt", "text/plain", NoCompression)
+
+	raw, err := Encode(header, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, file, err := Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *decoded != *header {
+		t.Fatalf("decoded %+v, want %+v", *decoded, *header)
+	}
+
+	if !bytes.Equal(file, data) {
+		t.Fatal("decoded file does not match")
+	}
+
+	if err := decoded.Verify(file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeVersion1(t *testing.T) {
+	data := []byte("injected before compression")
+	checksum := sha256.Sum256(data)
+
+	// Version 1 payloads have no compression byte
+	var buf bytes.Buffer
+	buf.Write(magic)
+	buf.WriteByte(1)
+	_ = wire.WriteVarString(&buf, 0, "old.txt")
+	_ = wire.WriteVarString(&buf, 0, "text/plain")
+	_ = wire.WriteVarInt(&buf, 0, uint64(len(data)))
+	buf.Write(checksum[:])
+	buf.Write(data)
+
+	header, file, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Version != 1 || header.Compression != NoCompression || header.Name != "old.txt" {
+		t.Fatalf("unexpected header %+v", *header)
+	}
+
+	if err := header.Verify(file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	data := []byte("hello bitcoin")
+	raw, err := Encode(NewHeader(data, "hello.txt", "text/plain", NoCompression), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknownVersion := append([]byte{}, raw...)
+	unknownVersion[len(magic)] = Version + 1
+
+	// The header ends with the checksum, cut in the middle of it
+	truncated := raw[:len(raw)-len(data)-1]
+
+	if _, _, err := Decode([]byte("no header")); err != ErrNoHeader {
+		t.Fatalf("err = %v, want ErrNoHeader", err)
+	}
+
+	if _, _, err := Decode(unknownVersion); err == nil {
+		t.Fatal("expected an error for an unknown version")
+	}
+
+	if _, _, err := Decode(truncated); err == nil {
+		t.Fatal("expected an error for a truncated header")
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	data := []byte("hello bitcoin")
+	header := NewHeader(data, "hello.txt", "text/plain", NoCompression)
+
+	if header.Verify(data[1:]) == nil {
+		t.Fatal("expected a length mismatch")
+	}
+
+	tampered := append([]byte{}, data...)
+	tampered[0] ^= 1
+	if header.Verify(tampered) == nil {
+		t.Fatal("expected a checksum mismatch")
+	}
+}
+
+func TestNewHeaderTruncatesFields(t *testing.T) {
+	// Multi-byte characters must not be split
+	name := strings.Repeat("é", maxFieldSize)
+	header := NewHeader(nil, name, "text/plain", NoCompression)
+
+	if len(header.Name) > maxFieldSize || !strings.HasPrefix(name, header.Name) || len(header.Name)%2 != 0 {
+		t.Fatalf("name truncated to %d bytes", len(header.Name))
+	}
+}
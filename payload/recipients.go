@@ -0,0 +1,191 @@
+package payload
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/wire"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// recipientMagic identifies a payload encrypted to recipients among retrieved data
+var recipientMagic = []byte("BCRC")
+
+// RecipientVersion is the current version of the recipient encrypted payload format
+const RecipientVersion = 1
+
+// slotSize is the size of a wrapped content key: ephemeral public key (33 bytes) | encrypted content key (32 bytes) | tag (16 bytes)
+const slotSize = btcec.PubKeyBytesLenCompressed + chacha20poly1305.KeySize + tagSize
+
+// slotInfo binds derived keys to this usage
+var slotInfo = []byte("bitcandle recipient slot")
+
+// ErrNotRecipient is returned when none of the slots of a payload can be unwrapped with a key
+var ErrNotRecipient = errors.New("key is not a recipient of this file")
+
+// RecipientOverhead returns the number of bytes added by encryption to a number of recipients
+func RecipientOverhead(recipients int) int {
+	return len(recipientMagic) + 1 + chacha20poly1305.NonceSizeX + wire.VarIntSerializeSize(uint64(recipients)) + recipients*slotSize + tagSize
+}
+
+// IsEncryptedToRecipients checks if retrieved data is a payload encrypted to recipients
+func IsEncryptedToRecipients(raw []byte) bool {
+	return len(raw) >= len(recipientMagic) && bytes.Equal(raw[:len(recipientMagic)], recipientMagic)
+}
+
+// EncryptToRecipients encrypts a payload with a random content key wrapped for each recipient with ECIES
+// Format: magic (4 bytes) | version (1 byte) | nonce (24 bytes) | slot count (varint) | slots | ciphertext
+// Slots do not reveal the public keys of the recipients
+func EncryptToRecipients(data []byte, recipients []*btcec.PublicKey) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+
+	contentKey := make([]byte, chacha20poly1305.KeySize)
+	_, err := rand.Read(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.Write(recipientMagic)
+	header.WriteByte(RecipientVersion)
+	header.Write(nonce)
+	_ = wire.WriteVarInt(&header, 0, uint64(len(recipients)))
+
+	for _, recipient := range recipients {
+		slot, err := wrapKey(contentKey, recipient)
+		if err != nil {
+			return nil, err
+		}
+		header.Write(slot)
+	}
+
+	aead, err := chacha20poly1305.NewX(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(header.Bytes(), nonce, data, header.Bytes()), nil
+}
+
+// DecryptWithKey finds the slot of the key, unwraps the content key and decrypts the payload
+func DecryptWithKey(raw []byte, key *btcec.PrivateKey) ([]byte, error) {
+	if !IsEncryptedToRecipients(raw) {
+		return nil, errors.New("payload is not encrypted to recipients")
+	}
+
+	r := bytes.NewReader(raw[len(recipientMagic):])
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, errors.New("truncated encrypted payload")
+	}
+
+	if version != RecipientVersion {
+		return nil, errors.New("unsupported encrypted payload version")
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	_, err = io.ReadFull(r, nonce)
+	if err != nil {
+		return nil, errors.New("truncated encrypted payload")
+	}
+
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil || count > uint64(r.Len()/slotSize) {
+		return nil, errors.New("truncated encrypted payload")
+	}
+
+	var contentKey []byte
+	slot := make([]byte, slotSize)
+	for i := uint64(0); i < count; i++ {
+		_, err = io.ReadFull(r, slot)
+		if err != nil {
+			return nil, errors.New("truncated encrypted payload")
+		}
+
+		if contentKey == nil {
+			contentKey, _ = unwrapKey(slot, key)
+		}
+	}
+
+	if contentKey == nil {
+		return nil, ErrNotRecipient
+	}
+
+	aead, err := chacha20poly1305.NewX(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize := len(raw) - r.Len()
+	data, err := aead.Open(nil, nonce, raw[headerSize:], raw[:headerSize])
+	if err != nil {
+		return nil, errors.New("corrupted data")
+	}
+
+	return data, nil
+}
+
+// wrapKey encrypts the content key with a key shared between an ephemeral key and the recipient
+func wrapKey(contentKey []byte, recipient *btcec.PublicKey) ([]byte, error) {
+	ephemeral, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPubKey := ephemeral.PubKey().SerializeCompressed()
+
+	aead, err := slotCipher(btcec.GenerateSharedSecret(ephemeral, recipient), ephemeralPubKey, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each ephemeral key is used once, the nonce can be constant
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	return aead.Seal(ephemeralPubKey, nonce, contentKey, nil), nil
+}
+
+// unwrapKey decrypts the content key of a slot if it was wrapped for the key
+func unwrapKey(slot []byte, key *btcec.PrivateKey) ([]byte, error) {
+	ephemeralPubKey := slot[:btcec.PubKeyBytesLenCompressed]
+
+	ephemeral, err := btcec.ParsePubKey(ephemeralPubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := slotCipher(btcec.GenerateSharedSecret(key, ephemeral), ephemeralPubKey, key.PubKey())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	return aead.Open(nil, nonce, slot[btcec.PubKeyBytesLenCompressed:], nil)
+}
+
+// slotCipher derives the key encrypting a slot from the ECDH shared secret and both public keys
+func slotCipher(secret, ephemeralPubKey []byte, recipient *btcec.PublicKey) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeralPubKey...), recipient.SerializeCompressed()...)
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, slotInfo), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return chacha20poly1305.NewX(key)
+}
@@ -0,0 +1,126 @@
+package payload
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func testKeys(t *testing.T, n int) []*btcec.PrivateKey {
+	t.Helper()
+
+	keys := make([]*btcec.PrivateKey, n)
+	for i := range keys {
+		key, err := btcec.NewPrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+func TestEncryptToRecipients(t *testing.T) {
+	data := []byte("shared payload")
+	keys := testKeys(t, 3)
+
+	var recipients []*btcec.PublicKey
+	for _, key := range keys {
+		recipients = append(recipients, key.PubKey())
+	}
+
+	raw, err := EncryptToRecipients(data, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsEncryptedToRecipients(raw) || IsEncrypted(raw) {
+		t.Fatal("payload encrypted to recipients is not recognized")
+	}
+
+	if len(raw) != len(data)+RecipientOverhead(len(recipients)) {
+		t.Fatalf("encrypted %d bytes to %d bytes, want %d", len(data), len(raw), len(data)+RecipientOverhead(len(recipients)))
+	}
+
+	for i, key := range keys {
+		decrypted, err := DecryptWithKey(raw, key)
+		if err != nil {
+			t.Fatalf("recipient %d: %v", i, err)
+		}
+
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("recipient %d: decrypted data does not match", i)
+		}
+	}
+
+	for _, recipient := range recipients {
+		if bytes.Contains(raw, recipient.SerializeCompressed()) {
+			t.Fatal("payload reveals a recipient")
+		}
+	}
+}
+
+func TestDecryptWithKeyNotRecipient(t *testing.T) {
+	keys := testKeys(t, 2)
+
+	raw, err := EncryptToRecipients([]byte("shared payload"), []*btcec.PublicKey{keys[0].PubKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecryptWithKey(raw, keys[1]); err != ErrNotRecipient {
+		t.Fatalf("err = %v, want ErrNotRecipient", err)
+	}
+}
+
+func TestDecryptWithKeyInvalid(t *testing.T) {
+	key := testKeys(t, 1)[0]
+
+	raw, err := EncryptToRecipients([]byte("shared payload"), []*btcec.PublicKey{key.PubKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tamper := func(f func(b []byte)) []byte {
+		b := append([]byte{}, raw...)
+		f(b)
+		return b
+	}
+
+	// Magic, version, nonce and a single byte slot count
+	header := len(recipientMagic) + 1 + chacha20poly1305.NonceSizeX + 1
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"not encrypted", []byte("plain data"), nil},
+		{"no version", recipientMagic, nil},
+		{"unknown version", tamper(func(b []byte) { b[len(recipientMagic)] = RecipientVersion + 1 }), nil},
+		{"truncated nonce", raw[:len(recipientMagic)+10], nil},
+		{"too many slots", tamper(func(b []byte) { b[header-1] = 100 }), nil},
+		{"tampered slot", tamper(func(b []byte) { b[header+slotSize-1] ^= 1 }), ErrNotRecipient},
+		{"tampered nonce", tamper(func(b []byte) { b[len(recipientMagic)+1] ^= 1 }), nil},
+		{"tampered ciphertext", tamper(func(b []byte) { b[len(b)-1] ^= 1 }), nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecryptWithKey(test.data, key)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if test.err != nil && err != test.err {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+		})
+	}
+
+	if _, err := EncryptToRecipients([]byte("data"), nil); err == nil {
+		t.Fatal("expected an error without recipients")
+	}
+}
@@ -0,0 +1,90 @@
+package seed
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// Purpose is the hardened purpose of the BIP32 path of injection keys
+// Keys are derived at m/25187'/coin'/h1'/.../h8' where h1...h8 are the 8 words of the SHA-256 of the file, without their first bit
+const Purpose = 25187
+
+// entropySize is the size of the entropy of 24 words mnemonics
+const entropySize = 256
+
+// Seed derives the injection key of every file from a single BIP39 mnemonic
+type Seed struct {
+	Mnemonic string
+	master   *hdkeychain.ExtendedKey
+}
+
+// New generates a seed from a new 24 words mnemonic
+func New() (*Seed, error) {
+	entropy, err := bip39.NewEntropy(entropySize)
+	if err != nil {
+		return nil, err
+	}
+
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		return nil, err
+	}
+
+	return FromMnemonic(mnemonic)
+}
+
+// FromMnemonic restores a seed from its mnemonic, the checksum of the mnemonic is verified
+func FromMnemonic(mnemonic string) (*Seed, error) {
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, errors.New("invalid mnemonic: " + err.Error())
+	}
+
+	// The version of the master key is only used to serialize it, it does not change derived keys
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Seed{Mnemonic: mnemonic, master: master}, nil
+}
+
+// Path returns the derivation path of the injection key of a file
+// Coin types follow BIP44, mainnet keys differ from the keys of test networks
+func Path(hash [sha256.Size]byte, network *chaincfg.Params) []uint32 {
+	coinType := uint32(1)
+	if network.Net == chaincfg.MainNetParams.Net {
+		coinType = 0
+	}
+
+	path := []uint32{Purpose + hdkeychain.HardenedKeyStart, coinType + hdkeychain.HardenedKeyStart}
+	for i := 0; i < sha256.Size; i += 4 {
+		index := binary.BigEndian.Uint32(hash[i:i+4]) &^ hdkeychain.HardenedKeyStart
+		path = append(path, index+hdkeychain.HardenedKeyStart)
+	}
+
+	return path
+}
+
+// Key derives the injection key of a file from its SHA-256
+func (s *Seed) Key(hash [sha256.Size]byte, network *chaincfg.Params) (*btcec.PrivateKey, error) {
+	key := s.master
+	for _, index := range Path(hash, network) {
+		var err error
+		key, err = key.Derive(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key.ECPrivKey()
+}
@@ -0,0 +1,105 @@
+package seed
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestPath(t *testing.T) {
+	hash := sha256.Sum256([]byte("abc"))
+	words := []uint32{0xba7816bf, 0x8f01cfea, 0xc14140de, 0xddae2223, 0xb00361a3, 0x96177a9c, 0xb410ff61, 0xf20015ad}
+
+	tests := []struct {
+		network *chaincfg.Params
+		path    []uint32
+	}{
+		{&chaincfg.MainNetParams, append([]uint32{0x80006263, 0x80000000}, words...)},
+		{&chaincfg.TestNet3Params, append([]uint32{0x80006263, 0x80000001}, words...)},
+		{&chaincfg.RegressionNetParams, append([]uint32{0x80006263, 0x80000001}, words...)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.network.Name, func(t *testing.T) {
+			if path := Path(hash, test.network); !reflect.DeepEqual(path, test.path) {
+				t.Fatalf("path = %x, want %x", path, test.path)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	s, err := FromMnemonic(testMnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data    string
+		network *chaincfg.Params
+		key     string
+	}{
+		{"abc", &chaincfg.MainNetParams, "580349421528155059543349f5478a6f493331d6d9db6b189b57ca5a3be3dd4d"},
+		{"abc", &chaincfg.TestNet3Params, "64b4c6f2bfea1c7606c71a0b5049bdac43b05771476e19c9ad70ad6b32e0a928"},
+		{"abc", &chaincfg.RegressionNetParams, "64b4c6f2bfea1c7606c71a0b5049bdac43b05771476e19c9ad70ad6b32e0a928"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.network.Name, func(t *testing.T) {
+			key, err := s.Key(sha256.Sum256([]byte(test.data)), test.network)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if hex.EncodeToString(key.Serialize()) != test.key {
+				t.Fatalf("key = %x, want %s", key.Serialize(), test.key)
+			}
+		})
+	}
+}
+
+func TestFromMnemonic(t *testing.T) {
+	tests := []struct {
+		name     string
+		mnemonic string
+		valid    bool
+	}{
+		{"valid", testMnemonic, true},
+		{"extra whitespace", "  " + strings.Replace(testMnemonic, " ", "\n ", 3) + " ", true},
+		{"wrong checksum", strings.Replace(testMnemonic, "about", "abandon", 1), false},
+		{"unknown word", strings.Replace(testMnemonic, "about", "bitcandle", 1), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := FromMnemonic(test.mnemonic)
+			if (err == nil) != test.valid {
+				t.Fatalf("err = %v, valid = %v", err, test.valid)
+			}
+
+			if test.valid && s.Mnemonic != testMnemonic {
+				t.Fatalf("mnemonic = %q", s.Mnemonic)
+			}
+		})
+	}
+
+	// New seeds are 24 words mnemonics that can be restored
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(strings.Fields(s.Mnemonic)) != 24 {
+		t.Fatalf("mnemonic of %d words", len(strings.Fields(s.Mnemonic)))
+	}
+
+	if _, err := FromMnemonic(s.Mnemonic); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,199 @@
+package spv
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Proof holds what is needed to check that a transaction is included in a block
+type Proof struct {
+	Height   int32
+	Header   wire.BlockHeader
+	Branch   []chainhash.Hash
+	Position uint32
+}
+
+// Verify checks that the proof commits to a transaction
+func (p *Proof) Verify(txid chainhash.Hash, params *chaincfg.Params) error {
+	return VerifyInclusion(txid, p.Branch, p.Position, &p.Header, params)
+}
+
+// MerkleRoot computes the root of a Merkle branch starting from a transaction at a position in the block
+func MerkleRoot(txid chainhash.Hash, branch []chainhash.Hash, position uint32) chainhash.Hash {
+	hash := txid
+
+	for _, sibling := range branch {
+		// The position tells on which side the sibling is at each level
+		if position&1 == 0 {
+			hash = chainhash.DoubleHashH(append(hash[:], sibling[:]...))
+		} else {
+			hash = chainhash.DoubleHashH(append(sibling[:], hash[:]...))
+		}
+		position >>= 1
+	}
+
+	return hash
+}
+
+// CheckProofOfWork checks that the hash of a header is below the target it declares, and that the target is within the limit of the network
+// The target alone is cheap to forge, VerifyChain checks it against the difficulty expected at the height of the block
+func CheckProofOfWork(header *wire.BlockHeader, params *chaincfg.Params) error {
+	target := blockchain.CompactToBig(header.Bits)
+	if target.Sign() <= 0 || target.Cmp(params.PowLimit) > 0 {
+		return errors.New("invalid target")
+	}
+
+	hash := header.BlockHash()
+	if blockchain.HashToBig(&hash).Cmp(target) > 0 {
+		return errors.New("block hash is above target")
+	}
+
+	return nil
+}
+
+// VerifyInclusion checks that a transaction is committed by the Merkle root of a well formed header
+// The header must come from a chain checked by VerifyChain to prove that the block was mined
+func VerifyInclusion(txid chainhash.Hash, branch []chainhash.Hash, position uint32, header *wire.BlockHeader, params *chaincfg.Params) error {
+	err := CheckProofOfWork(header, params)
+	if err != nil {
+		return err
+	}
+
+	// Each level of the branch consumes one bit of the position
+	if len(branch) > 32 || position>>uint(len(branch)) != 0 {
+		return errors.New("invalid merkle branch")
+	}
+
+	if MerkleRoot(txid, branch, position) != header.MerkleRoot {
+		return errors.New("merkle root mismatch")
+	}
+
+	return nil
+}
+
+// retargetInterval returns the number of blocks between two difficulty adjustments
+func retargetInterval(params *chaincfg.Params) int32 {
+	return int32(params.TargetTimespan / params.TargetTimePerBlock)
+}
+
+// Anchor returns the height from which headers must be verified to trust the header at a height
+// It is the start of the retarget period of the last checkpoint below the height, or the genesis block when there is none
+func Anchor(height int32, params *chaincfg.Params) int32 {
+	for i := len(params.Checkpoints) - 1; i >= 0; i-- {
+		if params.Checkpoints[i].Height <= height {
+			interval := retargetInterval(params)
+			return params.Checkpoints[i].Height / interval * interval
+		}
+	}
+
+	return 0
+}
+
+// VerifyChain checks a chain of consecutive headers starting at a retarget height
+// Headers must be linked, meet the difficulty expected by the retarget rules of the network, and include a checkpoint or the genesis block
+func VerifyChain(start int32, headers []wire.BlockHeader, params *chaincfg.Params) error {
+	if len(headers) == 0 {
+		return errors.New("empty header chain")
+	}
+
+	// Retargets need the whole period, so chains start at its first block
+	if start < 0 || start%retargetInterval(params) != 0 {
+		return errors.New("header chain does not start at a retarget height")
+	}
+
+	for i := range headers {
+		height := start + int32(i)
+
+		err := CheckProofOfWork(&headers[i], params)
+		if err != nil {
+			return fmt.Errorf("block %d: %v", height, err)
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		if headers[i].PrevBlock != headers[i-1].BlockHash() {
+			return fmt.Errorf("block %d does not follow block %d", height, height-1)
+		}
+
+		if headers[i].Bits != requiredBits(start, headers[:i], &headers[i], params) {
+			return fmt.Errorf("block %d: unexpected difficulty", height)
+		}
+	}
+
+	anchored := start == 0 && headers[0].BlockHash() == *params.GenesisHash
+	top := start + int32(len(headers)) - 1
+	for _, checkpoint := range params.Checkpoints {
+		if checkpoint.Height < start || checkpoint.Height > top {
+			continue
+		}
+
+		if headers[checkpoint.Height-start].BlockHash() != *checkpoint.Hash {
+			return fmt.Errorf("block %d does not match checkpoint", checkpoint.Height)
+		}
+		anchored = true
+	}
+
+	if !anchored {
+		return errors.New("header chain does not include a checkpoint")
+	}
+
+	return nil
+}
+
+// requiredBits returns the difficulty a header must declare after the previous headers of a chain starting at a retarget height
+// It follows the rules of btcd, including the minimum difficulty blocks of testnet
+func requiredBits(start int32, previous []wire.BlockHeader, header *wire.BlockHeader, params *chaincfg.Params) uint32 {
+	interval := retargetInterval(params)
+	height := start + int32(len(previous))
+	last := &previous[len(previous)-1]
+
+	if params.PoWNoRetargeting {
+		return params.PowLimitBits
+	}
+
+	if height%interval != 0 {
+		if !params.ReduceMinDifficulty {
+			return last.Bits
+		}
+
+		// Testnet allows minimum difficulty blocks when no block was found for a while
+		if header.Timestamp.After(last.Timestamp.Add(params.MinDiffReductionTime)) {
+			return params.PowLimitBits
+		}
+
+		// Otherwise the difficulty of the last regular block of the period applies
+		i := len(previous) - 1
+		for i > 0 && (start+int32(i))%interval != 0 && previous[i].Bits == params.PowLimitBits {
+			i--
+		}
+		return previous[i].Bits
+	}
+
+	// The new target scales with the time the last period took, within the adjustment factor
+	first := &previous[len(previous)-int(interval)]
+	targetTimespan := int64(params.TargetTimespan / time.Second)
+	timespan := last.Timestamp.Unix() - first.Timestamp.Unix()
+	if timespan < targetTimespan/params.RetargetAdjustmentFactor {
+		timespan = targetTimespan / params.RetargetAdjustmentFactor
+	} else if timespan > targetTimespan*params.RetargetAdjustmentFactor {
+		timespan = targetTimespan * params.RetargetAdjustmentFactor
+	}
+
+	target := blockchain.CompactToBig(last.Bits)
+	target.Mul(target, big.NewInt(timespan))
+	target.Div(target, big.NewInt(targetTimespan))
+	if target.Cmp(params.PowLimit) > 0 {
+		target.Set(params.PowLimit)
+	}
+
+	return blockchain.BigToCompact(target)
+}
@@ -0,0 +1,236 @@
+package spv
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// retargetParams returns regtest parameters with a retarget every 4 blocks and no minimum difficulty blocks
+func retargetParams() *chaincfg.Params {
+	params := chaincfg.RegressionNetParams
+	params.PoWNoRetargeting = false
+	params.ReduceMinDifficulty = false
+	params.TargetTimespan = 4 * params.TargetTimePerBlock
+	params.MinDiffReductionTime = 2 * params.TargetTimePerBlock
+	return &params
+}
+
+// mineHeader mines a header on top of another one, mined or not depending on valid
+func mineHeader(t *testing.T, prev *wire.BlockHeader, delay time.Duration, bits uint32, valid bool) wire.BlockHeader {
+	t.Helper()
+
+	header := wire.BlockHeader{
+		Version:   4,
+		PrevBlock: prev.BlockHash(),
+		Timestamp: prev.Timestamp.Add(delay),
+		Bits:      bits,
+	}
+
+	target := blockchain.CompactToBig(bits)
+	for ; header.Nonce < 1<<20; header.Nonce++ {
+		hash := header.BlockHash()
+		if (blockchain.HashToBig(&hash).Cmp(target) <= 0) == valid {
+			return header
+		}
+	}
+
+	t.Fatal("could not mine header")
+	return header
+}
+
+// testChain mines a valid chain on top of the genesis block, with the given delays between blocks
+func testChain(t *testing.T, params *chaincfg.Params, delays ...time.Duration) []wire.BlockHeader {
+	t.Helper()
+
+	headers := []wire.BlockHeader{params.GenesisBlock.Header}
+	for _, delay := range delays {
+		prev := &headers[len(headers)-1]
+		next := wire.BlockHeader{Timestamp: prev.Timestamp.Add(delay)}
+		headers = append(headers, mineHeader(t, prev, delay, requiredBits(0, headers, &next, params), true))
+	}
+
+	return headers
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	var txids [4]chainhash.Hash
+	for i := range txids {
+		txids[i] = chainhash.DoubleHashH([]byte{byte(i)})
+	}
+
+	left := chainhash.DoubleHashH(append(txids[0][:], txids[1][:]...))
+	right := chainhash.DoubleHashH(append(txids[2][:], txids[3][:]...))
+
+	header := wire.BlockHeader{
+		Version:    4,
+		MerkleRoot: chainhash.DoubleHashH(append(left[:], right[:]...)),
+		Timestamp:  time.Unix(1600000000, 0),
+		Bits:       chaincfg.RegressionNetParams.PowLimitBits,
+	}
+
+	target := blockchain.CompactToBig(header.Bits)
+	for hash := header.BlockHash(); blockchain.HashToBig(&hash).Cmp(target) > 0; hash = header.BlockHash() {
+		header.Nonce++
+	}
+
+	tests := []struct {
+		name     string
+		txid     chainhash.Hash
+		branch   []chainhash.Hash
+		position uint32
+		valid    bool
+	}{
+		{"first", txids[0], []chainhash.Hash{txids[1], right}, 0, true},
+		{"third", txids[2], []chainhash.Hash{txids[3], left}, 2, true},
+		{"last", txids[3], []chainhash.Hash{txids[2], left}, 3, true},
+		{"wrong position", txids[2], []chainhash.Hash{txids[3], left}, 0, false},
+		{"leftover position bits", txids[2], []chainhash.Hash{txids[3], left}, 6, false},
+		{"wrong sibling", txids[2], []chainhash.Hash{txids[1], left}, 2, false},
+		{"short branch", txids[2], []chainhash.Hash{txids[3]}, 0, false},
+		{"branch too long", txids[0], make([]chainhash.Hash, 33), 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyInclusion(test.txid, test.branch, test.position, &header, &chaincfg.RegressionNetParams)
+			if (err == nil) != test.valid {
+				t.Fatalf("err = %v, valid = %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestCheckProofOfWork(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	genesis := &params.GenesisBlock.Header
+
+	tests := []struct {
+		name   string
+		header wire.BlockHeader
+		params *chaincfg.Params
+		valid  bool
+	}{
+		{"mined", mineHeader(t, genesis, time.Minute, params.PowLimitBits, true), params, true},
+		{"above target", mineHeader(t, genesis, time.Minute, params.PowLimitBits, false), params, false},
+		{"target above limit", mineHeader(t, genesis, time.Minute, params.PowLimitBits, true), &chaincfg.MainNetParams, false},
+		{"zero target", mineHeader(t, genesis, time.Minute, 0, false), params, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckProofOfWork(&test.header, test.params)
+			if (err == nil) != test.valid {
+				t.Fatalf("err = %v, valid = %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestAnchor(t *testing.T) {
+	params := chaincfg.RegressionNetParams
+	params.Checkpoints = []chaincfg.Checkpoint{{Height: 3000}, {Height: 5000}}
+
+	tests := []struct {
+		height int32
+		anchor int32
+	}{
+		{0, 0},
+		{2999, 0},
+		{3000, 2016},
+		{4999, 2016},
+		{5000, 4032},
+		{100000, 4032},
+	}
+
+	for _, test := range tests {
+		if anchor := Anchor(test.height, &params); anchor != test.anchor {
+			t.Errorf("Anchor(%d) = %d, want %d", test.height, anchor, test.anchor)
+		}
+	}
+
+	// Networks without checkpoints are verified from the genesis block
+	if anchor := Anchor(100000, &chaincfg.RegressionNetParams); anchor != 0 {
+		t.Errorf("Anchor = %d, want 0", anchor)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	regtest := &chaincfg.RegressionNetParams
+	retarget := retargetParams()
+	minute, hour := time.Minute, time.Hour
+
+	// Blocks found 4 times faster than expected divide the target by 4
+	fast := testChain(t, retarget, minute, minute, minute, minute, minute)
+	quarter := blockchain.BigToCompact(new(big.Int).Div(retarget.PowLimit, big.NewInt(4)))
+	if fast[4].Bits != quarter {
+		t.Fatalf("bits = %x, want %x", fast[4].Bits, quarter)
+	}
+
+	// Testnet allows minimum difficulty blocks after a long delay, then goes back to the difficulty of the period
+	testnet := retargetParams()
+	testnet.ReduceMinDifficulty = true
+	slow := testChain(t, testnet, minute, minute, minute, minute, hour, minute)
+	if slow[5].Bits != testnet.PowLimitBits || slow[6].Bits != quarter {
+		t.Fatalf("bits = %x, %x", slow[5].Bits, slow[6].Bits)
+	}
+
+	// replace mines another header at the end of a chain
+	replace := func(headers []wire.BlockHeader, delay time.Duration, bits uint32, valid bool) []wire.BlockHeader {
+		chain := append([]wire.BlockHeader{}, headers[:len(headers)-1]...)
+		return append(chain, mineHeader(t, &chain[len(chain)-1], delay, bits, valid))
+	}
+
+	unlinked := testChain(t, regtest, minute, minute, minute)
+	unlinked[2].PrevBlock = chainhash.Hash{}
+
+	checkpointed := *regtest
+	checkpointed.Checkpoints = []chaincfg.Checkpoint{{Height: 2, Hash: &chainhash.Hash{}}}
+
+	tests := []struct {
+		name    string
+		start   int32
+		headers []wire.BlockHeader
+		params  *chaincfg.Params
+		valid   bool
+	}{
+		{"regtest", 0, testChain(t, regtest, minute, minute, minute), regtest, true},
+		{"retarget", 0, fast, retarget, true},
+		{"minimum difficulty", 0, slow, testnet, true},
+		{"empty", 0, nil, regtest, false},
+		{"unlinked", 0, unlinked, regtest, false},
+		{"above target", 0, replace(testChain(t, regtest, minute, minute), minute, regtest.PowLimitBits, false), regtest, false},
+		{"regtest difficulty change", 0, replace(testChain(t, regtest, minute, minute), minute, quarter, true), regtest, false},
+		{"missing retarget", 0, replace(fast[:5], minute, retarget.PowLimitBits, true), retarget, false},
+		{"wrong retarget", 0, replace(fast[:5], minute, quarter-1, true), retarget, false},
+		{"minimum difficulty on mainnet rules", 0, slow[:6], retarget, false},
+		{"minimum difficulty without delay", 0, replace(slow[:6], minute, testnet.PowLimitBits, true), testnet, false},
+		{"minimum difficulty kept", 0, replace(slow, minute, testnet.PowLimitBits, true), testnet, false},
+		{"not a retarget height", 1, testChain(t, regtest, minute, minute)[1:], regtest, false},
+		{"no checkpoint", 2016, testChain(t, regtest, minute, minute)[1:], regtest, false},
+		{"not genesis", 0, testChain(t, regtest, minute, minute)[1:], regtest, false},
+		{"checkpoint mismatch", 0, testChain(t, regtest, minute, minute, minute), &checkpointed, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyChain(test.start, test.headers, test.params)
+			if (err == nil) != test.valid {
+				t.Fatalf("err = %v, valid = %v", err, test.valid)
+			}
+		})
+	}
+
+	// Chains starting after the genesis block must include a checkpoint
+	chain := testChain(t, regtest, minute, minute, minute)
+	hash := chain[2].BlockHash()
+	checkpointed.Checkpoints = []chaincfg.Checkpoint{{Height: 2018, Hash: &hash}}
+	if err := VerifyChain(2016, chain, &checkpointed); err != nil {
+		t.Fatal(err)
+	}
+}
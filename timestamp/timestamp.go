@@ -0,0 +1,180 @@
+package timestamp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// recordMagic identifies a timestamp record among retrieved data
+var recordMagic = []byte("BCTS")
+
+// Version is the current version of the timestamp record and proof formats
+const Version = 1
+
+// Leaves and nodes are hashed with different prefixes so that a node cannot be passed off as a file
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// Step is a sibling hash on the path from a leaf to the root
+type Step struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// Proof proves that a file hash is committed by a root injected in a transaction
+type Proof struct {
+	Version int    `json:"version"`
+	Network string `json:"network"`
+	TxID    string `json:"txid"`
+	Root    string `json:"root"`
+	Name    string `json:"name"`
+	SHA256  string `json:"sha256"`
+	Path    []Step `json:"path"`
+}
+
+// Tree builds a Merkle tree over file hashes and returns its root and the path of every file
+// Nodes without a sibling are promoted to the next level as is
+func Tree(hashes [][sha256.Size]byte) ([sha256.Size]byte, [][]Step) {
+	paths := make([][]Step, len(hashes))
+
+	// Track which leaves are below each node of the current level
+	level := make([][sha256.Size]byte, len(hashes))
+	leaves := make([][]int, len(hashes))
+	for i, hash := range hashes {
+		level[i] = hashLeaf(hash)
+		leaves[i] = []int{i}
+	}
+
+	for len(level) > 1 {
+		var nextLevel [][sha256.Size]byte
+		var nextLeaves [][]int
+
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				nextLevel = append(nextLevel, level[i])
+				nextLeaves = append(nextLeaves, leaves[i])
+				continue
+			}
+
+			for _, leaf := range leaves[i] {
+				paths[leaf] = append(paths[leaf], Step{Hash: hex.EncodeToString(level[i+1][:]), Left: false})
+			}
+			for _, leaf := range leaves[i+1] {
+				paths[leaf] = append(paths[leaf], Step{Hash: hex.EncodeToString(level[i][:]), Left: true})
+			}
+
+			nextLevel = append(nextLevel, hashNode(level[i], level[i+1]))
+			nextLeaves = append(nextLeaves, append(leaves[i], leaves[i+1]...))
+		}
+
+		level = nextLevel
+		leaves = nextLeaves
+	}
+
+	return level[0], paths
+}
+
+// RootFromPath computes the root committing to a file hash
+func RootFromPath(hash [sha256.Size]byte, path []Step) ([sha256.Size]byte, error) {
+	node := hashLeaf(hash)
+
+	for _, step := range path {
+		b, err := hex.DecodeString(step.Hash)
+		if err != nil || len(b) != sha256.Size {
+			return node, errors.New("invalid proof path")
+		}
+
+		var sibling [sha256.Size]byte
+		copy(sibling[:], b)
+
+		if step.Left {
+			node = hashNode(sibling, node)
+		} else {
+			node = hashNode(node, sibling)
+		}
+	}
+
+	return node, nil
+}
+
+// Verify checks that the proof commits to the hash of a file
+func (p *Proof) Verify(hash [sha256.Size]byte) error {
+	if p.Version != Version {
+		return errors.New("unsupported proof version")
+	}
+
+	if hex.EncodeToString(hash[:]) != p.SHA256 {
+		return errors.New("file hash mismatch")
+	}
+
+	root, err := RootFromPath(hash, p.Path)
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(root[:]) != p.Root {
+		return errors.New("root mismatch")
+	}
+
+	return nil
+}
+
+// EncodeRecord serializes the root injected on chain
+// Format: magic (4 bytes) | version (1 byte) | number of files (varint) | root (32 bytes)
+func EncodeRecord(root [sha256.Size]byte, count int) []byte {
+	var buf bytes.Buffer
+
+	buf.Write(recordMagic)
+	buf.WriteByte(Version)
+	_ = wire.WriteVarInt(&buf, 0, uint64(count))
+	buf.Write(root[:])
+
+	return buf.Bytes()
+}
+
+// DecodeRecord parses a timestamp record and returns its root
+func DecodeRecord(data []byte) ([sha256.Size]byte, uint64, error) {
+	var root [sha256.Size]byte
+
+	if len(data) < len(recordMagic) || !bytes.Equal(data[:len(recordMagic)], recordMagic) {
+		return root, 0, errors.New("not a timestamp record")
+	}
+
+	r := bytes.NewReader(data[len(recordMagic):])
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return root, 0, errors.New("truncated timestamp record")
+	}
+
+	if version != Version {
+		return root, 0, errors.New("unsupported timestamp record version")
+	}
+
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return root, 0, errors.New("truncated timestamp record")
+	}
+
+	_, err = io.ReadFull(r, root[:])
+	if err != nil {
+		return root, 0, errors.New("truncated timestamp record")
+	}
+
+	return root, count, nil
+}
+
+func hashLeaf(hash [sha256.Size]byte) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte{leafPrefix}, hash[:]...))
+}
+
+func hashNode(left, right [sha256.Size]byte) [sha256.Size]byte {
+	return sha256.Sum256(append(append([]byte{nodePrefix}, left[:]...), right[:]...))
+}
@@ -0,0 +1,134 @@
+package timestamp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func testHashes(n int) [][sha256.Size]byte {
+	hashes := make([][sha256.Size]byte, n)
+	for i := range hashes {
+		hashes[i] = sha256.Sum256([]byte{byte(i)})
+	}
+	return hashes
+}
+
+func testProof(root [sha256.Size]byte, hash [sha256.Size]byte, path []Step) *Proof {
+	return &Proof{
+		Version: Version,
+		Root:    hex.EncodeToString(root[:]),
+		SHA256:  hex.EncodeToString(hash[:]),
+		Path:    path,
+	}
+}
+
+func TestTreeProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		hashes := testHashes(n)
+		root, paths := Tree(hashes)
+
+		if len(paths) != n {
+			t.Fatalf("%d files: %d paths", n, len(paths))
+		}
+
+		for i, hash := range hashes {
+			if err := testProof(root, hash, paths[i]).Verify(hash); err != nil {
+				t.Fatalf("%d files, file %d: %v", n, i, err)
+			}
+		}
+	}
+}
+
+func TestTreeSingleFile(t *testing.T) {
+	hash := sha256.Sum256([]byte("file"))
+	root, paths := Tree([][sha256.Size]byte{hash})
+
+	if root != hashLeaf(hash) || len(paths[0]) != 0 {
+		t.Fatal("the root of a single file is its leaf")
+	}
+}
+
+func TestProofInvalid(t *testing.T) {
+	hashes := testHashes(5)
+	root, paths := Tree(hashes)
+	hash := hashes[2]
+
+	tamperedPath := append([]Step{}, paths[2]...)
+	tamperedPath[0].Left = !tamperedPath[0].Left
+
+	otherRoot := sha256.Sum256([]byte("other root"))
+
+	tests := []struct {
+		name  string
+		proof *Proof
+	}{
+		{"unknown version", &Proof{Version: Version + 1, Root: hex.EncodeToString(root[:]), SHA256: hex.EncodeToString(hash[:]), Path: paths[2]}},
+		{"other file", testProof(root, hashes[3], paths[2])},
+		{"path of another file", testProof(root, hash, paths[3])},
+		{"tampered path", testProof(root, hash, tamperedPath)},
+		{"invalid step", testProof(root, hash, []Step{{Hash: "zz"}})},
+		{"short step", testProof(root, hash, []Step{{Hash: "00"}})},
+		{"other root", testProof(otherRoot, hash, paths[2])},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.proof.Verify(hash) == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestProofNodeAsFile(t *testing.T) {
+	// An inner node must not verify as a file hash with a shortened path
+	hashes := testHashes(4)
+	root, paths := Tree(hashes)
+	node := hashNode(hashLeaf(hashes[0]), hashLeaf(hashes[1]))
+
+	if testProof(root, node, paths[0][1:]).Verify(node) == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	root := sha256.Sum256([]byte("root"))
+
+	for _, count := range []int{1, 300, 70000} {
+		decoded, decodedCount, err := DecodeRecord(EncodeRecord(root, count))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded != root || decodedCount != uint64(count) {
+			t.Fatalf("decoded %x %d, want %x %d", decoded, decodedCount, root, count)
+		}
+	}
+}
+
+func TestDecodeRecordInvalid(t *testing.T) {
+	record := EncodeRecord(sha256.Sum256([]byte("root")), 3)
+
+	unknownVersion := append([]byte{}, record...)
+	unknownVersion[len(recordMagic)] = Version + 1
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a record", []byte("hello world")},
+		{"magic only", recordMagic},
+		{"unknown version", unknownVersion},
+		{"no count", record[:len(recordMagic)+1]},
+		{"truncated root", record[:len(record)-1]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := DecodeRecord(test.data); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}